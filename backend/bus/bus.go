@@ -0,0 +1,48 @@
+// Package bus はWebSocketイベントをサーバーの全レプリカに配る（ファンアウト）。
+// 各レプリカは受け取ったイベントを自分に接続しているユーザーにだけ届ける。
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// イベントの種類
+const (
+	KindUsers = "users" // Seqs のユーザーに送る（ルーム宛ても、送る側がメンバーを展開して Seqs に入れる）
+	KindJoin  = "join"  // UserIDs を RoomID の購読者にする
+	// UserIDs が RoomID で入力を始めた・続けている（Typing=true）、やめた（false）。
+	// 各レプリカが入力中の状態を持ち、まとめてから接続に送る
+	KindTyping = "typing"
+)
+
+// Event はレプリカ間で受け渡すイベント。Body は protocol.Marshal したイベント本体。
+type Event struct {
+	Kind    string          `json:"kind"`
+	Type    string          `json:"type,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Seqs    map[int]int64   `json:"seqs,omitempty"`     // 宛先ユーザー → seq（記録に失敗したユーザーは 0）
+	RoomID  int             `json:"room_id,omitempty"`  // KindJoin / KindTyping の対象ルーム
+	UserIDs []int           `json:"user_ids,omitempty"` // KindJoin で参加した・KindTyping で入力中のユーザー
+	Typing  bool            `json:"typing,omitempty"`
+	// Ref が true なら Body を省いている（大きすぎて送れなかった）。受け手がイベントログから読む
+	Ref bool `json:"ref,omitempty"`
+}
+
+// ErrTooLarge は Event が大きすぎてそのままでは送れないときに返す
+var ErrTooLarge = errors.New("bus: event too large")
+
+// Bus はレプリカ間のイベント配送。自分が Publish したイベントも自分の購読者に届く。
+type Bus interface {
+	// Publish は e を全レプリカに送る
+	Publish(e Event) error
+	// Subscribe は受け取ったイベントを onEvent に渡す。
+	// 配送が途切れてイベントを取りこぼした可能性があるときは onGap を呼ぶ。
+	Subscribe(onEvent func(Event), onGap func())
+	Close() error
+}
+
+type subscriber struct {
+	onEvent func(Event)
+	onGap   func()
+}
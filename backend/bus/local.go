@@ -0,0 +1,37 @@
+package bus
+
+import "sync"
+
+// Local は同じプロセス内だけで配る Bus（レプリカ1台のとき用）。
+// 複数の購読者を持てるので、1プロセス内に2つのハブを立てて配送を確かめることもできる。
+type Local struct {
+	mu   sync.RWMutex
+	subs []subscriber
+}
+
+// NewLocal は購読者のいない Local を作る
+func NewLocal() *Local {
+	return &Local{}
+}
+
+// Publish は全購読者に e をその場で渡す（Publish の順に届く）
+func (b *Local) Publish(e Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		s.onEvent(e)
+	}
+	return nil
+}
+
+// Subscribe は購読者を追加する。Local は取りこぼさないので onGap は呼ばれない。
+func (b *Local) Subscribe(onEvent func(Event), onGap func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, subscriber{onEvent: onEvent, onGap: onGap})
+}
+
+// Close は何もしない
+func (b *Local) Close() error {
+	return nil
+}
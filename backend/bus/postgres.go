@@ -0,0 +1,123 @@
+package bus
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// NOTIFY するチャンネル名（同じDBを使う全レプリカで共通）
+	notifyChannel = "chat_events"
+	// NOTIFY のペイロードは8000バイト未満でなければならない
+	maxNotifyPayload = 7900
+	// 接続が生きているかを確かめる間隔
+	listenerPingInterval = 90 * time.Second
+)
+
+// Postgres は LISTEN/NOTIFY でレプリカ間にイベントを配る Bus。
+// 送信は通常の接続プールから pg_notify で行い、受信は専用の接続（pq.Listener）で待つ。
+type Postgres struct {
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+
+	mu   sync.RWMutex
+	subs []subscriber
+}
+
+// NewPostgres は dsn に LISTEN 用の接続を張り、受信ループを起動する
+func NewPostgres(db *sql.DB, dsn string) (*Postgres, error) {
+	listener := pq.NewListener(dsn, 100*time.Millisecond, 10*time.Second, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("⚠️ LISTEN接続のエラー: event=%d err=%v", ev, err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("LISTEN %s に失敗: %w", notifyChannel, err)
+	}
+
+	b := &Postgres{db: db, listener: listener, done: make(chan struct{})}
+	go b.loop()
+	return b, nil
+}
+
+// Publish は e を pg_notify で送る。大きすぎる場合は ErrTooLarge を返す。
+func (b *Postgres) Publish(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrTooLarge
+	}
+	_, err = b.db.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+// Subscribe は購読者を追加する
+func (b *Postgres) Subscribe(onEvent func(Event), onGap func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, subscriber{onEvent: onEvent, onGap: onGap})
+}
+
+// Close は受信ループを止め、LISTEN 用の接続を閉じる
+func (b *Postgres) Close() error {
+	close(b.done)
+	return b.listener.Close()
+}
+
+func (b *Postgres) loop() {
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// 再接続した: 切断中の通知は届かないので、購読者に取りこぼしを知らせる
+				log.Println("⚠️ LISTEN接続を張り直しました（切断中のイベントは再同期します）")
+				b.gap()
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("❌ NOTIFY のペイロードを解析できません: %v", err)
+				continue
+			}
+			b.dispatch(e)
+		case <-ticker.C:
+			go b.listener.Ping()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Postgres) dispatch(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		s.onEvent(e)
+	}
+}
+
+func (b *Postgres) gap() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		if s.onGap != nil {
+			s.onGap()
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 実行環境
+const (
+	EnvDev  = "dev"
+	EnvTest = "test"
+	EnvProd = "prod"
+)
+
+// DBConfig はPostgreSQLの接続設定
+type DBConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode"`
+}
+
+// DSN は lib/pq 用の接続文字列を返す
+func (d DBConfig) DSN() string {
+	return fmt.Sprintf(
+		"user=%s dbname=%s password=%s host=%s port=%d sslmode=%s",
+		d.User, d.Name, d.Password, d.Host, d.Port, d.SSLMode,
+	)
+}
+
+// WSConfig はWebSocket接続の設定
+type WSConfig struct {
+	PingInterval   time.Duration `yaml:"ping_interval" toml:"ping_interval"`       // サーバーから ping を送る間隔
+	PongWait       time.Duration `yaml:"pong_wait" toml:"pong_wait"`               // この間に pong（または何かのメッセージ）が届かなければ切断する
+	WriteWait      time.Duration `yaml:"write_wait" toml:"write_wait"`             // 1回の書き込みにかけられる時間
+	MaxMessageSize int64         `yaml:"max_message_size" toml:"max_message_size"` // クライアントから受け付ける1メッセージの最大バイト数
+	Compression    bool          `yaml:"compression" toml:"compression"`           // permessage-deflate を受け入れる（クライアントが対応していれば圧縮する）
+	BatchWindow    time.Duration `yaml:"batch_window" toml:"batch_window"`         // /ws?batch=1 の接続で、最初のイベントからこの間に届いたイベントを1フレームに束ねる（0なら束ねない）
+}
+
+// ストレージの種類
+const (
+	StorePostgres = "postgres"
+	StoreMemory   = "memory" // DBなしで動かす（開発・テスト用、再起動で消える）
+)
+
+// WebSocketイベントをレプリカ間で配るバスの種類
+const (
+	BusLocal    = "local"    // 同じプロセス内だけで配る（レプリカ1台のとき）
+	BusPostgres = "postgres" // PostgreSQL の LISTEN/NOTIFY で全レプリカに配る
+)
+
+// Config はバックエンド全体の設定
+type Config struct {
+	Env            string   `yaml:"env" toml:"env"`
+	Store          string   `yaml:"store" toml:"store"`
+	Addr           string   `yaml:"addr" toml:"addr"`                       // 待ち受けアドレス (例: ":8080")
+	PublicURL      string   `yaml:"public_url" toml:"public_url"`           // 画像URLなど外部に返すURLのベース
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"` // CORS / WebSocket で許可するオリジン
+	JWTSecret      string   `yaml:"jwt_secret" toml:"jwt_secret"`
+	DB             DBConfig `yaml:"db" toml:"db"`
+	WS             WSConfig `yaml:"ws" toml:"ws"`
+	Bus            string   `yaml:"bus" toml:"bus"` // 複数台で動かすときは postgres にする
+
+	Admins           []string      `yaml:"admins" toml:"admins"`                       // 管理者のユーザー名（削除メッセージの復元など）
+	DeletedRetention time.Duration `yaml:"deleted_retention" toml:"deleted_retention"` // 削除メッセージを復元できる期間。過ぎると本文を消去する
+
+	EventRetention time.Duration `yaml:"event_retention" toml:"event_retention"` // WebSocketイベントを再接続時の再送用に残す期間
+
+	// true なら、フォローしていないスレッドの返信はルームの未読数に数えない
+	UnreadFollowedThreadsOnly bool `yaml:"unread_followed_threads_only" toml:"unread_followed_threads_only"`
+
+	TimeZone string `yaml:"time_zone" toml:"time_zone"` // 検索の after:/before: の日付を解釈するタイムゾーン（IANA名。リクエストの tz が優先）
+}
+
+// IsAdmin は username が管理者かどうか
+func (c *Config) IsAdmin(username string) bool {
+	for _, a := range c.Admins {
+		if a == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Location は TimeZone を読み込む（Validate 済みなら失敗しない。空なら UTC）
+func (c *Config) Location() (*time.Location, error) {
+	return time.LoadLocation(c.TimeZone)
+}
+
+// Defaults は環境ごとのデフォルト値を返す。prod では秘密情報のデフォルトを持たない。
+func Defaults(env string) *Config {
+	c := &Config{
+		Env:                       env,
+		Store:                     StorePostgres,
+		Bus:                       BusLocal,
+		Addr:                      ":8080",
+		PublicURL:                 "http://localhost:8080",
+		AllowedOrigins:            []string{"http://localhost:3001"},
+		JWTSecret:                 "dev-secret-key",
+		DeletedRetention:          30 * 24 * time.Hour,
+		EventRetention:            24 * time.Hour,
+		UnreadFollowedThreadsOnly: true,
+		TimeZone:                  "Asia/Tokyo",
+		DB: DBConfig{
+			Host:     "db",
+			Port:     5432,
+			User:     "chatuser",
+			Password: "password",
+			Name:     "chat_app_db",
+			SSLMode:  "disable",
+		},
+		WS: WSConfig{
+			PingInterval:   30 * time.Second,
+			PongWait:       60 * time.Second,
+			WriteWait:      10 * time.Second,
+			MaxMessageSize: 64 * 1024,
+			Compression:    true,
+			BatchWindow:    20 * time.Millisecond,
+		},
+	}
+
+	switch env {
+	case EnvTest:
+		c.JWTSecret = "test-secret-key"
+		c.DB.Host = "localhost"
+		c.DB.Name = "chat_app_test"
+	case EnvProd:
+		c.PublicURL = ""
+		c.AllowedOrigins = nil
+		c.JWTSecret = ""
+		c.DB.Password = ""
+		c.DB.SSLMode = "require"
+	}
+	return c
+}
+
+// Validate は設定値を検証する。prod では秘密情報が未設定なら起動させない。
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Env {
+	case EnvDev, EnvTest, EnvProd:
+	default:
+		errs = append(errs, fmt.Errorf("env は dev/test/prod のいずれかです: %q", c.Env))
+	}
+	switch c.Store {
+	case StorePostgres:
+	case StoreMemory:
+		if c.Env == EnvProd {
+			errs = append(errs, errors.New("prod では store=memory は使えません"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("store は postgres/memory のいずれかです: %q", c.Store))
+	}
+	switch c.Bus {
+	case BusLocal:
+	case BusPostgres:
+		if c.Store != StorePostgres {
+			errs = append(errs, errors.New("bus=postgres は store=postgres のときだけ使えます"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("bus は local/postgres のいずれかです: %q", c.Bus))
+	}
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr が未設定です"))
+	}
+	if c.PublicURL == "" {
+		errs = append(errs, errors.New("public_url が未設定です"))
+	}
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("allowed_origins が未設定です"))
+	}
+	if c.DB.Host == "" || c.DB.User == "" || c.DB.Name == "" {
+		errs = append(errs, errors.New("db.host / db.user / db.name は必須です"))
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		errs = append(errs, fmt.Errorf("db.port が不正です: %d", c.DB.Port))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("jwt_secret が未設定です"))
+	}
+	if c.DeletedRetention <= 0 {
+		errs = append(errs, fmt.Errorf("deleted_retention は正の期間である必要があります: %v", c.DeletedRetention))
+	}
+	if c.EventRetention <= 0 {
+		errs = append(errs, fmt.Errorf("event_retention は正の期間である必要があります: %v", c.EventRetention))
+	}
+	if _, err := c.Location(); c.TimeZone == "" || err != nil {
+		errs = append(errs, fmt.Errorf("time_zone が不正です（例: Asia/Tokyo, UTC）: %q", c.TimeZone))
+	}
+	if c.WS.PingInterval <= 0 || c.WS.PongWait <= 0 || c.WS.WriteWait <= 0 {
+		errs = append(errs, errors.New("ws.ping_interval / ws.pong_wait / ws.write_wait は正の期間である必要があります"))
+	} else if c.WS.PingInterval >= c.WS.PongWait {
+		errs = append(errs, fmt.Errorf("ws.ping_interval (%v) は ws.pong_wait (%v) より短くしてください", c.WS.PingInterval, c.WS.PongWait))
+	}
+	if c.WS.MaxMessageSize <= 0 {
+		errs = append(errs, fmt.Errorf("ws.max_message_size は正の値である必要があります: %d", c.WS.MaxMessageSize))
+	}
+	if c.WS.BatchWindow < 0 || c.WS.BatchWindow > time.Second {
+		errs = append(errs, fmt.Errorf("ws.batch_window は0〜1sの期間である必要があります: %v", c.WS.BatchWindow))
+	}
+
+	if c.Env == EnvProd {
+		if c.JWTSecret != "" && len(c.JWTSecret) < 32 {
+			errs = append(errs, errors.New("prod の jwt_secret は32文字以上の固有値が必要です"))
+		}
+		if c.DB.Password == "" {
+			errs = append(errs, errors.New("prod では db.password が必須です"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+var (
+	current *Config
+	mu      sync.RWMutex
+)
+
+// Set はアプリ全体で使う設定を登録する
+func Set(c *Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+}
+
+// Get は登録済みの設定を返す。未登録なら dev のデフォルト値を返す。
+func Get() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return Defaults(EnvDev)
+	}
+	return current
+}
+
+// Load は デフォルト値 → 設定ファイル → 環境変数 → フラグ の順に上書きして設定を作る。
+// 戻り値の []string はフラグ以外の残りの引数（サブコマンドなど）。
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("backend", flag.ContinueOnError)
+	envFlag := fs.String("env", "", "実行環境 (dev/test/prod) [CHAT_ENV]")
+	fileFlag := fs.String("config", "", "設定ファイル (.yaml/.yml/.toml) [CHAT_CONFIG]")
+	storeKind := fs.String("store", "", "ストレージ (postgres/memory) [CHAT_STORE]")
+	busKind := fs.String("bus", "", "レプリカ間のイベント配送 (local/postgres) [CHAT_BUS]")
+	addr := fs.String("addr", "", "待ち受けアドレス [CHAT_ADDR]")
+	publicURL := fs.String("public-url", "", "外部公開URL [CHAT_PUBLIC_URL]")
+	origins := fs.String("allowed-origins", "", "許可するオリジン（カンマ区切り） [CHAT_ALLOWED_ORIGINS]")
+	jwtSecret := fs.String("jwt-secret", "", "JWT署名キー [CHAT_JWT_SECRET]")
+	dbHost := fs.String("db-host", "", "DBホスト [CHAT_DB_HOST]")
+	dbPort := fs.Int("db-port", 0, "DBポート [CHAT_DB_PORT]")
+	dbUser := fs.String("db-user", "", "DBユーザー [CHAT_DB_USER]")
+	dbPassword := fs.String("db-password", "", "DBパスワード [CHAT_DB_PASSWORD]")
+	dbName := fs.String("db-name", "", "DB名 [CHAT_DB_NAME]")
+	dbSSLMode := fs.String("db-sslmode", "", "DB sslmode [CHAT_DB_SSLMODE]")
+	admins := fs.String("admins", "", "管理者のユーザー名（カンマ区切り） [CHAT_ADMINS]")
+	unreadFollowed := fs.Bool("unread-followed-threads-only", false, "フォロー外のスレッド返信を未読数に数えない [CHAT_UNREAD_FOLLOWED_THREADS_ONLY]")
+	deletedRetention := fs.Duration("deleted-retention", 0, "削除メッセージの保持期間 (例: 720h) [CHAT_DELETED_RETENTION]")
+	timeZone := fs.String("time-zone", "", "検索の日付を解釈するタイムゾーン (例: Asia/Tokyo) [CHAT_TIME_ZONE]")
+	eventRetention := fs.Duration("event-retention", 0, "WebSocketイベントの再送用の保持期間 (例: 24h) [CHAT_EVENT_RETENTION]")
+	wsPingInterval := fs.Duration("ws-ping-interval", 0, "WebSocket の ping 間隔 [CHAT_WS_PING_INTERVAL]")
+	wsPongWait := fs.Duration("ws-pong-wait", 0, "WebSocket の pong 待ち時間 [CHAT_WS_PONG_WAIT]")
+	wsWriteWait := fs.Duration("ws-write-wait", 0, "WebSocket の書き込みタイムアウト [CHAT_WS_WRITE_WAIT]")
+	wsMaxMessageSize := fs.Int64("ws-max-message-size", 0, "WebSocket の最大受信メッセージサイズ（バイト） [CHAT_WS_MAX_MESSAGE_SIZE]")
+	wsCompression := fs.Bool("ws-compression", false, "WebSocket の permessage-deflate を受け入れる [CHAT_WS_COMPRESSION]")
+	wsBatchWindow := fs.Duration("ws-batch-window", 0, "WebSocket のイベントを束ねる待ち時間（0で束ねない） [CHAT_WS_BATCH_WINDOW]")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	env := firstNonEmpty(*envFlag, os.Getenv("CHAT_ENV"), EnvDev)
+	c := Defaults(env)
+
+	// 設定ファイル
+	if path := firstNonEmpty(*fileFlag, os.Getenv("CHAT_CONFIG")); path != "" {
+		if err := loadFile(path, c); err != nil {
+			return nil, nil, err
+		}
+		// ファイル側で env を変えられると既定値の前提が崩れるので、明示指定を優先する
+		if *envFlag != "" || os.Getenv("CHAT_ENV") != "" {
+			c.Env = env
+		}
+	}
+
+	// 環境変数
+	if err := applyEnv(c); err != nil {
+		return nil, nil, err
+	}
+
+	// フラグ（明示的に指定されたものだけ）
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "store":
+			c.Store = *storeKind
+		case "bus":
+			c.Bus = *busKind
+		case "addr":
+			c.Addr = *addr
+		case "public-url":
+			c.PublicURL = *publicURL
+		case "allowed-origins":
+			c.AllowedOrigins = splitList(*origins)
+		case "jwt-secret":
+			c.JWTSecret = *jwtSecret
+		case "db-host":
+			c.DB.Host = *dbHost
+		case "db-port":
+			c.DB.Port = *dbPort
+		case "db-user":
+			c.DB.User = *dbUser
+		case "db-password":
+			c.DB.Password = *dbPassword
+		case "db-name":
+			c.DB.Name = *dbName
+		case "db-sslmode":
+			c.DB.SSLMode = *dbSSLMode
+		case "admins":
+			c.Admins = splitList(*admins)
+		case "deleted-retention":
+			c.DeletedRetention = *deletedRetention
+		case "unread-followed-threads-only":
+			c.UnreadFollowedThreadsOnly = *unreadFollowed
+		case "time-zone":
+			c.TimeZone = *timeZone
+		case "event-retention":
+			c.EventRetention = *eventRetention
+		case "ws-ping-interval":
+			c.WS.PingInterval = *wsPingInterval
+		case "ws-pong-wait":
+			c.WS.PongWait = *wsPongWait
+		case "ws-write-wait":
+			c.WS.WriteWait = *wsWriteWait
+		case "ws-max-message-size":
+			c.WS.MaxMessageSize = *wsMaxMessageSize
+		case "ws-compression":
+			c.WS.Compression = *wsCompression
+		case "ws-batch-window":
+			c.WS.BatchWindow = *wsBatchWindow
+		}
+	})
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("設定エラー:\n%v", err)
+	}
+	return c, fs.Args(), nil
+}
+
+func loadFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("設定ファイルの読み込みに失敗: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("未対応の設定ファイル形式です: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("設定ファイルの解析に失敗 (%s): %v", path, err)
+	}
+	return nil
+}
+
+func applyEnv(c *Config) error {
+	setString := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+
+	setString("CHAT_STORE", &c.Store)
+	setString("CHAT_BUS", &c.Bus)
+	setString("CHAT_ADDR", &c.Addr)
+	setString("CHAT_PUBLIC_URL", &c.PublicURL)
+	setString("CHAT_JWT_SECRET", &c.JWTSecret)
+	setString("CHAT_DB_HOST", &c.DB.Host)
+	setString("CHAT_DB_USER", &c.DB.User)
+	setString("CHAT_DB_PASSWORD", &c.DB.Password)
+	setString("CHAT_DB_NAME", &c.DB.Name)
+	setString("CHAT_DB_SSLMODE", &c.DB.SSLMode)
+	setString("CHAT_TIME_ZONE", &c.TimeZone)
+
+	if v, ok := os.LookupEnv("CHAT_ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CHAT_DB_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_DB_PORT が数値ではありません: %q", v)
+		}
+		c.DB.Port = port
+	}
+	if v, ok := os.LookupEnv("CHAT_ADMINS"); ok {
+		c.Admins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CHAT_DELETED_RETENTION"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_DELETED_RETENTION が期間の形式ではありません: %q", v)
+		}
+		c.DeletedRetention = d
+	}
+	if v, ok := os.LookupEnv("CHAT_UNREAD_FOLLOWED_THREADS_ONLY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_UNREAD_FOLLOWED_THREADS_ONLY は true/false で指定してください: %q", v)
+		}
+		c.UnreadFollowedThreadsOnly = b
+	}
+	for key, dst := range map[string]*time.Duration{
+		"CHAT_EVENT_RETENTION":  &c.EventRetention,
+		"CHAT_WS_PING_INTERVAL": &c.WS.PingInterval,
+		"CHAT_WS_PONG_WAIT":     &c.WS.PongWait,
+		"CHAT_WS_WRITE_WAIT":    &c.WS.WriteWait,
+		"CHAT_WS_BATCH_WINDOW":  &c.WS.BatchWindow,
+	} {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s が期間の形式ではありません: %q", key, v)
+			}
+			*dst = d
+		}
+	}
+	if v, ok := os.LookupEnv("CHAT_WS_MAX_MESSAGE_SIZE"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("CHAT_WS_MAX_MESSAGE_SIZE が数値ではありません: %q", v)
+		}
+		c.WS.MaxMessageSize = n
+	}
+	if v, ok := os.LookupEnv("CHAT_WS_COMPRESSION"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CHAT_WS_COMPRESSION は true/false で指定してください: %q", v)
+		}
+		c.WS.Compression = b
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		change  func(c *Config)
+		wantErr string // 空ならエラーなし
+	}{
+		{"dev のデフォルト", EnvDev, func(c *Config) {}, ""},
+		{"test のデフォルト", EnvTest, func(c *Config) {}, ""},
+		{"prod は秘密情報がなければ起動しない", EnvProd, func(c *Config) {}, "jwt_secret が未設定です"},
+		{"prod の短い jwt_secret", EnvProd, func(c *Config) {
+			c.PublicURL = "https://chat.example.com"
+			c.AllowedOrigins = []string{"https://chat.example.com"}
+			c.JWTSecret = "short"
+			c.DB.Password = "secret"
+		}, "32文字以上"},
+		{"prod で必要なものがそろっている", EnvProd, func(c *Config) {
+			c.PublicURL = "https://chat.example.com"
+			c.AllowedOrigins = []string{"https://chat.example.com"}
+			c.JWTSecret = strings.Repeat("x", 32)
+			c.DB.Password = "secret"
+		}, ""},
+		{"prod で memory", EnvProd, func(c *Config) {
+			c.PublicURL = "https://chat.example.com"
+			c.AllowedOrigins = []string{"https://chat.example.com"}
+			c.JWTSecret = strings.Repeat("x", 32)
+			c.DB.Password = "secret"
+			c.Store = StoreMemory
+		}, "store=memory"},
+		{"不明な env", "staging", func(c *Config) {}, "env は dev/test/prod"},
+		{"bus=postgres に store=memory", EnvDev, func(c *Config) { c.Store, c.Bus = StoreMemory, BusPostgres }, "bus=postgres"},
+		{"db.port の範囲外", EnvDev, func(c *Config) { c.DB.Port = 70000 }, "db.port"},
+		{"不明なタイムゾーン", EnvDev, func(c *Config) { c.TimeZone = "Mars/Olympus" }, "time_zone"},
+		{"空のタイムゾーン", EnvDev, func(c *Config) { c.TimeZone = "" }, "time_zone"},
+		{"ping が pong 待ちより長い", EnvDev, func(c *Config) { c.WS.PingInterval = time.Minute; c.WS.PongWait = time.Second }, "ws.ping_interval"},
+		{"batch_window が長すぎる", EnvDev, func(c *Config) { c.WS.BatchWindow = 2 * time.Second }, "ws.batch_window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Defaults(tt.env)
+			tt.change(c)
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate = %v, want %q を含むエラー", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 設定ファイル < 環境変数 < フラグ の順に優先する
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "addr: \":9000\"\nadmins: [root]\ntime_zone: UTC\ndb:\n  host: file-db\n  port: 5433\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CHAT_CONFIG", path)
+	t.Setenv("CHAT_DB_HOST", "env-db")
+	t.Setenv("CHAT_ADDR", ":9001")
+
+	c, rest, err := Load([]string{"-addr", ":9002", "-admins", "alice, bob", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":9002" {
+		t.Errorf("addr = %q, want フラグの :9002", c.Addr)
+	}
+	if c.DB.Host != "env-db" {
+		t.Errorf("db.host = %q, want 環境変数の env-db", c.DB.Host)
+	}
+	if c.DB.Port != 5433 || c.TimeZone != "UTC" {
+		t.Errorf("db.port = %d, time_zone = %q, want ファイルの 5433, UTC", c.DB.Port, c.TimeZone)
+	}
+	if !slices.Equal(c.Admins, []string{"alice", "bob"}) {
+		t.Errorf("admins = %q", c.Admins)
+	}
+	if c.Env != EnvDev || c.JWTSecret != "dev-secret-key" {
+		t.Errorf("指定のない項目は dev のデフォルト: env = %q, jwt_secret = %q", c.Env, c.JWTSecret)
+	}
+	if !slices.Equal(rest, []string{"migrate", "up"}) {
+		t.Errorf("残りの引数 = %q", rest)
+	}
+
+	// 検証に通らなければエラー
+	if _, _, err := Load([]string{"-store", "redis"}); err == nil {
+		t.Error("store=redis を受け付けました")
+	}
+}
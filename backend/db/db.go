@@ -2,28 +2,32 @@ package db
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
 	"time"
 
+	"backend/config"
+
 	_ "github.com/lib/pq"
 )
 
 var Conn *sql.DB
 
+// Initialize はDBに接続し、未適用のマイグレーションをすべて適用する
 func Initialize() {
-	var err error
+	Connect()
+
+	applied, err := MigrateUp(Conn)
+	if err != nil {
+		log.Fatalf("❌ マイグレーション失敗: %v", err)
+	}
+	log.Printf("✅ マイグレーション完了 (%d件適用)", applied)
+}
 
-	host := "db"
-	port := 5432
-	user := "chatuser"
-	password := "password"
-	dbname := "chat_app_db"
+// Connect はDBに接続する（マイグレーションは行わない）
+func Connect() {
+	var err error
 
-	connStr := fmt.Sprintf(
-		"user=%s dbname=%s password=%s host=%s port=%d sslmode=disable",
-		user, dbname, password, host, port,
-	)
+	connStr := config.Get().DB.DSN()
 
 	// 最大10回、接続をリトライ
 	for i := 0; i < 10; i++ {
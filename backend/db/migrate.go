@@ -0,0 +1,329 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// マイグレーションSQLはバイナリに埋め込む
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// 複数のバックエンドが同時に起動してもマイグレーションが二重に走らないようにするロックキー
+const migrationLockKey = 727274001
+
+// ErrDirty は前回のマイグレーションが途中で失敗したままになっていることを表す
+var ErrDirty = errors.New("schema_migrations が dirty 状態です。手動で確認して `migrate force` してください")
+
+// Migration は 1 バージョン分の up/down SQL
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus は各マイグレーションの適用状況
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	Dirty     bool
+	AppliedAt *time.Time
+}
+
+// LoadMigrations は埋め込まれた "<version>_<name>.(up|down).sql" をバージョン順に読み込む
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("マイグレーション名が不正です: %s", name)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("マイグレーションのバージョンが不正です: %s", name)
+		}
+
+		body, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("version %d に up マイグレーションがありません", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func ensureMigrationTable(conn *sql.DB) error {
+	_, err := conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			dirty      BOOLEAN     NOT NULL DEFAULT FALSE,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+type appliedMigration struct {
+	dirty     bool
+	appliedAt time.Time
+}
+
+func appliedMigrations(conn *sql.DB) (map[int]appliedMigration, error) {
+	rows, err := conn.Query(`SELECT version, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.dirty, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// withMigrationLock はアドバイザリロックを保持したまま fn を実行する。
+// ロックはセッション単位なので、取得と解放は専用コネクションで行う。
+func withMigrationLock(conn *sql.DB, fn func() error) error {
+	ctx := context.Background()
+	lockConn, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer lockConn.Close()
+
+	if _, err := lockConn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("マイグレーションロック取得失敗: %v", err)
+	}
+	defer lockConn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	if err := ensureMigrationTable(conn); err != nil {
+		return fmt.Errorf("schema_migrations 作成失敗: %v", err)
+	}
+	return fn()
+}
+
+func checkDirty(applied map[int]appliedMigration) error {
+	for version, a := range applied {
+		if a.dirty {
+			return fmt.Errorf("version %d: %w", version, ErrDirty)
+		}
+	}
+	return nil
+}
+
+// runMigration は1件のマイグレーションをトランザクション内で実行する（up なら upSteps の処理も）。
+// 実行前に dirty フラグを立て、成功したときだけ下ろすので、失敗や途中終了は dirty として残る。
+func runMigration(conn *sql.DB, m Migration, up bool) error {
+	body := m.Up
+	if !up {
+		body = m.Down
+	}
+
+	_, err := conn.Exec(`
+		INSERT INTO schema_migrations (version, name, dirty, applied_at)
+		VALUES ($1, $2, TRUE, NOW())
+		ON CONFLICT (version) DO UPDATE SET dirty = TRUE
+	`, m.Version, m.Name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(body); err != nil {
+		return fmt.Errorf("version %d (%s) の実行に失敗: %v", m.Version, m.Name, err)
+	}
+	if step := upSteps[m.Version]; up && step != nil {
+		if err := step(tx); err != nil {
+			return fmt.Errorf("version %d (%s) のデータ移行に失敗: %v", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(`UPDATE schema_migrations SET dirty = FALSE, applied_at = NOW() WHERE version = $1`, m.Version)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp は未適用のマイグレーションを古い順にすべて適用し、適用した件数を返す
+func MigrateUp(conn *sql.DB) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(conn, func() error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			log.Printf("⬆️ マイグレーション適用: %04d_%s", m.Version, m.Name)
+			if err := runMigration(conn, m, true); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown は適用済みのマイグレーションを新しい順に steps 件だけ戻す
+func MigrateDown(conn *sql.DB, steps int) (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(conn, func() error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("version %d に down マイグレーションがありません", m.Version)
+			}
+			log.Printf("⬇️ マイグレーション取り消し: %04d_%s", m.Version, m.Name)
+			if err := runMigration(conn, m, false); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateForce は dirty フラグを消して version を適用済みとして記録する（手動復旧用）
+func MigrateForce(conn *sql.DB, version int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(conn, func() error {
+		for _, m := range migrations {
+			if m.Version != version {
+				continue
+			}
+			_, err := conn.Exec(`
+				INSERT INTO schema_migrations (version, name, dirty, applied_at)
+				VALUES ($1, $2, FALSE, NOW())
+				ON CONFLICT (version) DO UPDATE SET dirty = FALSE
+			`, m.Version, m.Name)
+			return err
+		}
+		return fmt.Errorf("version %d のマイグレーションは存在しません", version)
+	})
+}
+
+// GetMigrationStatus は埋め込まれた全マイグレーションの適用状況を返す
+func GetMigrationStatus(conn *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationTable(conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			at := a.appliedAt
+			s.Applied = true
+			s.Dirty = a.dirty
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"backend/search"
+	"database/sql"
+)
+
+// upSteps は SQL だけでは書けない処理（アプリと同じ Go の関数でデータを埋めるなど）。
+// キーの version の up SQL のあとに、同じトランザクションで実行する。
+var upSteps = map[int]func(tx *sql.Tx) error{
+	2: backfillSearchText,
+}
+
+// 既存データを書き換えるときに1回で読み込む行数
+const backfillBatchSize = 1000
+
+// backfillSearchText は既存のメッセージの search_text を、保存時と同じ search.Normalize で埋める
+func backfillSearchText(tx *sql.Tx) error {
+	type row struct {
+		id      int
+		content string
+	}
+	lastID := 0
+	for {
+		rows, err := tx.Query(`SELECT id, content FROM messages WHERE id > $1 ORDER BY id LIMIT $2`, lastID, backfillBatchSize)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.content); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		// 同じトランザクションで読み込み中に UPDATE できないので、読み終えてから書く
+		for _, r := range batch {
+			if _, err := tx.Exec(`UPDATE messages SET search_text = $1 WHERE id = $2`, search.Normalize(r.content), r.id); err != nil {
+				return err
+			}
+			lastID = r.id
+		}
+	}
+}
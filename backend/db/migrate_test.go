@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckDirty(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		applied map[int]appliedMigration
+		wantErr bool
+	}{
+		{"未適用", nil, false},
+		{"すべて適用済み", map[int]appliedMigration{1: {appliedAt: now}, 2: {appliedAt: now}}, false},
+		{"途中で失敗した version がある", map[int]appliedMigration{1: {appliedAt: now}, 2: {dirty: true, appliedAt: now}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDirty(tt.applied)
+			if got := errors.Is(err, ErrDirty); got != tt.wantErr {
+				t.Errorf("checkDirty = %v, want dirty=%v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 埋め込んだマイグレーションは 1 から欠番なく並び、すべて up と down を持つ
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("マイグレーションがありません")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("%d番目の version = %d, want %d", i, m.Version, i+1)
+		}
+		if m.Name == "" || m.Up == "" || m.Down == "" {
+			t.Errorf("version %d: name=%q up=%d文字 down=%d文字", m.Version, m.Name, len(m.Up), len(m.Down))
+		}
+	}
+	if migrations[0].Name != "init" {
+		t.Errorf("最初のマイグレーション = %q, want init", migrations[0].Name)
+	}
+}
@@ -0,0 +1,60 @@
+-- 初期スキーマ
+-- 既存の手作りDBにもそのまま適用できるよう IF NOT EXISTS で作成する
+
+CREATE TABLE IF NOT EXISTS users (
+    id            SERIAL PRIMARY KEY,
+    username      TEXT        NOT NULL UNIQUE,
+    password_hash TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS chat_rooms (
+    id         SERIAL PRIMARY KEY,
+    room_name  TEXT        NOT NULL DEFAULT '',
+    is_group   INTEGER     NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+    id        SERIAL PRIMARY KEY,
+    room_id   INTEGER     NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    user_id   INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS room_members_room_user_key ON room_members (room_id, user_id);
+CREATE INDEX IF NOT EXISTS room_members_user_idx ON room_members (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id         SERIAL PRIMARY KEY,
+    room_id    INTEGER     NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    sender_id  INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS messages_room_created_idx ON messages (room_id, created_at, id);
+
+-- 既読・リアクション（1ユーザー1メッセージにつき1行）
+CREATE TABLE IF NOT EXISTS message_reads (
+    id         SERIAL PRIMARY KEY,
+    message_id INTEGER     NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reaction   TEXT,
+    read_at    TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS message_reads_message_user_key ON message_reads (message_id, user_id);
+CREATE INDEX IF NOT EXISTS message_reads_unread_idx ON message_reads (user_id) WHERE read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+    id         SERIAL PRIMARY KEY,
+    message_id INTEGER     NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    file_name  TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mentions (
+    message_id        INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    mention_target_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, mention_target_id)
+);
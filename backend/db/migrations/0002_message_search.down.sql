@@ -0,0 +1,4 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS has_attachment;
+DROP INDEX IF EXISTS messages_search_grams_idx;
+DROP FUNCTION IF EXISTS search_grams(TEXT);
+ALTER TABLE messages DROP COLUMN IF EXISTS search_text;
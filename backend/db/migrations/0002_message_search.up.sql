@@ -0,0 +1,22 @@
+-- メッセージ検索
+-- search_text は search.Normalize で正規化した本文。部分一致で検索する。
+-- 日本語は分かち書きせず、単語も1〜2文字のことが多い（東京、会議）。pg_trgm のトライグラムでは
+-- 3文字未満の語に索引が効かないので、本文の1文字と2文字の部分文字列すべてに GIN インデックスを張る。
+-- 検索語の側は search.Grams で同じ部分文字列にして @> で候補を絞り、LIKE で確かめる。
+-- 既存のメッセージの search_text は、この SQL のあとに Go で埋める（db/migrate_steps.go。SQL では同じ正規化ができない）。
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_text TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION search_grams(t TEXT) RETURNS TEXT[]
+LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE
+AS $$
+    SELECT COALESCE(array_agg(DISTINCT g), '{}')
+    FROM generate_series(1, char_length(t)) AS i,
+         LATERAL (VALUES (substr(t, i, 1)), (substr(t, i, 2))) AS v(g)
+$$;
+
+CREATE INDEX IF NOT EXISTS messages_search_grams_idx ON messages USING GIN (search_grams(search_text));
+
+-- 画像添付（/upload が返す /static/ 配下のURL）を含むか
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS has_attachment BOOLEAN
+    GENERATED ALWAYS AS (strpos(content, '/static/') > 0) STORED;
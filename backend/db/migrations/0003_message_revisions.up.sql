@@ -0,0 +1,12 @@
+-- メッセージの編集履歴
+-- 編集のたびに「編集前の本文」を1行残す。edited_at はその本文が置き換えられた時刻。
+-- 最新の本文は messages.content、最後に編集された時刻は messages.updated_at にある。
+
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id         SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    content    TEXT NOT NULL,
+    edited_by  INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    edited_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS message_revisions_message_idx ON message_revisions (message_id, id);
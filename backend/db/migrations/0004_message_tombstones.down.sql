@@ -0,0 +1,7 @@
+-- 旧方式（本文を固定文言で上書き）に戻す
+UPDATE messages SET content = 'このメッセージは削除されました', search_text = '' WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS messages_pending_purge_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS purged_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,16 @@
+-- メッセージの論理削除（トゥームストーン）
+-- 削除しても保持期間中は本文を残し、管理者が復元できる。
+-- 保持期間を過ぎるとバックグラウンドジョブが本文・編集履歴・リアクションを消去し purged_at を記録する。
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS purged_at  TIMESTAMPTZ;
+
+-- 以前は本文を固定文言で上書きしていたので、その行は削除済み（本文は消去済み）として移行する
+UPDATE messages
+SET deleted_at = COALESCE(updated_at, created_at), purged_at = NOW(), content = '', search_text = ''
+WHERE content = 'このメッセージは削除されました' AND deleted_at IS NULL;
+
+-- 消去ジョブが対象を探すためのインデックス
+CREATE INDEX IF NOT EXISTS messages_pending_purge_idx ON messages (deleted_at)
+    WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
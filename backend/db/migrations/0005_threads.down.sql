@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS thread_followers;
+DROP INDEX IF EXISTS messages_thread_idx;
+DROP INDEX IF EXISTS messages_room_root_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS last_reply_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_count;
+ALTER TABLE messages DROP COLUMN IF EXISTS parent_message_id;
@@ -0,0 +1,20 @@
+-- スレッド
+-- 返信は1階層のみ（返信への返信はスレッドの親への返信として保存する）。
+-- reply_count / last_reply_at は親メッセージに非正規化して持ち、タイムライン取得時に集計しない。
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count   INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMPTZ;
+
+-- タイムライン（返信を除く）とスレッドのページング用
+CREATE INDEX IF NOT EXISTS messages_room_root_idx ON messages (room_id, created_at, id) WHERE parent_message_id IS NULL;
+CREATE INDEX IF NOT EXISTS messages_thread_idx ON messages (parent_message_id, created_at, id) WHERE parent_message_id IS NOT NULL;
+
+-- スレッドのフォロー（親の投稿者と返信したユーザーは自動でフォローする）
+CREATE TABLE IF NOT EXISTS thread_followers (
+    message_id INTEGER     NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS thread_followers_user_idx ON thread_followers (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_reply_to_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to_message_id;
@@ -0,0 +1,4 @@
+-- 引用返信
+-- プレビューは取得時に引用元から組み立てるので、引用元の編集・削除がそのまま反映される。
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS messages_reply_to_idx ON messages (reply_to_message_id) WHERE reply_to_message_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_client_request_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_request_id;
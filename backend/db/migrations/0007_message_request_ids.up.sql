@@ -0,0 +1,4 @@
+-- WebSocket 送信の冪等化
+-- クライアントが付けた request_id を保存し、再接続後の再送で同じメッセージが二重に作られないようにする。
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_request_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_client_request_idx ON messages (sender_id, client_request_id) WHERE client_request_id IS NOT NULL;
@@ -0,0 +1,17 @@
+-- WebSocketイベントログ
+-- ユーザーごとに連番 (seq) を振って配信したイベントを保持し、再接続時に取りこぼした分を順に再送する。
+-- 保持期間 (event_retention) を過ぎたイベントは定期的に削除する。連番は user_event_seqs に持つので削除後も巻き戻らない。
+CREATE TABLE IF NOT EXISTS user_event_seqs (
+    user_id  INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT  NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_events (
+    user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq        BIGINT      NOT NULL,
+    type       TEXT        NOT NULL,
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, seq)
+);
+CREATE INDEX IF NOT EXISTS user_events_created_idx ON user_events (created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_presence;
+ALTER TABLE users DROP COLUMN IF EXISTS hide_last_seen;
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
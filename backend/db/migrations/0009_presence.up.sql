@@ -0,0 +1,14 @@
+-- プレゼンス（オンライン状態と最終ログイン）
+-- user_presence はレプリカごとの接続状態。どこかのレプリカで online なら online、idle だけなら idle、行がなければ offline。
+-- 各レプリカは自分の行の updated_at を定期的に更新するので、更新が止まった行（落ちたレプリカの分）は無視して後で削除する。
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS user_presence (
+    user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    replica    TEXT        NOT NULL,
+    state      TEXT        NOT NULL CHECK (state IN ('online', 'idle')),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, replica)
+);
+CREATE INDEX IF NOT EXISTS user_presence_replica_idx ON user_presence (replica);
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/middleware"
+	"backend/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 削除メッセージの消去ジョブを実行する間隔
+const purgeInterval = time.Hour
+
+// requireAdmin はリクエストユーザーが管理者ならそのIDを返す。違えばエラーレスポンスを書いて false を返す。
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return 0, false
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil || !config.Get().IsAdmin(user.Username) {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+// RestoreMessage は保持期間内の削除済みメッセージを復元する（管理者のみ）
+// POST /admin/messages/restore?id=xx
+func (s *Server) RestoreMessage(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	deletedSince := time.Now().Add(-config.Get().DeletedRetention)
+	err = s.store.RestoreMessage(id, deletedSince)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, `{"error": "復元できる削除済みメッセージがありません"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("❌ メッセージ復元失敗:", err)
+		http.Error(w, `{"error": "復元に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("♻️ メッセージ復元: messageID=%d adminID=%d", id, adminID)
+
+	if m, err := s.store.GetMessage(id); err == nil {
+		s.BroadcastRestore(m.RoomID, m.ID, m.Content)
+		s.notifyQuoteUpdate(*m)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HardDeleteMessage はメッセージを完全に削除する（管理者のみ。保持期間を待たず、復元もできない）
+// DELETE /admin/messages/hard_delete?id=xx
+func (s *Server) HardDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.hardDeleteMessage(adminID, id); err != nil {
+		writeServiceError(w, err, "完全削除に失敗しました")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// StartDeletedMessagePurge は保持期間を過ぎた削除済みメッセージの本文を定期的に消去する
+func (s *Server) StartDeletedMessagePurge() {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			s.purgeDeletedMessages()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *Server) purgeDeletedMessages() {
+	cutoff := time.Now().Add(-config.Get().DeletedRetention)
+	n, err := s.store.PurgeDeletedMessages(cutoff)
+	if err != nil {
+		log.Println("❌ 削除済みメッセージの消去に失敗:", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("🧹 削除済みメッセージの本文を消去: %d件", n)
+	}
+}
+
+// GetWebSocketStats は接続中のユーザー数・接続数と、応答なしで回収した接続数を返す（管理者のみ）
+// GET /admin/ws/stats
+func (s *Server) GetWebSocketStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.clients.Stats())
+}
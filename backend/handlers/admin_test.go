@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"backend/config"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// 本人が削除したメッセージはトゥームストーンとして残り、管理者だけが復元できる
+func TestDeleteAndRestore(t *testing.T) {
+	setConfig(t, func(c *config.Config) { c.Admins = []string{"admin"} })
+	ts, alice, bob, roomID := startChat(t)
+	admin := signUp(t, ts, "admin")
+	id := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "間違えました"})
+	bobWS := dialWS(t, ts, bob)
+
+	decodeBody(t, bob.do(t, ts, "DELETE", fmt.Sprintf("/messages/delete?id=%d", id), nil), http.StatusForbidden, nil)
+	decodeBody(t, alice.do(t, ts, "DELETE", fmt.Sprintf("/messages/delete?id=%d", id), nil), http.StatusOK, nil)
+
+	if got := readFrame(t, bobWS, "delete"); got["message_id"] != float64(id) {
+		t.Errorf("delete = %v", got)
+	}
+	msgs := getMessages(t, ts, bob, roomID)
+	if len(msgs) != 1 || !msgs[0].Deleted || msgs[0].Content != "" {
+		t.Fatalf("削除後のメッセージ = %+v", msgs)
+	}
+
+	restore := fmt.Sprintf("/admin/messages/restore?id=%d", id)
+	decodeBody(t, alice.do(t, ts, "POST", restore, nil), http.StatusForbidden, nil)
+	decodeBody(t, admin.do(t, ts, "POST", restore, nil), http.StatusOK, nil)
+
+	if got := readFrame(t, bobWS, "restore"); got["content"] != "間違えました" {
+		t.Errorf("restore = %v", got)
+	}
+	if msgs := getMessages(t, ts, bob, roomID); msgs[0].Deleted || msgs[0].Content != "間違えました" {
+		t.Errorf("復元後のメッセージ = %+v", msgs[0])
+	}
+	decodeBody(t, admin.do(t, ts, "POST", restore, nil), http.StatusNotFound, nil)
+}
+
+// 完全削除は管理者だけができ、ルームに削除・引用の更新・スレッドの返信数を知らせる
+func TestHardDeleteMessage(t *testing.T) {
+	setConfig(t, func(c *config.Config) { c.Admins = []string{"admin"} })
+	ts, alice, bob, roomID := startChat(t)
+	admin := signUp(t, ts, "admin")
+	root := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "議題"})
+	sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "賛成", "parent_message_id": root})
+	reply := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "ありがとう", "parent_message_id": root})
+	sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "どういたしまして", "reply_to_message_id": reply})
+	bobWS := dialWS(t, ts, bob)
+
+	hardDelete := fmt.Sprintf("/admin/messages/hard_delete?id=%d", reply)
+	decodeBody(t, alice.do(t, ts, "DELETE", hardDelete, nil), http.StatusForbidden, nil)
+	decodeBody(t, admin.do(t, ts, "DELETE", hardDelete, nil), http.StatusOK, nil)
+	decodeBody(t, admin.do(t, ts, "DELETE", hardDelete, nil), http.StatusNotFound, nil)
+
+	if got := readFrame(t, bobWS, "delete"); got["message_id"] != float64(reply) {
+		t.Errorf("delete = %v", got)
+	}
+	if got := readFrame(t, bobWS, "quote_update"); got["message_id"] != float64(reply) || got["preview"].(map[string]any)["deleted"] != true {
+		t.Errorf("quote_update = %v", got)
+	}
+	if got := readFrame(t, bobWS, "thread_update"); got["root_id"] != float64(root) || got["reply_count"] != float64(1) {
+		t.Errorf("thread_update = %v", got)
+	}
+
+	var thread struct {
+		Root     MessageWithStatus   `json:"root"`
+		Messages []MessageWithStatus `json:"messages"`
+	}
+	decodeBody(t, alice.do(t, ts, "GET", fmt.Sprintf("/messages/thread?message_id=%d", root), nil), http.StatusOK, &thread)
+	if len(thread.Messages) != 1 {
+		t.Fatalf("返信数 = %d, want 1", len(thread.Messages))
+	}
+	if thread.Root.ReplyCount != 1 {
+		t.Errorf("reply_count = %d, want 1", thread.Root.ReplyCount)
+	}
+	if thread.Root.LastReplyAt == nil || !thread.Root.LastReplyAt.Equal(thread.Messages[0].Timestamp) {
+		t.Errorf("last_reply_at = %v, want %v", thread.Root.LastReplyAt, thread.Messages[0].Timestamp)
+	}
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
-	"backend/db"
 	"backend/middleware"
 	"backend/models"
+	"backend/store"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 )
 
 // ユーザー登録（サインアップ）
-func SignUp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -24,14 +25,12 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ユーザー名の重複チェック
-	var existingID int
-	query := `SELECT id FROM users WHERE username=$1`
-	err := db.Conn.QueryRow(query, user.Username).Scan(&existingID)
-	if err != nil && err.Error() != "sql: no rows in result set" {
+	_, err := s.store.GetUserByUsername(user.Username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
 		http.Error(w, "データベースエラー", http.StatusInternalServerError)
 		return
 	}
-	if existingID != 0 {
+	if err == nil {
 		http.Error(w, "ユーザー名はすでに存在します", http.StatusConflict)
 		return
 	}
@@ -44,8 +43,7 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 登録
-	query = `INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id`
-	err = db.Conn.QueryRow(query, user.Username, hashedPassword).Scan(&user.ID)
+	user.ID, err = s.store.CreateUser(user.Username, string(hashedPassword))
 	if err != nil {
 		http.Error(w, "ユーザー作成に失敗しました", http.StatusInternalServerError)
 		return
@@ -58,7 +56,7 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 
 // ログイン（トークン生成）
 // Login（Cookieベース版）
-func Login(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -68,14 +66,13 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var storedPasswordHash string
-	var userID int
-	query := `SELECT id, password_hash FROM users WHERE username=$1`
-	err := db.Conn.QueryRow(query, user.Username).Scan(&userID, &storedPasswordHash)
+	stored, err := s.store.GetUserByUsername(user.Username)
 	if err != nil {
 		http.Error(w, "ユーザーが存在しないか、DBエラー", http.StatusUnauthorized)
 		return
 	}
+	userID := stored.ID
+	storedPasswordHash := stored.PasswordHash
 
 	// パスワード検証
 	err = bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(user.PasswordHash))
@@ -89,7 +86,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		"user_id": userID,
 		"exp":     jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24時間有効
 	})
-	tokenString, err := token.SignedString(middleware.SecretKey)
+	tokenString, err := token.SignedString(middleware.SecretKey())
 	if err != nil {
 		http.Error(w, "トークン生成に失敗しました", http.StatusInternalServerError)
 		return
@@ -136,7 +133,7 @@ func GetMe(w http.ResponseWriter, r *http.Request) {
 	}
 
 	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
-		return middleware.SecretKey, nil
+		return middleware.SecretKey(), nil
 	})
 	if err != nil || !token.Valid {
 		http.Error(w, "無効なトークンです", http.StatusUnauthorized)
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/store"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	ts := startTestServer(t, store.NewMemory(), bus.NewLocal())
+	alice := signUp(t, ts, "alice")
+
+	// 間違ったパスワードでは Cookie を発行しない
+	res, err := http.Post(ts.URL+"/login", "application/json", strings.NewReader(`{"username": "alice", "password_hash": "wrong"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, res, http.StatusUnauthorized, nil)
+	if len(res.Cookies()) != 0 {
+		t.Errorf("ログイン失敗なのに Cookie が発行されました: %v", res.Cookies())
+	}
+
+	// 発行された Cookie で本人と分かる
+	var me struct {
+		UserID int `json:"user_id"`
+	}
+	decodeBody(t, alice.do(t, ts, "GET", "/me", nil), http.StatusOK, &me)
+	if me.UserID != alice.ID {
+		t.Errorf("/me の user_id = %d, want %d", me.UserID, alice.ID)
+	}
+
+	// Cookie がなければ認証エラー
+	res, err = http.Get(ts.URL + "/messages?room_id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, res, http.StatusUnauthorized, nil)
+}
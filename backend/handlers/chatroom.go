@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"backend/db"
 	"backend/middleware"
 	"backend/models"
 	"encoding/json"
@@ -10,10 +9,10 @@ import (
 )
 
 // チャットルーム作成（グループ対応）
-func CreateChatRoom(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CreateChatRoom(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
-		http.Error(w, `{"error": "Unauthorized: `+err.Error()+`"}`, http.StatusUnauthorized)
+		writeJSONError(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	log.Println("✅ sender userID =", userID)
@@ -25,24 +24,12 @@ func CreateChatRoom(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	isGroupInt := 0
-	if room.IsGroup {
-		isGroupInt = 1
-	}
-
-	query := `INSERT INTO chat_rooms (room_name, is_group) VALUES ($1, $2) RETURNING id`
-	err = db.Conn.QueryRow(query, room.RoomName, isGroupInt).Scan(&room.ID)
+	room.ID, err = s.store.CreateRoom(room.RoomName, room.IsGroup, []int{userID})
 	if err != nil {
 		http.Error(w, `{"error": "チャットルームの作成に失敗しました"}`, http.StatusInternalServerError)
 		return
 	}
-
-	memberQuery := `INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)`
-	_, err = db.Conn.Exec(memberQuery, room.ID, userID)
-	if err != nil {
-		http.Error(w, `{"error": "ルームメンバー追加に失敗しました"}`, http.StatusInternalServerError)
-		return
-	}
+	s.joinRoomMembers(room.ID, []int{userID})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(room)
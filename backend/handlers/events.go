@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/config"
+	"backend/hub"
+	"backend/protocol"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// 再接続時に再送する最大件数。これより多く取りこぼした接続には resync を送る
+	maxReplayEvents = 1000
+	// 保持期間を過ぎたイベントを削除する間隔
+	eventPurgeInterval = time.Hour
+)
+
+// deliverEvent はバスから届いたイベントを接続中のユーザーに送る。
+// 本体を省いたイベントは、接続中の宛先の分だけイベントログから読み直す。
+func (s *Server) deliverEvent(e bus.Event) {
+	if e.Kind == bus.KindTyping {
+		s.typing.observe(e)
+		return
+	}
+	if !e.Ref {
+		s.clients.Deliver(e)
+		return
+	}
+	for uid, seq := range e.Seqs {
+		if seq == 0 || !s.clients.IsOnline(uid) {
+			continue
+		}
+		events, err := s.store.ListUserEvents(uid, seq-1, 1)
+		if err != nil || len(events) == 0 || events[0].Seq != seq {
+			log.Printf("❌ イベントログから本体を読めません（再同期します）: userID=%d seq=%d err=%v", uid, seq, err)
+			s.clients.SendToUser(uid, protocol.ResyncEvent{})
+			continue
+		}
+		s.clients.Deliver(bus.Event{Kind: bus.KindUsers, Type: e.Type, Body: events[0].Payload, Seqs: map[int]int64{uid: seq}})
+	}
+}
+
+// publish は ev を userIDs それぞれのイベントログに記録し、バス経由で全レプリカの接続中の端末に seq 付きで送る。
+// ログへの記録に失敗しても、接続中の端末には seq なしで送る。記録した seq を宛先ごとに返す。
+func (s *Server) publish(userIDs []int, ev protocol.ServerEvent) (map[int]int64, error) {
+	body, err := protocol.Marshal(ev)
+	if err != nil {
+		log.Printf("❌ WebSocket送信データのJSON変換失敗: type=%s err=%v", ev.EventType(), err)
+		return nil, err
+	}
+
+	userIDs = uniqueSortedIDs(userIDs)
+	seqs, err := s.store.AppendUserEvents(userIDs, ev.EventType(), body)
+	if err != nil {
+		log.Printf("❌ イベントログ記録失敗（再接続時に再送できません）: type=%s err=%v", ev.EventType(), err)
+	}
+
+	targets := make(map[int]int64, len(userIDs))
+	for _, uid := range userIDs {
+		targets[uid] = seqs[uid]
+	}
+	err = s.publishToBus(bus.Event{Kind: bus.KindUsers, Type: ev.EventType(), Body: body, Seqs: targets})
+	if err != nil {
+		log.Printf("❌ イベント配送失敗（再接続時に再送）: type=%s err=%v", ev.EventType(), err)
+	}
+	return targets, err
+}
+
+// publishEphemeral は ev をイベントログに記録せず、バス経由で userIDs の接続中の端末にだけ送る。
+// 未接続の端末や取りこぼした端末には届かない（その時点の状態を REST で取り直せるイベント用）。
+func (s *Server) publishEphemeral(userIDs []int, ev protocol.ServerEvent) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	body, err := protocol.Marshal(ev)
+	if err != nil {
+		log.Printf("❌ WebSocket送信データのJSON変換失敗: type=%s err=%v", ev.EventType(), err)
+		return err
+	}
+	targets := make(map[int]int64, len(userIDs))
+	for _, uid := range userIDs {
+		targets[uid] = 0
+	}
+	return s.publishToBus(bus.Event{Kind: bus.KindUsers, Type: ev.EventType(), Body: body, Seqs: targets})
+}
+
+// publishToBus は e をバスに流す。大きすぎて流せなければ本体を省き（全宛先のログにあるときだけ）、
+// それでも大きければ宛先を分けて流す。
+func (s *Server) publishToBus(e bus.Event) error {
+	err := s.bus.Publish(e)
+	if !errors.Is(err, bus.ErrTooLarge) {
+		return err
+	}
+	if !e.Ref && allLogged(e.Seqs) {
+		e.Ref = true
+		e.Body = nil
+		return s.publishToBus(e)
+	}
+	if len(e.Seqs) <= 1 {
+		return err
+	}
+	first, rest := make(map[int]int64), make(map[int]int64)
+	for uid, seq := range e.Seqs {
+		if len(first) < len(e.Seqs)/2 {
+			first[uid] = seq
+		} else {
+			rest[uid] = seq
+		}
+	}
+	e.Seqs = first
+	err1 := s.publishToBus(e)
+	e.Seqs = rest
+	return errors.Join(err1, s.publishToBus(e))
+}
+
+func allLogged(seqs map[int]int64) bool {
+	for _, seq := range seqs {
+		if seq == 0 {
+			return false
+		}
+	}
+	return len(seqs) > 0
+}
+
+// publishToRoom は ev をルームの全メンバー（未接続のメンバーも含む）に publish する。
+// 宛先はストアのメンバーで決まる（clients のルーム購読は「入力中」の配送にだけ使う）。
+func (s *Server) publishToRoom(roomID int, ev protocol.ServerEvent) error {
+	members, err := s.store.ListRoomMemberIDs(roomID)
+	if err != nil {
+		log.Printf("❌ ルームメンバー取得失敗: roomID=%d err=%v", roomID, err)
+		return err
+	}
+	_, err = s.publish(members, ev)
+	return err
+}
+
+func uniqueSortedIDs(ids []int) []int {
+	out := append([]int(nil), ids...)
+	sort.Ints(out)
+	n := 0
+	for i, id := range out {
+		if i == 0 || id != out[n-1] {
+			out[n] = id
+			n++
+		}
+	}
+	return out[:n]
+}
+
+// resumeEvents は接続の保留を解く。lastSeqParam（クエリの last_seq）があれば、それより後の
+// イベントを順に再送する。再送できないほど古ければ resync を送る。最後に ready を送る。
+func (s *Server) resumeEvents(c *hub.Conn, lastSeqParam string) {
+	userID := c.UserID
+	oldest, latest, err := s.store.UserEventRange(userID)
+	if err != nil {
+		log.Printf("❌ イベントログの範囲取得失敗: userID=%d err=%v", userID, err)
+		releaseWithResync(c, 0)
+		return
+	}
+
+	if lastSeqParam == "" {
+		// 初回接続: ここから先のイベントだけ送る
+		releaseConn(c, latest, nil)
+		return
+	}
+	lastSeq, err := strconv.ParseInt(lastSeqParam, 10, 64)
+	switch {
+	case err != nil || lastSeq < 0 || lastSeq > latest:
+		log.Printf("⚠️ last_seq がイベントログと合わないため再同期: userID=%d last_seq=%q latest=%d", userID, lastSeqParam, latest)
+		releaseWithResync(c, latest)
+		return
+	case lastSeq+1 < oldest || latest-lastSeq > maxReplayEvents:
+		log.Printf("⚠️ 取りこぼしが古すぎる・多すぎるため再同期: userID=%d last_seq=%d oldest=%d latest=%d", userID, lastSeq, oldest, latest)
+		releaseWithResync(c, latest)
+		return
+	}
+
+	events, err := s.store.ListUserEvents(userID, lastSeq, maxReplayEvents)
+	if err != nil {
+		log.Printf("❌ イベントログ取得失敗: userID=%d err=%v", userID, err)
+		releaseWithResync(c, latest)
+		return
+	}
+	// 範囲を取ったあとに追加されたイベントも含まれうるので、floor は実際に再送した最後の seq に合わせる
+	floor := latest
+	replay := make([][]byte, 0, len(events))
+	for _, e := range events {
+		replay = append(replay, protocol.Frame(e.Type, e.Seq, e.Payload))
+		if e.Seq > floor {
+			floor = e.Seq
+		}
+	}
+	if len(replay) > 0 {
+		log.Printf("⏪ 取りこぼしたイベントを再送: userID=%d connID=%d last_seq=%d 件数=%d", userID, c.ID, lastSeq, len(replay))
+	}
+	releaseConn(c, floor, replay)
+}
+
+func releaseConn(c *hub.Conn, floor int64, replay [][]byte) {
+	ready, _ := protocol.Encode(protocol.ReadyEvent{LastSeq: floor, Replayed: len(replay)})
+	c.Release(floor, replay, ready)
+}
+
+func releaseWithResync(c *hub.Conn, floor int64) {
+	resync, _ := protocol.Encode(protocol.ResyncEvent{})
+	ready, _ := protocol.Encode(protocol.ReadyEvent{LastSeq: floor})
+	c.Release(floor, [][]byte{resync}, ready)
+}
+
+// StartEventLogPurge は保持期間を過ぎたイベントを定期的に削除する
+func (s *Server) StartEventLogPurge() {
+	go func() {
+		ticker := time.NewTicker(eventPurgeInterval)
+		defer ticker.Stop()
+		for {
+			s.purgeEventLog()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *Server) purgeEventLog() {
+	cutoff := time.Now().Add(-config.Get().EventRetention)
+	n, err := s.store.PurgeUserEvents(cutoff)
+	if err != nil {
+		log.Println("❌ イベントログの削除に失敗:", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("🧹 保持期間を過ぎたイベントを削除: %d件", n)
+	}
+}
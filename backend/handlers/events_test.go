@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+)
+
+// last_seq を渡して再接続すると、それより後のイベントを順に再送してから ready を送る
+func TestReplayOnReconnect(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	first := dialWSWith(t, ts, bob, "", "")
+	lastSeq := readFrame(t, first, "ready")["last_seq"].(float64)
+	first.Close()
+
+	var ids []any
+	for _, content := range []string{"1件目", "2件目"} {
+		ids = append(ids, float64(sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": content})))
+	}
+
+	conn := dialWSWith(t, ts, bob, fmt.Sprintf("last_seq=%d", int64(lastSeq)), "")
+	frames := readFramesUntil(t, conn, "ready")
+	ready := frames[len(frames)-1]
+	var replayed []any
+	prevSeq := lastSeq
+	for _, f := range frames[:len(frames)-1] {
+		seq, _ := f["seq"].(float64)
+		if seq <= prevSeq {
+			t.Errorf("seq が増えていません: %v のあとに %v", prevSeq, f)
+		}
+		prevSeq = seq
+		if f["type"] == "message" {
+			replayed = append(replayed, f["id"])
+		}
+	}
+	if fmt.Sprint(replayed) != fmt.Sprint(ids) {
+		t.Errorf("再送されたメッセージ = %v, want %v", replayed, ids)
+	}
+	if ready["replayed"] != float64(len(frames)-1) || ready["last_seq"] != prevSeq {
+		t.Errorf("ready = %v, want replayed %d, last_seq %v", ready, len(frames)-1, prevSeq)
+	}
+
+	// 再送したあとに届いたイベントは重複なく続く
+	third := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "3件目"})
+	if got := readFrame(t, conn, "message"); got["id"] != float64(third) || got["seq"].(float64) <= prevSeq {
+		t.Errorf("再接続後のメッセージ = %v", got)
+	}
+
+	tests := []struct {
+		name       string
+		lastSeq    string
+		wantResync bool
+	}{
+		{"再送できる範囲", fmt.Sprint(int64(prevSeq)), false},
+		{"数値でない", "abc", true},
+		{"ログより先", "999999", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialWSWith(t, ts, bob, "last_seq="+tt.lastSeq, "")
+			types := typesOf(readFramesUntil(t, conn, "ready"))
+			if got := count(types, "resync") == 1; got != tt.wantResync {
+				t.Errorf("ready までのフレーム = %v, want resync %v", types, tt.wantResync)
+			}
+		})
+	}
+}
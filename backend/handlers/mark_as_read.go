@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"backend/db"
+	"backend/protocol"
 	"log"
 	"net/http"
 	"strconv"
@@ -9,7 +9,7 @@ import (
 )
 
 // MarkMessageAsRead handles marking a specific message as read by a user.
-func MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
+func (s *Server) MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
 	messageIDStr := r.URL.Query().Get("message_id")
 	userIDStr := r.URL.Query().Get("user_id")
 
@@ -34,29 +34,19 @@ func MarkMessageAsRead(w http.ResponseWriter, r *http.Request) {
 	readAt := time.Now()
 
 	// ✅ UPSERT処理（INSERTまたはUPDATE）
-	_, err = db.Conn.Exec(`
-		INSERT INTO message_reads (message_id, user_id, read_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (message_id, user_id)
-		DO UPDATE SET read_at = EXCLUDED.read_at
-	`, messageID, userID, readAt)
+	err = s.store.UpsertMessageRead(messageID, userID, readAt)
 	if err != nil {
-		http.Error(w, `{"error": "DB upsert error: `+err.Error()+`"}`, http.StatusInternalServerError)
+		writeJSONError(w, "DB upsert error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	log.Printf("✅ UPSERT read_at: message_id=%d user_id=%d", messageID, userID)
 
 	// Notify sender
-	var senderID int
-	err = db.Conn.QueryRow(`SELECT sender_id FROM messages WHERE id = $1`, messageID).Scan(&senderID)
+	senderID, err := s.store.GetSenderIDByMessageID(messageID)
 	if err != nil {
 		log.Printf("❌ 送信者取得失敗: %v", err)
 	} else {
-		NotifyUser(senderID, map[string]interface{}{
-			"type":       "read",
-			"message_id": messageID,
-			"read_at":    readAt.Format(time.RFC3339),
-		})
+		s.NotifyUser(senderID, protocol.ReadEvent{MessageIDs: []int{messageID}, ReadAt: readAt})
 		log.Printf("📡 WebSocket通知: sender_id=%d message_id=%d", senderID, messageID)
 	}
 
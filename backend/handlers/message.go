@@ -1,25 +1,27 @@
 package handlers
 
 import (
-	"backend/db"
 	"backend/middleware"
 	"backend/models"
-	"database/sql"
+	"backend/protocol"
+	"backend/store"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"regexp"
 	"strconv"
-	"strings"
 	"time"
 )
 
 type IncomingMessage struct {
-	Content    string `json:"content"`
-	ReceiverID int    `json:"receiver_id"`
+	Content          string `json:"content"`
+	ReceiverID       int    `json:"receiver_id"`
+	RoomID           int    `json:"room_id"`             // 指定すればそのルームに送る（グループルーム用）。なければ receiver_id との1対1ルーム
+	ParentMessageID  *int   `json:"parent_message_id"`   // スレッドに返信する場合
+	ReplyToMessageID *int   `json:"reply_to_message_id"` // 引用返信する場合
 }
 
-func SendMessage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
@@ -33,170 +35,115 @@ func SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	if strings.TrimSpace(req.Content) == "" {
-		http.Error(w, `{"error": "メッセージが空です"}`, http.StatusBadRequest)
-		return
+	roomID := req.RoomID
+	if roomID == 0 {
+		roomID, err = s.getOrCreateRoomID(userID, req.ReceiverID)
+		if err != nil {
+			http.Error(w, `{"error": "ルーム取得失敗"}`, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✅ RoomID=%d を取得", roomID)
 	}
 
-	roomID, err := getOrCreateRoomID(userID, req.ReceiverID)
+	msg, _, err := s.postMessage(models.Message{
+		SenderID:         userID,
+		RoomID:           roomID,
+		Content:          req.Content,
+		ParentMessageID:  req.ParentMessageID,
+		ReplyToMessageID: req.ReplyToMessageID,
+	})
 	if err != nil {
-		http.Error(w, `{"error": "ルーム取得失敗"}`, http.StatusInternalServerError)
+		writeServiceError(w, err, "保存失敗")
 		return
 	}
-	log.Printf("✅ RoomID=%d を取得", roomID)
 
-	var msg models.Message
-	msg.SenderID = userID
-	msg.RoomID = roomID
-	msg.Content = req.Content
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
 
-	err = db.Conn.QueryRow(`
-	INSERT INTO messages (sender_id, room_id, content, created_at)
-	VALUES ($1, $2, $3, NOW())
-	RETURNING id, created_at
-`, msg.SenderID, msg.RoomID, msg.Content).Scan(&msg.ID, &msg.Timestamp)
+func (s *Server) EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
 	if err != nil {
-		log.Println("❌ メッセージ保存失敗:", err)
-		http.Error(w, `{"error": "保存失敗"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	// 未読通知を送信（送信者以外のルームメンバーに通知）
-	rows, err := db.Conn.Query(`
-	SELECT user_id FROM room_members WHERE room_id = $1 AND user_id != $2
-`, msg.RoomID, msg.SenderID)
+	idStr := r.URL.Query().Get("id")
+	messageID, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println("❌ 未読通知のメンバー取得失敗:", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var toUserID int
-			if err := rows.Scan(&toUserID); err == nil {
-				payload := map[string]interface{}{
-					"type":    "unread",
-					"room_id": msg.RoomID,
-					"count":   1, // ※正確な未読数を出すなら再集計でもOK
-				}
-				NotifyUser(toUserID, payload)
-				log.Printf("📡 未読通知: user_id=%d room_id=%d", toUserID, msg.RoomID)
-			}
-		}
+		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		return
 	}
 
-	log.Printf("✅ メッセージ保存成功: messageID=%d", msg.ID)
-
-	err = models.InsertMessageReads(db.Conn, msg.ID, msg.RoomID)
-	if err != nil {
-		log.Printf("⚠️ message_reads 挿入エラー: %v", err)
-	}
-
-	// メッセージ送信後にBroadcast
-	BroadcastMessage(msg.RoomID, msg.ID, msg.SenderID, msg.Content, msg.Timestamp)
-
-	// 未読通知の追加（← この部分全体を上書き）
-	rows, err = db.Conn.Query(`
-	SELECT user_id FROM room_members
-	WHERE room_id = $1 AND user_id != $2
-`, msg.RoomID, msg.SenderID)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var uid int
-			if err := rows.Scan(&uid); err == nil {
-				var count int
-				err := db.Conn.QueryRow(`
-				SELECT COUNT(*)
-				FROM message_reads
-				WHERE user_id = $1 AND read_at IS NULL
-				  AND message_id IN (
-				    SELECT id FROM messages WHERE room_id = $2
-				  )
-			`, uid, msg.RoomID).Scan(&count)
-				if err != nil {
-					log.Printf("❌ 未読数取得失敗: user_id=%d room_id=%d", uid, msg.RoomID)
-					count = 0
-				}
-
-				NotifyUser(uid, map[string]interface{}{
-					"type":    "unread",
-					"room_id": msg.RoomID,
-					"count":   count,
-				})
-			}
-		}
+	var payload struct {
+		Content string `json:"content"`
 	}
-
-	// メンション処理（@ユーザー名）
-	mentionRegex := regexp.MustCompile(`@([\p{Hiragana}\p{Katakana}\p{Han}a-zA-Z0-9_]+)`)
-	matches := mentionRegex.FindAllStringSubmatch(msg.Content, -1)
-	for _, match := range matches {
-		if len(match) < 2 {
-			continue
-		}
-		username := match[1]
-		var mentionedUserID int
-		err := db.Conn.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&mentionedUserID)
-		if err == nil && mentionedUserID != msg.SenderID {
-			NotifyUser(mentionedUserID, map[string]interface{}{
-				"type":    "mention",
-				"user_id": msg.SenderID,
-				"message": msg.Content,
-			})
-		}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(msg)
+	if _, err := s.editMessage(userID, messageID, payload.Content); err != nil {
+		writeServiceError(w, err, "Failed to edit")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
-func EditMessage(w http.ResponseWriter, r *http.Request) {
+// GetMessageRevisions はメッセージの編集履歴を返す（ルームメンバーのみ）
+// GET /messages/revisions?message_id=xx
+func (s *Server) GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	idStr := r.URL.Query().Get("id")
-	messageID, err := strconv.Atoi(idStr)
+	messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
 	if err != nil {
-		http.Error(w, `{"error": "Invalid message ID"}`, http.StatusBadRequest)
+		http.Error(w, `{"error": "message_id の形式が正しくありません"}`, http.StatusBadRequest)
 		return
 	}
 
-	var payload struct {
-		Content string `json:"content"`
+	m, err := s.store.GetMessage(messageID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && m.IsDeleted()) {
+		// 削除済みメッセージの履歴は本文が残るので返さない
+		http.Error(w, `{"error": "メッセージが見つかりません"}`, http.StatusNotFound)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
 		return
 	}
 
-	_, err = db.Conn.Exec(`
-        UPDATE messages
-        SET content = $1, updated_at = NOW()
-        WHERE id = $2 AND sender_id = $3
-    `, payload.Content, messageID, userID)
+	isMember, err := s.store.IsRoomMember(m.RoomID, userID)
 	if err != nil {
-		log.Println("❌ Edit failed:", err)
-		http.Error(w, `{"error": "Failed to edit"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error": "DB error"}`, http.StatusInternalServerError)
 		return
 	}
-	var roomID int
-	err = db.Conn.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, messageID).Scan(&roomID)
-	if err == nil {
-		BroadcastEdit(roomID, messageID, payload.Content)
+	if !isMember {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
 
-	NotifyUser(userID, map[string]interface{}{
-		"type":       "edit",
-		"message_id": messageID,
-		"content":    payload.Content,
-	})
+	revisions, err := s.store.ListMessageRevisions(messageID)
+	if err != nil {
+		log.Println("❌ 編集履歴取得失敗:", err)
+		http.Error(w, `{"error": "編集履歴の取得に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
 
+	// revisions は編集前の本文（古い順）、content / edited_at は現在の本文と最終編集時刻
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": m.ID,
+		"content":    m.Content,
+		"edited_at":  m.EditedAt,
+		"revisions":  revisions,
+	})
 }
 
-func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -215,44 +162,52 @@ func DeleteMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 投稿者のユーザーIDと一致するか確認（セキュリティ）
-	var senderID int
-	err = db.Conn.QueryRow("SELECT sender_id FROM messages WHERE id = $1", id).Scan(&senderID)
-	if err != nil {
-		http.Error(w, "message not found", http.StatusNotFound)
+	// 投稿者本人だけが削除できる
+	if err := s.deleteMessage(userID, id); err != nil {
+		writeServiceError(w, err, "delete failed")
 		return
 	}
-	if senderID != userID {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
-	}
-
-	// メッセージ内容を論理削除
-	_, err = db.Conn.Exec(`UPDATE messages SET content = 'このメッセージは削除されました' WHERE id = $1`, id)
-	if err != nil {
-		http.Error(w, "delete failed", http.StatusInternalServerError)
-		return
-	}
-
-	var roomID int
-	err = db.Conn.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, id).Scan(&roomID)
-	if err == nil {
-		BroadcastDelete(roomID, id)
-	}
 	w.WriteHeader(http.StatusOK)
+}
 
-	NotifyUser(userID, map[string]interface{}{
-		"type":       "delete",
-		"message_id": id,
-	})
+// MessageWithStatus は read_at と reactions を付けたメッセージ
+type MessageWithStatus struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	SenderID  int        `json:"sender_id"`
+	Content   string     `json:"content"`
+	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"edited_at"`
+	Deleted   bool       `json:"deleted"` // true なら content は空
+	ReadAt    *time.Time `json:"read_at"`
+	Reactions []Reaction `json:"reactions"`
+
+	ParentMessageID *int       `json:"parent_message_id,omitempty"` // スレッドの返信なら親のID
+	ReplyCount      int        `json:"reply_count"`
+	LastReplyAt     *time.Time `json:"last_reply_at"`
+
+	ReplyTo *models.QuotePreview `json:"reply_to,omitempty"` // 引用返信の引用元
+}
+
+type Reaction struct {
+	UserID int    `json:"user_id"`
+	Emoji  string `json:"emoji"`
+}
 
+// MessagePage は GET /messages のレスポンス。
+// prev_cursor は before=、next_cursor は after= に渡して続きを取得する（続きがなければ null）。
+type MessagePage struct {
+	Messages   []MessageWithStatus `json:"messages"`
+	PrevCursor *string             `json:"prev_cursor"`
+	NextCursor *string             `json:"next_cursor"`
 }
 
 // メッセージ取得（read_at + reactions付き）
-func GetMessages(w http.ResponseWriter, r *http.Request) {
+// GET /messages?room_id=xx[&before=cursor|&after=cursor][&limit=50]
+func (s *Server) GetMessages(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
-		http.Error(w, `{"error": "Unauthorized: `+err.Error()+`"}`, http.StatusUnauthorized)
+		writeJSONError(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -268,125 +223,293 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := parsePageQuery(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("📥 メッセージ取得: roomID=%d", roomID)
 
-	// 永続既読更新
-	_, err = db.Conn.Exec(`
-		UPDATE message_reads
-		SET read_at = NOW()
-		WHERE message_id IN (
-			SELECT id FROM messages
-			WHERE room_id = $1 AND sender_id != $2
-		)
-		AND user_id = $2 AND read_at IS NULL
-	`, roomID, userID)
+	resp, err := s.listMessages(userID, roomID, page)
 	if err != nil {
-		log.Println("❌ 既読UPDATE失敗:", err)
+		writeServiceError(w, err, "メッセージ取得に失敗しました")
+		return
 	}
 
-	// 既読通知
-	rowsNotify, err := db.Conn.Query(`
-		SELECT m.id, m.sender_id, mr.read_at
-		FROM messages m
-		JOIN message_reads mr ON m.id = mr.message_id
-		WHERE m.room_id = $1 AND mr.user_id = $2
-		  AND mr.read_at IS NOT NULL
-		  AND m.sender_id != $2
-	`, roomID, userID)
-	if err == nil {
-		defer rowsNotify.Close()
-		for rowsNotify.Next() {
-			var messageID, senderID int
-			var readAt time.Time
-			if err := rowsNotify.Scan(&messageID, &senderID, &readAt); err == nil {
-				NotifyUser(senderID, map[string]interface{}{
-					"type":       "read",
-					"message_id": messageID,
-					"read_at":    readAt.Format(time.RFC3339),
-				})
-				log.Printf("📡 既読通知: message_id=%d → sender_id=%d", messageID, senderID)
-			}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// pageCursors は取得結果から prev_cursor / next_cursor を決める（続きがなければ nil）
+func pageCursors(rows []models.Message, page models.PageQuery, hasMore bool) (prev, next *string) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	first := models.CursorOf(rows[0]).Encode()
+	last := models.CursorOf(rows[len(rows)-1]).Encode()
+	switch {
+	case page.After != nil:
+		// 取得範囲より前には必ずカーソルのメッセージがある
+		prev = &first
+		if hasMore {
+			next = &last
+		}
+	case page.Before != nil:
+		if hasMore {
+			prev = &first
+		}
+		next = &last
+	default:
+		if hasMore {
+			prev = &first
 		}
 	}
+	return prev, next
+}
+
+// GetMessageContext は指定メッセージの前後を返す（メンション通知・検索結果からのジャンプ用）
+// GET /messages/context?message_id=xx[&before_count=25][&after_count=25]
+func (s *Server) GetMessageContext(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, `{"error": "message_id の形式が正しくありません"}`, http.StatusBadRequest)
+		return
+	}
+	beforeCount, err1 := parseCount(r, "before_count")
+	afterCount, err2 := parseCount(r, "after_count")
+	if err1 != nil || err2 != nil {
+		http.Error(w, `{"error": "before_count / after_count は0以上の数値である必要があります"}`, http.StatusBadRequest)
+		return
+	}
 
-	// メッセージ取得
-	type MessageWithStatus struct {
-		ID        int        `json:"id"`
-		RoomID    int        `json:"room_id"`
-		SenderID  int        `json:"sender_id"`
-		Content   string     `json:"content"`
-		Timestamp time.Time  `json:"timestamp"`
-		ReadAt    *time.Time `json:"read_at"`
-		Reactions []struct {
-			UserID int    `json:"user_id"`
-			Emoji  string `json:"emoji"`
-		} `json:"reactions"`
-	}
-
-	rows, err := db.Conn.Query(`
-		SELECT id, room_id, sender_id, content, created_at
-		FROM messages
-		WHERE room_id = $1
-		ORDER BY created_at ASC
-	`, roomID)
+	anchor, err := s.store.GetMessage(messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, `{"error": "メッセージが見つかりません"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Println("❌ メッセージSELECT失敗:", err)
 		http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	messages := []MessageWithStatus{}
-	messageIDMap := make(map[int]*MessageWithStatus)
-	for rows.Next() {
-		var msg MessageWithStatus
-		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Content, &msg.Timestamp); err != nil {
-			log.Println("❌ rows.Scan失敗:", err)
-			http.Error(w, `{"error": "読み込みエラー"}`, http.StatusInternalServerError)
+	// メッセージのルームのメンバーだけが閲覧できる
+	isMember, err := s.store.IsRoomMember(anchor.RoomID, userID)
+	if err != nil {
+		http.Error(w, `{"error": "DB error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	// スレッドの返信はタイムラインに出ないので、親の前後を返す（返信のIDは thread_reply_id）
+	var threadReplyID *int
+	if anchor.ParentMessageID != nil {
+		replyID := anchor.ID
+		threadReplyID = &replyID
+		if anchor, err = s.store.GetMessage(*anchor.ParentMessageID); err != nil {
+			http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
 			return
 		}
-		messages = append(messages, msg)
-		messageIDMap[msg.ID] = &messages[len(messages)-1]
-	}
-
-	r2, err := db.Conn.Query(`
-		SELECT message_id, user_id, reaction, read_at
-		FROM message_reads
-		WHERE message_id IN (
-			SELECT id FROM messages WHERE room_id = $1
-		)
-	`, roomID)
-	if err == nil {
-		defer r2.Close()
-		for r2.Next() {
-			var mid, uid int
-			var emoji sql.NullString
-			var readAt sql.NullTime
-			if err := r2.Scan(&mid, &uid, &emoji, &readAt); err == nil {
-				if m, ok := messageIDMap[mid]; ok {
-					if emoji.Valid {
-						m.Reactions = append(m.Reactions, struct {
-							UserID int    `json:"user_id"`
-							Emoji  string `json:"emoji"`
-						}{UserID: uid, Emoji: emoji.String})
-					}
-					if uid != userID && readAt.Valid {
-						if m.ReadAt == nil || readAt.Time.Before(*m.ReadAt) {
-							m.ReadAt = &readAt.Time
-						}
-					}
-
-				}
+	}
+
+	cursor := models.CursorOf(*anchor)
+	older, olderHasMore, err := s.store.ListMessagesPage(anchor.RoomID, models.PageQuery{Before: &cursor, Limit: beforeCount})
+	if err != nil {
+		log.Println("❌ 前方メッセージ取得失敗:", err)
+		http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+	newer, newerHasMore, err := s.store.ListMessagesPage(anchor.RoomID, models.PageQuery{After: &cursor, Limit: afterCount})
+	if err != nil {
+		log.Println("❌ 後方メッセージ取得失敗:", err)
+		http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]models.Message, 0, len(older)+1+len(newer))
+	rows = append(rows, older...)
+	rows = append(rows, *anchor)
+	rows = append(rows, newer...)
+
+	messages, err := s.withReadStatus(rows, userID)
+	if err != nil {
+		log.Println("❌ message_reads 取得失敗:", err)
+	}
+
+	resp := struct {
+		MessagePage
+		AnchorID      int  `json:"anchor_id"`
+		ThreadReplyID *int `json:"thread_reply_id,omitempty"`
+	}{MessagePage: MessagePage{Messages: messages}, AnchorID: anchor.ID, ThreadReplyID: threadReplyID}
+	if olderHasMore {
+		first := models.CursorOf(rows[0]).Encode()
+		resp.PrevCursor = &first
+	}
+	if newerHasMore {
+		last := models.CursorOf(rows[len(rows)-1]).Encode()
+		resp.NextCursor = &last
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseCount は前後の件数クエリを読み取る（未指定なら25件、上限は MaxPageLimit）
+func parseCount(r *http.Request, key string) (int, error) {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return 25, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid count")
+	}
+	if n > models.MaxPageLimit {
+		n = models.MaxPageLimit
+	}
+	return n, nil
+}
+
+// parsePageQuery は before / after / limit クエリを読み取る
+func parsePageQuery(r *http.Request) (models.PageQuery, error) {
+	limit := models.DefaultPageLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return models.PageQuery{}, errors.New("limit は1以上の数値である必要があります")
+		}
+	}
+	return pageQueryOf(r.URL.Query().Get("before"), r.URL.Query().Get("after"), limit)
+}
+
+// pageQueryOf はカーソルと件数から PageQuery を作る（件数は MaxPageLimit まで）
+func pageQueryOf(before, after string, limit int) (models.PageQuery, error) {
+	q := models.PageQuery{Limit: min(limit, models.MaxPageLimit)}
+	if before != "" && after != "" {
+		return q, errors.New("before と after は同時に指定できません")
+	}
+	if before != "" {
+		c, err := models.DecodeCursor(before)
+		if err != nil {
+			return q, err
+		}
+		q.Before = &c
+	}
+	if after != "" {
+		c, err := models.DecodeCursor(after)
+		if err != nil {
+			return q, err
+		}
+		q.After = &c
+	}
+	return q, nil
+}
+
+// withReadStatus は渡されたメッセージ分だけ reactions と read_at を読み込んで付与する
+func (s *Server) withReadStatus(rows []models.Message, viewerID int) ([]MessageWithStatus, error) {
+	previews, err := s.quotePreviews(rows)
+	if err != nil {
+		log.Println("❌ 引用プレビュー取得失敗:", err)
+	}
+
+	messages := make([]MessageWithStatus, len(rows))
+	messageIDMap := make(map[int]*MessageWithStatus, len(rows))
+	ids := make([]int, len(rows))
+	for i, m := range rows {
+		messages[i] = MessageWithStatus{
+			ID:        m.ID,
+			RoomID:    m.RoomID,
+			SenderID:  m.SenderID,
+			Content:   m.Content,
+			Timestamp: m.Timestamp,
+			EditedAt:  m.EditedAt,
+
+			ParentMessageID: m.ParentMessageID,
+			ReplyCount:      m.ReplyCount,
+			LastReplyAt:     m.LastReplyAt,
+		}
+		if m.ReplyToMessageID != nil {
+			if p, ok := previews[*m.ReplyToMessageID]; ok {
+				messages[i].ReplyTo = &p
 			}
 		}
+		if m.IsDeleted() {
+			// 削除済みはトゥームストーンだけ返す（本文・リアクションは見せない）
+			messages[i].Content = ""
+			messages[i].Deleted = true
+		}
+		messageIDMap[m.ID] = &messages[i]
+		ids[i] = m.ID
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	reads, err := s.store.ListMessageReads(ids)
+	if err != nil {
+		return messages, err
+	}
+	for _, rd := range reads {
+		m, ok := messageIDMap[rd.MessageID]
+		if !ok {
+			continue
+		}
+		if rd.Reaction != nil && !m.Deleted {
+			m.Reactions = append(m.Reactions, Reaction{UserID: rd.UserID, Emoji: *rd.Reaction})
+		}
+		if rd.UserID != viewerID && rd.ReadAt != nil {
+			if m.ReadAt == nil || rd.ReadAt.Before(*m.ReadAt) {
+				m.ReadAt = rd.ReadAt
+			}
+		}
+	}
+	return messages, nil
+}
+
+// markRoomReadAndNotify はルームの未読を既読にし、今回既読になったメッセージの送信者へ通知する
+func (s *Server) markRoomReadAndNotify(roomID, readerID int) {
+	updates, err := models.MarkAllMessagesAsRead(s.store, roomID, readerID)
+	if err != nil {
+		log.Println("❌ 既読UPDATE失敗:", err)
+		return
+	}
+	s.notifyReadUpdates(updates)
+	if len(updates) > 0 {
+		// 読んだ本人の他の端末のバッジも揃える
+		s.NotifyUnreadCount(readerID, roomID)
+	}
+}
+
+// notifyReadUpdates は今回既読になったメッセージの送信者へ "read" を通知する。
+// 同じ既読の操作で既読になった分は read_at が同じなので、送信者ごとに1件にまとめて送る。
+func (s *Server) notifyReadUpdates(updates []models.ReadUpdate) {
+	events := make(map[int]*protocol.ReadEvent)
+	var senders []int
+	for _, u := range updates {
+		ev, ok := events[u.SenderID]
+		if !ok {
+			ev = &protocol.ReadEvent{ReadAt: u.ReadAt}
+			events[u.SenderID] = ev
+			senders = append(senders, u.SenderID)
+		}
+		ev.MessageIDs = append(ev.MessageIDs, u.ID)
+	}
+	for _, senderID := range senders {
+		ev := events[senderID]
+		s.NotifyUser(senderID, *ev)
+		log.Printf("📡 既読通知: %d件 → sender_id=%d", len(ev.MessageIDs), senderID)
+	}
 }
 
 // MarkAllAsRead は部屋単位のメッセージをすべて既読にする
-func MarkAllAsRead(w http.ResponseWriter, r *http.Request) {
+func (s *Server) MarkAllAsRead(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
@@ -404,77 +527,22 @@ func MarkAllAsRead(w http.ResponseWriter, r *http.Request) {
 
 	// === ① ルーム全体の既読処理 ===
 	if payload.RoomID != nil {
-		_, err = db.Conn.Exec(`
-			UPDATE message_reads
-			SET read_at = NOW()
-			WHERE message_id IN (
-				SELECT id FROM messages
-				WHERE room_id = $1 AND sender_id != $2
-			)
-			AND user_id = $2 AND read_at IS NULL
-		`, *payload.RoomID, userID)
-		if err != nil {
-			log.Println("❌ 既読UPDATE失敗:", err)
-		}
-
-		rows, err := db.Conn.Query(`
-			SELECT m.id, m.sender_id, mr.read_at
-			FROM messages m
-			JOIN message_reads mr ON m.id = mr.message_id
-			WHERE m.room_id = $1 AND mr.user_id = $2
-				AND mr.read_at IS NOT NULL
-				AND m.sender_id != $2
-		`, *payload.RoomID, userID)
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var messageID, senderID int
-				var readAt time.Time
-				if err := rows.Scan(&messageID, &senderID, &readAt); err == nil {
-					NotifyUser(senderID, map[string]interface{}{
-						"type":       "read",
-						"message_id": messageID,
-						"read_at":    readAt.Format(time.RFC3339),
-					})
-					log.Printf("📡 既読通知: message_id=%d → sender_id=%d", messageID, senderID)
-				}
-			}
-		}
+		err = s.markRoomRead(userID, *payload.RoomID)
 
 		// === ② 単一メッセージの既読処理 ===
 	} else if payload.MessageID != nil {
-		_, err = db.Conn.Exec(`
-			UPDATE message_reads
-			SET read_at = NOW()
-			WHERE message_id = $1 AND user_id = $2 AND read_at IS NULL
-		`, *payload.MessageID, userID)
-		if err != nil {
-			log.Println("❌ 単一既読UPDATE失敗:", err)
-		}
-
-		var senderID int
-		var readAt time.Time
-		err = db.Conn.QueryRow(`
-			SELECT m.sender_id, mr.read_at
-			FROM messages m
-			JOIN message_reads mr ON m.id = mr.message_id
-			WHERE m.id = $1 AND mr.user_id = $2
-		`, *payload.MessageID, userID).Scan(&senderID, &readAt)
-		if err == nil {
-			NotifyUser(senderID, map[string]interface{}{
-				"type":       "read",
-				"message_id": *payload.MessageID,
-				"read_at":    readAt.Format(time.RFC3339),
-			})
-			log.Printf("📡 単一既読通知: message_id=%d → sender_id=%d", *payload.MessageID, senderID)
-		}
+		err = s.markMessageRead(userID, *payload.MessageID)
+	}
+	if err != nil {
+		writeServiceError(w, err, "既読にできませんでした")
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
 // AddReaction はメッセージにリアクションを追加・更新・削除する
-func AddReaction(w http.ResponseWriter, r *http.Request) {
+func (s *Server) AddReaction(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -490,65 +558,9 @@ func AddReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var current *string
-	err = db.Conn.QueryRow(`
-		SELECT reaction FROM message_reads
-		WHERE message_id = $1 AND user_id = $2
-	`, payload.MessageID, userID).Scan(&current)
-
-	if err != nil && err.Error() != "sql: no rows in result set" {
-		http.Error(w, `{"error": "DB read failed"}`, http.StatusInternalServerError)
-		return
-	}
-
-	if current != nil && *current == payload.Emoji {
-		// 同じ絵文字ならリアクション削除
-		_, err = db.Conn.Exec(`
-			UPDATE message_reads SET reaction = NULL WHERE message_id=$1 AND user_id=$2
-		`, payload.MessageID, userID)
-	} else if current == nil {
-		// 新規リアクション
-		_, err = db.Conn.Exec(`
-			INSERT INTO message_reads (message_id, user_id, reaction, read_at)
-			VALUES ($1, $2, $3, NOW())
-		`, payload.MessageID, userID, payload.Emoji)
-	} else {
-		// リアクション更新
-		_, err = db.Conn.Exec(`
-			UPDATE message_reads SET reaction = $3 WHERE message_id=$1 AND user_id=$2
-		`, payload.MessageID, userID, payload.Emoji)
-	}
-
-	if err != nil {
-		http.Error(w, `{"error": "DB update failed"}`, http.StatusInternalServerError)
-		return
-	}
-
-	// WebSocket通知
-	NotifyUser(userID, map[string]interface{}{
-		"type":       "reaction",
-		"message_id": payload.MessageID,
-		"emoji":      payload.Emoji,
-		"user_id":    userID,
-	})
-
-	w.WriteHeader(http.StatusOK)
-}
-
-// 完全削除: メッセージをDBから削除する
-func HardDeleteMessage(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
-		return
-	}
-
-	_, err = db.Conn.Exec("DELETE FROM messages WHERE id = $1", id)
-	if err != nil {
-		http.Error(w, "failed to delete message", http.StatusInternalServerError)
+	if _, err := s.reactToMessage(userID, payload.MessageID, payload.Emoji); err != nil {
+		writeServiceError(w, err, "DB update failed")
 		return
 	}
-
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/protocol"
+	"backend/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// メッセージの操作を拒否する理由（HTTP は 4xx、WebSocket は rejected、JSON-RPC はエラーコードとして返す）
+var (
+	errEmptyMessage     = errors.New("メッセージが空です")
+	errNotRoomMember    = errors.New("このルームのメンバーではありません")
+	errMessageNotFound  = errors.New("メッセージが見つかりません")
+	errNotMessageSender = errors.New("本人のメッセージではありません")
+)
+
+var mentionRegex = regexp.MustCompile(`@([\p{Hiragana}\p{Katakana}\p{Han}a-zA-Z0-9_]+)`)
+
+// postMessage は HTTP (POST /messages)・WebSocket ("message")・JSON-RPC (sendMessage) に共通のメッセージ送信処理。
+// msg.SenderID は認証済みのユーザーであること。空チェック・ルームメンバーの確認・返信先と引用元の検証をしてから保存し、
+// メッセージ（スレッドの返信ならスレッドの通知）・未読数・メンションを配信する。
+// msg.ClientRequestID が保存済みなら、保存も配信もせずに保存済みのメッセージと dup=true を返す。
+func (s *Server) postMessage(msg models.Message) (_ models.Message, dup bool, err error) {
+	if strings.TrimSpace(msg.Content) == "" {
+		return msg, false, errEmptyMessage
+	}
+	if err := s.requireRoomMember(msg.RoomID, msg.SenderID); err != nil {
+		return msg, false, err
+	}
+	if err := s.resolveThreadParent(&msg); err != nil {
+		return msg, false, err
+	}
+	if err := s.resolveReplyTo(&msg); err != nil {
+		return msg, false, err
+	}
+
+	err = s.store.CreateMessage(&msg)
+	if errors.Is(err, store.ErrDuplicateRequest) {
+		log.Printf("🔁 保存済みのメッセージの再送: messageID=%d request_id=%s", msg.ID, msg.ClientRequestID)
+		return msg, true, nil
+	}
+	if err != nil {
+		return msg, false, fmt.Errorf("メッセージ保存失敗: %w", err)
+	}
+	log.Printf("✅ メッセージ保存成功: messageID=%d", msg.ID)
+
+	// 送信したら入力中は終わり
+	if s.typing.has(msg.RoomID, msg.SenderID) {
+		if err := s.publishTyping(msg.RoomID, msg.SenderID, false); err != nil {
+			log.Printf("❌ %v: userID=%d roomID=%d", err, msg.SenderID, msg.RoomID)
+		}
+	}
+
+	if err := models.InsertMessageReads(s.store, msg.ID, msg.RoomID); err != nil {
+		log.Printf("⚠️ message_reads 挿入エラー: %v", err)
+	}
+
+	// スレッドの返信はタイムラインに流さず、スレッドの通知だけ送る
+	if msg.ParentMessageID != nil {
+		s.notifyThreadReply(msg)
+	} else {
+		s.BroadcastMessage(msg.RoomID, msg.ID, msg.SenderID, msg.Content, msg.Timestamp, s.quotePreviewFor(msg))
+	}
+
+	// 📡 未読バッジ通知（自分の端末のバッジも揃えるため送信者にも送る）
+	members, err := s.store.ListRoomMemberIDs(msg.RoomID)
+	if err != nil {
+		log.Println("❌ 未読通知のメンバー取得失敗:", err)
+	}
+	for _, uid := range members {
+		count, err := s.countUnread(uid, msg.RoomID)
+		if err != nil {
+			log.Printf("❌ 未読数取得失敗: userID=%d roomID=%d err=%v", uid, msg.RoomID, err)
+			continue
+		}
+		s.NotifyUser(uid, protocol.UnreadEvent{RoomID: msg.RoomID, Count: count})
+	}
+
+	s.notifyMentions(msg)
+	return msg, false, nil
+}
+
+// editMessage は本人のメッセージの本文を書き換え、ルームに編集を知らせる（本人の全端末にもメンバーとして届く）
+func (s *Server) editMessage(userID, messageID int, content string) (*models.Message, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, errEmptyMessage
+	}
+	if _, err := s.ownMessage(userID, messageID); err != nil {
+		return nil, err
+	}
+
+	err := s.store.UpdateMessageContent(messageID, userID, content)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errMessageNotFound // 確認した直後に削除された
+	}
+	if err != nil {
+		return nil, fmt.Errorf("メッセージ編集失敗: %w", err)
+	}
+	m, err := s.store.GetMessage(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("編集後のメッセージ取得失敗: %w", err)
+	}
+	log.Printf("✏️ メッセージ編集: messageID=%d userID=%d", messageID, userID)
+
+	s.BroadcastEdit(m.RoomID, messageID, m.Content, m.EditedAt)
+	s.notifyQuoteUpdate(*m)
+	return m, nil
+}
+
+// deleteMessage は本人のメッセージを論理削除し、ルームに削除を知らせる（本人の全端末にもメンバーとして届く）
+// （本文は保持期間が過ぎるまで残り、管理者が復元できる）
+func (s *Server) deleteMessage(userID, messageID int) error {
+	if _, err := s.ownMessage(userID, messageID); err != nil {
+		return err
+	}
+
+	err := s.store.SoftDeleteMessage(messageID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("メッセージ削除失敗: %w", err)
+	}
+	log.Printf("🗑️ メッセージ削除: messageID=%d userID=%d", messageID, userID)
+
+	if m, err := s.store.GetMessage(messageID); err == nil {
+		s.BroadcastDelete(m.RoomID, messageID)
+		s.notifyQuoteUpdate(*m)
+	}
+	return nil
+}
+
+// hardDeleteMessage はメッセージを完全に削除し（管理者用。復元できない）、ルームに削除を知らせる。
+// スレッドの親なら返信もまとめて消える。返信なら親の返信数の変化も知らせる。
+func (s *Server) hardDeleteMessage(adminID, messageID int) error {
+	m, err := s.store.GetMessage(messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("メッセージ取得失敗: %w", err)
+	}
+
+	// 削除すると引用の参照も消えるので、引用されているかは先に調べる
+	quoted, err := s.store.IsMessageQuoted(messageID)
+	if err != nil {
+		return fmt.Errorf("引用の有無の確認失敗: %w", err)
+	}
+	if err := s.store.HardDeleteMessage(messageID); err != nil {
+		return fmt.Errorf("メッセージ完全削除失敗: %w", err)
+	}
+	log.Printf("💥 メッセージ完全削除: messageID=%d adminID=%d", messageID, adminID)
+
+	s.BroadcastDelete(m.RoomID, messageID)
+	if quoted {
+		if !m.IsDeleted() {
+			now := time.Now()
+			m.DeletedAt = &now
+		}
+		s.publishQuoteUpdate(*m)
+	}
+	if m.ParentMessageID != nil {
+		if root, err := s.store.GetMessage(*m.ParentMessageID); err == nil {
+			s.notifyThreadUpdate(*root)
+		}
+	}
+	return nil
+}
+
+// ownMessage は userID が送った、削除されていないメッセージを返す
+func (s *Server) ownMessage(userID, messageID int) (*models.Message, error) {
+	m, err := s.store.GetMessage(messageID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && m.IsDeleted()) {
+		return nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("メッセージ取得失敗: %w", err)
+	}
+	if m.SenderID != userID {
+		return nil, errNotMessageSender
+	}
+	return m, nil
+}
+
+// listMessages はルームのメッセージを1ページ分返す（read_at と reactions 付き）。
+// 開いたルームは既読にして、送信者に既読を知らせる。
+func (s *Server) listMessages(userID, roomID int, page models.PageQuery) (MessagePage, error) {
+	if err := s.requireRoomMember(roomID, userID); err != nil {
+		return MessagePage{}, err
+	}
+
+	// 永続既読更新 + 既読通知
+	s.markRoomReadAndNotify(roomID, userID)
+
+	rows, hasMore, err := s.store.ListMessagesPage(roomID, page)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("メッセージSELECT失敗: %w", err)
+	}
+	messages, err := s.withReadStatus(rows, userID)
+	if err != nil {
+		log.Println("❌ message_reads 取得失敗:", err)
+	}
+
+	resp := MessagePage{Messages: messages}
+	resp.PrevCursor, resp.NextCursor = pageCursors(rows, page, hasMore)
+	return resp, nil
+}
+
+// markRoomRead はルームのメッセージをすべて既読にする
+func (s *Server) markRoomRead(userID, roomID int) error {
+	if err := s.requireRoomMember(roomID, userID); err != nil {
+		return err
+	}
+	s.markRoomReadAndNotify(roomID, userID)
+	return nil
+}
+
+// markMessageRead はメッセージを1件既読にして、送信者に知らせる。
+// 既読の行は保存時にルームのメンバー（送信者以外）にだけ作るので、メンバーでなければ見つからない扱いになる。
+func (s *Server) markMessageRead(userID, messageID int) error {
+	receipt, err := s.store.MarkMessageRead(messageID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errMessageNotFound // 存在しない・自分宛てでない（既読の行がない）
+	}
+	if err != nil {
+		return fmt.Errorf("単一既読UPDATE失敗: %w", err)
+	}
+	s.NotifyUser(receipt.SenderID, protocol.ReadEvent{MessageIDs: []int{messageID}, ReadAt: receipt.ReadAt})
+	log.Printf("📡 単一既読通知: message_id=%d → sender_id=%d", messageID, receipt.SenderID)
+
+	// 読んだ本人の他の端末のバッジも揃える
+	if m, err := s.store.GetMessage(messageID); err == nil {
+		s.NotifyUnreadCount(userID, m.RoomID)
+	}
+	return nil
+}
+
+func (s *Server) requireRoomMember(roomID, userID int) error {
+	isMember, err := s.store.IsRoomMember(roomID, userID)
+	if err != nil {
+		return fmt.Errorf("ルームメンバー確認失敗: %w", err)
+	}
+	if !isMember {
+		return errNotRoomMember
+	}
+	return nil
+}
+
+// isMessageRejection はエラーが入力の誤り・権限によるもの（サーバー側の失敗でない）かどうか
+func isMessageRejection(err error) bool {
+	return serviceErrorStatus(err) != http.StatusInternalServerError
+}
+
+// serviceErrorStatus はメッセージ・ルームの操作のエラーに対応する HTTP ステータスを返す
+func serviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotRoomMember), errors.Is(err, errNotMessageSender):
+		return http.StatusForbidden
+	case errors.Is(err, errMessageNotFound), errors.Is(err, errMessageDeleted):
+		return http.StatusNotFound
+	case errors.Is(err, errEmptyMessage), errors.Is(err, errInvalidParent), errors.Is(err, errInvalidReplyTo):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// writeServiceError は操作のエラーをエラーレスポンスにする。サーバー側の失敗はログに残し、message だけ返す。
+func writeServiceError(w http.ResponseWriter, err error, message string) {
+	status := serviceErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("❌ %s: %v", message, err)
+		writeJSONError(w, message, status)
+		return
+	}
+	writeJSONError(w, err.Error(), status)
+}
+
+// writeJSONError は {"error": message} のエラーレスポンスを書く。
+// message に入力由来の文字列（引用符など）が含まれても壊れないよう、JSON はエンコーダーで作る。
+func writeJSONError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// notifyMentions は本文の @ユーザー名 に当たるユーザーにメンションを通知する
+func (s *Server) notifyMentions(msg models.Message) {
+	for _, match := range mentionRegex.FindAllStringSubmatch(msg.Content, -1) {
+		mentioned, err := s.store.GetUserByUsername(match[1])
+		if err == nil && mentioned.ID != msg.SenderID {
+			s.NotifyUser(mentioned.ID, protocol.MentionEvent{
+				From:    msg.SenderID,
+				RoomID:  msg.RoomID,
+				Message: msg.Content,
+			})
+		}
+	}
+}
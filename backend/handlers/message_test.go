@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/models"
+	"backend/store"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startChat はサーバーを起動し、alice と bob の1対1ルームを作る
+func startChat(t *testing.T) (ts *httptest.Server, alice, bob testUser, roomID int) {
+	t.Helper()
+	ts = startTestServer(t, store.NewMemory(), bus.NewLocal())
+	alice = signUp(t, ts, "alice")
+	bob = signUp(t, ts, "bob")
+	return ts, alice, bob, openRoom(t, ts, alice, bob)
+}
+
+// sendMessage は u として roomID にメッセージを送り、保存されたメッセージのIDを返す
+func sendMessage(t *testing.T, ts *httptest.Server, u testUser, body map[string]any) int {
+	t.Helper()
+	var msg struct {
+		ID int `json:"id"`
+	}
+	decodeBody(t, u.do(t, ts, "POST", "/messages", body), http.StatusOK, &msg)
+	return msg.ID
+}
+
+// getMessages は u として roomID のメッセージを取得する
+func getMessages(t *testing.T, ts *httptest.Server, u testUser, roomID int) []MessageWithStatus {
+	t.Helper()
+	var page MessagePage
+	decodeBody(t, u.do(t, ts, "GET", fmt.Sprintf("/messages?room_id=%d", roomID), nil), http.StatusOK, &page)
+	return page.Messages
+}
+
+func TestSendAndGetMessages(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+
+	first := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "おはよう"})
+	// room_id がなければ receiver_id との1対1ルームに送る
+	second := sendMessage(t, ts, bob, map[string]any{"receiver_id": alice.ID, "content": "おはようございます"})
+
+	msgs := getMessages(t, ts, alice, roomID)
+	if len(msgs) != 2 {
+		t.Fatalf("メッセージ数 = %d, want 2: %+v", len(msgs), msgs)
+	}
+	if msgs[0].ID != first || msgs[0].SenderID != alice.ID || msgs[0].Content != "おはよう" {
+		t.Errorf("1件目 = %+v", msgs[0])
+	}
+	if msgs[1].ID != second || msgs[1].SenderID != bob.ID || msgs[1].RoomID != roomID {
+		t.Errorf("2件目 = %+v", msgs[1])
+	}
+
+	// メンバーでなければ送信も取得もできない
+	carol := signUp(t, ts, "carol")
+	decodeBody(t, carol.do(t, ts, "POST", "/messages", map[string]any{"room_id": roomID, "content": "こんにちは"}), http.StatusForbidden, nil)
+	decodeBody(t, carol.do(t, ts, "GET", fmt.Sprintf("/messages?room_id=%d", roomID), nil), http.StatusForbidden, nil)
+}
+
+func TestMarkAsRead(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	id := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "読んだら教えて"})
+
+	if msgs := getMessages(t, ts, alice, roomID); msgs[0].ReadAt != nil {
+		t.Fatalf("読まれる前から read_at があります: %v", msgs[0].ReadAt)
+	}
+
+	decodeBody(t, bob.do(t, ts, "POST", "/messages/read", map[string]any{"message_id": id}), http.StatusOK, nil)
+
+	if msgs := getMessages(t, ts, alice, roomID); msgs[0].ReadAt == nil {
+		t.Error("bob が既読にしたのに read_at がありません")
+	}
+
+	// メンバー以外は既読にできない
+	carol := signUp(t, ts, "carol")
+	decodeBody(t, carol.do(t, ts, "POST", "/messages/read", map[string]any{"message_id": id}), http.StatusNotFound, nil)
+}
+
+func TestReactions(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	id := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "ランチ行きましょう"})
+
+	react := func(u testUser, emoji string, want int) {
+		t.Helper()
+		decodeBody(t, u.do(t, ts, "POST", "/reactions", map[string]any{"message_id": id, "emoji": emoji}), want, nil)
+	}
+
+	react(bob, "👍", http.StatusOK)
+	msgs := getMessages(t, ts, alice, roomID)
+	if len(msgs[0].Reactions) != 1 || msgs[0].Reactions[0] != (Reaction{UserID: bob.ID, Emoji: "👍"}) {
+		t.Fatalf("リアクション = %+v", msgs[0].Reactions)
+	}
+
+	// 別の絵文字なら付け替え、同じ絵文字なら取り消し
+	react(bob, "🎉", http.StatusOK)
+	if msgs := getMessages(t, ts, alice, roomID); len(msgs[0].Reactions) != 1 || msgs[0].Reactions[0].Emoji != "🎉" {
+		t.Fatalf("付け替え後のリアクション = %+v", msgs[0].Reactions)
+	}
+	react(bob, "🎉", http.StatusOK)
+	if msgs := getMessages(t, ts, alice, roomID); len(msgs[0].Reactions) != 0 {
+		t.Fatalf("取り消し後のリアクション = %+v", msgs[0].Reactions)
+	}
+
+	carol := signUp(t, ts, "carol")
+	react(carol, "👍", http.StatusForbidden)
+}
+
+// ルームを開いて既読になったメッセージは、送信者に1件の "read" にまとめて知らせる
+func TestReadEventPerSender(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	var ids []any
+	for _, content := range []string{"1つ目", "2つ目", "3つ目"} {
+		ids = append(ids, float64(sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": content})))
+	}
+	aliceWS := dialWS(t, ts, alice)
+
+	getMessages(t, ts, bob, roomID)
+	sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "読みました"})
+
+	frames := readFramesUntil(t, aliceWS, "message")
+	if n := count(typesOf(frames), "read"); n != 1 {
+		t.Fatalf("read の件数 = %d, want 1: %v", n, typesOf(frames))
+	}
+	for _, f := range frames {
+		if f["type"] == "read" && (!slices.Equal(f["message_ids"].([]any), ids) || f["read_at"] == nil) {
+			t.Errorf("read = %v, want message_ids %v", f, ids)
+		}
+	}
+}
+
+// before / after のカーソルで、抜けも重複もなく前後のページをたどれる
+func TestMessagePaging(t *testing.T) {
+	ts, alice, _, roomID := startChat(t)
+	var ids []int
+	for i := range 5 {
+		ids = append(ids, sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": fmt.Sprintf("メッセージ%d", i)}))
+	}
+
+	page := func(query string) MessagePage {
+		t.Helper()
+		var p MessagePage
+		decodeBody(t, alice.do(t, ts, "GET", fmt.Sprintf("/messages?room_id=%d&limit=2%s", roomID, query), nil), http.StatusOK, &p)
+		return p
+	}
+	idsOf := func(p MessagePage) []int {
+		out := make([]int, len(p.Messages))
+		for i, m := range p.Messages {
+			out[i] = m.ID
+		}
+		return out
+	}
+
+	latest := page("")
+	if got := idsOf(latest); !slices.Equal(got, ids[3:]) || latest.PrevCursor == nil || latest.NextCursor != nil {
+		t.Fatalf("最新ページ = %v (prev=%v next=%v), want %v", got, latest.PrevCursor, latest.NextCursor, ids[3:])
+	}
+	middle := page("&before=" + *latest.PrevCursor)
+	if got := idsOf(middle); !slices.Equal(got, ids[1:3]) || middle.PrevCursor == nil || middle.NextCursor == nil {
+		t.Fatalf("2ページ目 = %v, want %v", got, ids[1:3])
+	}
+	oldest := page("&before=" + *middle.PrevCursor)
+	if got := idsOf(oldest); !slices.Equal(got, ids[:1]) || oldest.PrevCursor != nil {
+		t.Fatalf("最古のページ = %v (prev=%v), want %v", got, oldest.PrevCursor, ids[:1])
+	}
+	newer := page("&after=" + *oldest.NextCursor)
+	if got := idsOf(newer); !slices.Equal(got, ids[1:3]) || newer.NextCursor == nil {
+		t.Fatalf("after で戻ったページ = %v, want %v", got, ids[1:3])
+	}
+
+	for _, query := range []string{"&before=!!!", "&limit=0", "&before=" + *latest.PrevCursor + "&after=" + *latest.PrevCursor} {
+		decodeBody(t, alice.do(t, ts, "GET", fmt.Sprintf("/messages?room_id=%d%s", roomID, query), nil), http.StatusBadRequest, nil)
+	}
+}
+
+// 指定したメッセージの前後を返し、スレッドの返信なら親の前後を返す
+func TestMessageContext(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	var ids []int
+	for i := range 7 {
+		ids = append(ids, sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": fmt.Sprintf("メッセージ%d", i)}))
+	}
+	reply := sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "返信", "parent_message_id": ids[1]})
+
+	type contextPage struct {
+		MessagePage
+		AnchorID      int  `json:"anchor_id"`
+		ThreadReplyID *int `json:"thread_reply_id"`
+	}
+	tests := []struct {
+		name      string
+		messageID int
+		query     string
+		wantIDs   []int
+		wantPrev  bool
+		wantNext  bool
+		wantReply bool
+	}{
+		{"前後2件", ids[3], "&before_count=2&after_count=2", ids[1:6], true, true, false},
+		{"最初のメッセージ", ids[0], "&before_count=2&after_count=2", ids[0:3], false, true, false},
+		{"後ろが足りない", ids[5], "&before_count=1&after_count=5", ids[4:7], true, false, false},
+		{"スレッドの返信は親の前後", reply, "&before_count=1&after_count=1", ids[0:3], false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got contextPage
+			path := fmt.Sprintf("/messages/context?message_id=%d%s", tt.messageID, tt.query)
+			decodeBody(t, bob.do(t, ts, "GET", path, nil), http.StatusOK, &got)
+			var gotIDs []int
+			for _, m := range got.Messages {
+				gotIDs = append(gotIDs, m.ID)
+			}
+			if !slices.Equal(gotIDs, tt.wantIDs) {
+				t.Errorf("messages = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			if (got.PrevCursor != nil) != tt.wantPrev || (got.NextCursor != nil) != tt.wantNext {
+				t.Errorf("prev_cursor = %v, next_cursor = %v, want %v, %v", got.PrevCursor, got.NextCursor, tt.wantPrev, tt.wantNext)
+			}
+			if tt.wantReply && (got.ThreadReplyID == nil || *got.ThreadReplyID != reply || got.AnchorID != ids[1]) {
+				t.Errorf("anchor_id = %d, thread_reply_id = %v, want %d, %d", got.AnchorID, got.ThreadReplyID, ids[1], reply)
+			}
+		})
+	}
+
+	carol := signUp(t, ts, "carol")
+	decodeBody(t, carol.do(t, ts, "GET", fmt.Sprintf("/messages/context?message_id=%d", ids[0]), nil), http.StatusForbidden, nil)
+	decodeBody(t, bob.do(t, ts, "GET", "/messages/context?message_id=9999", nil), http.StatusNotFound, nil)
+	decodeBody(t, bob.do(t, ts, "GET", fmt.Sprintf("/messages/context?message_id=%d&before_count=-1", ids[0]), nil), http.StatusBadRequest, nil)
+}
+
+// 編集すると編集前の本文が履歴に残り、送信者以外は編集できない
+func TestEditRevisions(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	id := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "14時から"})
+
+	edit := func(u testUser, content string, want int) {
+		t.Helper()
+		decodeBody(t, u.do(t, ts, "PUT", fmt.Sprintf("/messages/edit?id=%d", id), map[string]any{"content": content}), want, nil)
+	}
+	edit(alice, "15時から", http.StatusOK)
+	edit(alice, "15時半から", http.StatusOK)
+	edit(bob, "16時から", http.StatusForbidden)
+	edit(alice, "  ", http.StatusBadRequest)
+
+	var got struct {
+		MessageID int                      `json:"message_id"`
+		Content   string                   `json:"content"`
+		EditedAt  *time.Time               `json:"edited_at"`
+		Revisions []models.MessageRevision `json:"revisions"`
+	}
+	revisions := fmt.Sprintf("/messages/revisions?message_id=%d", id)
+	decodeBody(t, bob.do(t, ts, "GET", revisions, nil), http.StatusOK, &got)
+	if got.Content != "15時半から" || got.EditedAt == nil {
+		t.Errorf("content = %q, edited_at = %v", got.Content, got.EditedAt)
+	}
+	var contents []string
+	for _, r := range got.Revisions {
+		contents = append(contents, r.Content)
+		if r.EditedBy != alice.ID || r.MessageID != id {
+			t.Errorf("revision = %+v", r)
+		}
+	}
+	if want := []string{"14時から", "15時から"}; !slices.Equal(contents, want) {
+		t.Errorf("revisions = %q, want %q", contents, want)
+	}
+	if msgs := getMessages(t, ts, bob, roomID); msgs[0].Content != "15時半から" {
+		t.Errorf("一覧の本文 = %q", msgs[0].Content)
+	}
+
+	// メンバー以外と、削除済みのメッセージには履歴を返さない
+	carol := signUp(t, ts, "carol")
+	decodeBody(t, carol.do(t, ts, "GET", revisions, nil), http.StatusForbidden, nil)
+	decodeBody(t, alice.do(t, ts, "DELETE", fmt.Sprintf("/messages/delete?id=%d", id), nil), http.StatusOK, nil)
+	decodeBody(t, bob.do(t, ts, "GET", revisions, nil), http.StatusNotFound, nil)
+	edit(alice, "復活", http.StatusNotFound)
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"backend/hub"
+	"backend/middleware"
+	"backend/models"
+	"backend/protocol"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// クライアントからこれだけフレームが届かなければ idle にする
+	presenceIdleAfter = 5 * time.Minute
+	// idle への切り替えと、user_presence の自分の行の更新を行う間隔
+	presenceSweepInterval = 30 * time.Second
+	// これより長く更新されていない user_presence の行（落ちたレプリカの分）は数えない
+	presenceStaleAfter = 2 * time.Minute
+)
+
+// newReplicaID は user_presence でこのサーバーを区別するIDを作る（起動ごとに変わる）
+func newReplicaID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// presenceTracker はこのレプリカに接続しているユーザーの状態を持ち、変わったら記録して知らせる
+type presenceTracker struct {
+	srv *Server
+
+	writeMu sync.Mutex // 記録を直列にする（接続と切断の記録が入れ替わらないように）
+
+	mu         sync.Mutex
+	states     map[int]string    // 記録済みの状態（offline のユーザーは持たない）
+	lastActive map[int]time.Time // 記録したときの最後の操作時刻
+}
+
+func newPresenceTracker(srv *Server) *presenceTracker {
+	return &presenceTracker{
+		srv:        srv,
+		states:     make(map[int]string),
+		lastActive: make(map[int]time.Time),
+	}
+}
+
+// localPresence は userID のこのレプリカでの状態と、最後にフレームが届いた時刻を返す
+func (s *Server) localPresence(userID int) (string, time.Time) {
+	conns := s.clients.Conns(userID)
+	if len(conns) == 0 {
+		return models.PresenceOffline, time.Time{}
+	}
+	state := models.PresenceIdle
+	var last time.Time
+	for _, c := range conns {
+		at, idle := c.Activity()
+		if at.After(last) {
+			last = at
+		}
+		if !idle && time.Since(at) < presenceIdleAfter {
+			state = models.PresenceOnline
+		}
+	}
+	return state, last
+}
+
+// update は userID の状態を接続から計算し直し、変わっていれば記録して同じルームのユーザーに知らせる
+func (t *presenceTracker) update(userID int) {
+	t.writeMu.Lock()
+	state, lastActive := t.srv.localPresence(userID)
+
+	t.mu.Lock()
+	prev, known := t.states[userID]
+	if state == prev || (!known && state == models.PresenceOffline) {
+		t.mu.Unlock()
+		t.writeMu.Unlock()
+		return
+	}
+	// online なら今、idle なら最後の操作、切断なら idle だったかどうかで最終ログインが決まる
+	lastSeen := time.Now()
+	switch {
+	case state == models.PresenceIdle:
+		lastSeen = lastActive
+	case state == models.PresenceOffline && prev == models.PresenceIdle:
+		lastSeen = t.lastActive[userID]
+	}
+	if state == models.PresenceOffline {
+		delete(t.states, userID)
+		delete(t.lastActive, userID)
+	} else {
+		t.states[userID] = state
+		t.lastActive[userID] = lastActive
+	}
+	t.mu.Unlock()
+
+	err := t.srv.store.SetPresence(userID, t.srv.replicaID, state, lastSeen)
+	t.writeMu.Unlock()
+	if err != nil {
+		log.Printf("❌ プレゼンス記録失敗: userID=%d state=%s err=%v", userID, state, err)
+		return
+	}
+	if !known {
+		prev = models.PresenceOffline
+	}
+	log.Printf("🟢 プレゼンス変更: userID=%d %s → %s", userID, prev, state)
+	t.srv.pushPresence(userID)
+}
+
+// touch はフレームを受け取ったユーザーが online でなければ状態を計算し直す（online のままなら何もしない）
+func (t *presenceTracker) touch(userID int) {
+	t.mu.Lock()
+	online := t.states[userID] == models.PresenceOnline
+	t.mu.Unlock()
+	if !online {
+		t.update(userID)
+	}
+}
+
+// sweep は操作のない接続を idle にし、自分の記録が古くならないよう更新する
+func (t *presenceTracker) sweep() {
+	t.mu.Lock()
+	userIDs := make([]int, 0, len(t.states))
+	for uid := range t.states {
+		userIDs = append(userIDs, uid)
+	}
+	t.mu.Unlock()
+
+	for _, uid := range userIDs {
+		t.update(uid)
+	}
+	if err := t.srv.store.RefreshPresence(t.srv.replicaID, time.Now().Add(-presenceStaleAfter)); err != nil {
+		log.Println("❌ プレゼンスの更新に失敗:", err)
+	}
+}
+
+// StartPresenceSweep は idle への切り替えとプレゼンスの記録の更新を定期的に行う
+func (s *Server) StartPresenceSweep() {
+	go func() {
+		ticker := time.NewTicker(presenceSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.presence.sweep()
+		}
+	}()
+}
+
+// pushPresence は userID の（全レプリカをまとめた）状態を、同じルームにいるユーザーに送る
+func (s *Server) pushPresence(userID int) {
+	presences, err := s.store.GetPresences([]int{userID}, presenceFreshSince())
+	if err != nil {
+		log.Printf("❌ プレゼンス取得失敗: userID=%d err=%v", userID, err)
+		return
+	}
+	pr, ok := presences[userID]
+	if !ok {
+		return
+	}
+	peers, err := s.store.ListRoomPeerIDs(userID)
+	if err != nil {
+		log.Printf("❌ 同じルームのユーザー取得失敗: userID=%d err=%v", userID, err)
+		return
+	}
+	ev := protocol.PresenceEvent{UserID: userID, State: pr.State}
+	if !pr.HideLastSeen {
+		ev.LastSeenAt = pr.LastSeenAt
+	}
+	if err := s.publishEphemeral(peers, ev); err != nil {
+		log.Printf("❌ プレゼンス配送失敗: userID=%d err=%v", userID, err)
+	}
+}
+
+func presenceFreshSince() time.Time {
+	return time.Now().Add(-presenceStaleAfter)
+}
+
+// attachPresence は users のうち viewerID と同じルームにいるユーザー（と本人）にプレゼンスを付ける。
+// それ以外のユーザーにはプレゼンスを付けない。最終ログインを隠しているユーザーは、本人以外には時刻を返さない。
+// 取得に失敗したらプレゼンスなしのまま返す。
+func (s *Server) attachPresence(viewerID int, users []models.User) {
+	if len(users) == 0 {
+		return
+	}
+	peers, err := s.store.ListRoomPeerIDs(viewerID)
+	if err != nil {
+		log.Printf("❌ 同じルームのユーザー取得失敗: userID=%d err=%v", viewerID, err)
+		return
+	}
+	visible := make(map[int]bool, len(peers)+1)
+	visible[viewerID] = true
+	for _, id := range peers {
+		visible[id] = true
+	}
+	var ids []int
+	for _, u := range users {
+		if visible[u.ID] {
+			ids = append(ids, u.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	presences, err := s.store.GetPresences(ids, presenceFreshSince())
+	if err != nil {
+		log.Println("❌ プレゼンス取得失敗:", err)
+		return
+	}
+	for i := range users {
+		pr, ok := presences[users[i].ID]
+		if !ok {
+			continue
+		}
+		if pr.HideLastSeen && users[i].ID != viewerID {
+			pr.LastSeenAt = nil
+		}
+		users[i].Presence = &pr
+	}
+}
+
+// handlePresenceRequest はクライアントが知らせた在席状態を反映する
+func (s *Server) handlePresenceRequest(c *hub.Conn, req *protocol.PresenceRequest) error {
+	if req.State == models.PresenceIdle {
+		c.SetIdle()
+	}
+	s.presence.update(c.UserID)
+	return nil
+}
+
+// UpdatePresenceSettings は最終ログインの時刻を他のユーザーに見せるかどうかを切り替える
+// PUT /me/presence {"hide_last_seen": true}
+func (s *Server) UpdatePresenceSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		HideLastSeen *bool `json:"hide_last_seen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.HideLastSeen == nil {
+		http.Error(w, `{"error": "hide_last_seen が必要です"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.SetHideLastSeen(userID, *req.HideLastSeen); err != nil {
+		log.Println("❌ 最終ログインの公開設定の保存失敗:", err)
+		http.Error(w, `{"error": "保存に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("🙈 最終ログインの公開設定: userID=%d hide=%v", userID, *req.HideLastSeen)
+
+	// 同じルームのユーザーの表示を揃える
+	s.pushPresence(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"hide_last_seen": *req.HideLastSeen})
+}
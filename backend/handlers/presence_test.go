@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"backend/models"
+	"net/http"
+	"testing"
+)
+
+// ユーザー一覧のプレゼンスは、同じルームにいるユーザーの分だけ返す
+func TestUsersPresence(t *testing.T) {
+	ts, alice, bob, _ := startChat(t)
+	carol := signUp(t, ts, "carol")
+	dialWS(t, ts, bob)
+	dialWS(t, ts, carol)
+
+	var users []models.User
+	decodeBody(t, alice.do(t, ts, "GET", "/users", nil), http.StatusOK, &users)
+
+	tests := []struct {
+		name  string
+		id    int
+		state string // 空ならプレゼンスを返さない
+	}{
+		{"同じルームの bob", bob.ID, models.PresenceOnline},
+		{"ルームを共有しない carol", carol.ID, ""},
+	}
+	for _, tt := range tests {
+		var got *models.Presence
+		found := false
+		for _, u := range users {
+			if u.ID == tt.id {
+				got, found = u.Presence, true
+			}
+		}
+		if !found {
+			t.Fatalf("%s: 一覧にいません: %+v", tt.name, users)
+		}
+		switch {
+		case tt.state == "" && got != nil:
+			t.Errorf("%s: プレゼンス = %+v, want なし", tt.name, got)
+		case tt.state != "" && (got == nil || got.State != tt.state):
+			t.Errorf("%s: プレゼンス = %+v, want %s", tt.name, got, tt.state)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/protocol"
+	"backend/store"
+	"errors"
+	"log"
+)
+
+// 引用プレビューに含める本文の最大文字数
+const quotePreviewLength = 80
+
+// errInvalidReplyTo は引用元が存在しない・別ルーム・削除済みであることを表す
+var errInvalidReplyTo = errors.New("引用元のメッセージが見つかりません")
+
+func quotePreviewOf(m models.Message) models.QuotePreview {
+	p := models.QuotePreview{ID: m.ID, SenderID: m.SenderID, EditedAt: m.EditedAt}
+	if m.IsDeleted() {
+		p.Deleted = true
+		return p
+	}
+	content := []rune(m.Content)
+	if len(content) > quotePreviewLength {
+		p.Content = string(content[:quotePreviewLength]) + "…"
+	} else {
+		p.Content = m.Content
+	}
+	return p
+}
+
+// resolveReplyTo は引用元が同じルームの削除されていないメッセージか検証する
+func (s *Server) resolveReplyTo(msg *models.Message) error {
+	if msg.ReplyToMessageID == nil {
+		return nil
+	}
+	quoted, err := s.store.GetMessage(*msg.ReplyToMessageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidReplyTo
+	}
+	if err != nil {
+		return err
+	}
+	if quoted.RoomID != msg.RoomID || quoted.IsDeleted() {
+		return errInvalidReplyTo
+	}
+	return nil
+}
+
+// quotePreviewFor は msg が引用しているメッセージのプレビューを返す（引用なしなら nil）
+func (s *Server) quotePreviewFor(msg models.Message) *models.QuotePreview {
+	if msg.ReplyToMessageID == nil {
+		return nil
+	}
+	quoted, err := s.store.GetMessage(*msg.ReplyToMessageID)
+	if err != nil {
+		// 引用元が完全削除された場合も削除済みとして表示する
+		return &models.QuotePreview{ID: *msg.ReplyToMessageID, Deleted: true}
+	}
+	p := quotePreviewOf(*quoted)
+	return &p
+}
+
+// quotePreviews は rows が引用しているメッセージのプレビューをまとめて読み込む（キーは引用元ID）
+func (s *Server) quotePreviews(rows []models.Message) (map[int]models.QuotePreview, error) {
+	var ids []int
+	for _, m := range rows {
+		if m.ReplyToMessageID != nil {
+			ids = append(ids, *m.ReplyToMessageID)
+		}
+	}
+	previews := make(map[int]models.QuotePreview, len(ids))
+	if len(ids) == 0 {
+		return previews, nil
+	}
+
+	quoted, err := s.store.ListMessagesByIDs(ids)
+	if err != nil {
+		return previews, err
+	}
+	for _, q := range quoted {
+		previews[q.ID] = quotePreviewOf(q)
+	}
+	for _, id := range ids {
+		if _, ok := previews[id]; !ok {
+			previews[id] = models.QuotePreview{ID: id, Deleted: true}
+		}
+	}
+	return previews, nil
+}
+
+// notifyQuoteUpdate は m の編集・削除・復元をルームに通知し、m を引用しているメッセージのプレビューを更新させる。
+// m を引用しているメッセージがなければ何もしない。
+func (s *Server) notifyQuoteUpdate(m models.Message) {
+	quoted, err := s.store.IsMessageQuoted(m.ID)
+	if err != nil {
+		log.Printf("❌ 引用の有無の確認失敗: messageID=%d err=%v", m.ID, err)
+		return
+	}
+	if quoted {
+		s.publishQuoteUpdate(m)
+	}
+}
+
+// publishQuoteUpdate は m の引用プレビューをルームに送る
+func (s *Server) publishQuoteUpdate(m models.Message) {
+	s.publishToRoom(m.RoomID, protocol.QuoteUpdateEvent{MessageID: m.ID, Preview: quotePreviewOf(m)})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestQuotedReply(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	quoted := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "14時からでどうですか"})
+	reply := sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "大丈夫です", "reply_to_message_id": quoted})
+
+	msgs := getMessages(t, ts, alice, roomID)
+	if msgs[1].ID != reply || msgs[1].ReplyTo == nil || msgs[1].ReplyTo.ID != quoted || msgs[1].ReplyTo.Content != "14時からでどうですか" {
+		t.Fatalf("引用プレビュー = %+v", msgs[1].ReplyTo)
+	}
+
+	// 存在しない・別のルームのメッセージは引用できない
+	carol := signUp(t, ts, "carol")
+	other := openRoom(t, ts, alice, carol)
+	elsewhere := sendMessage(t, ts, alice, map[string]any{"room_id": other, "content": "別件"})
+	for _, id := range []int{9999, elsewhere} {
+		res := bob.do(t, ts, "POST", "/messages", map[string]any{"room_id": roomID, "content": "引用", "reply_to_message_id": id})
+		decodeBody(t, res, http.StatusBadRequest, nil)
+	}
+}
+
+// 引用されているメッセージを編集したときだけ quote_update を送る
+func TestQuoteUpdateOnlyWhenQuoted(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	quoted := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "14時から"})
+	sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "了解", "reply_to_message_id": quoted})
+	plain := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "資料は後で"})
+	bobWS := dialWS(t, ts, bob)
+
+	edit := func(id int, content string) {
+		t.Helper()
+		res := alice.do(t, ts, "PUT", fmt.Sprintf("/messages/edit?id=%d", id), map[string]any{"content": content})
+		decodeBody(t, res, http.StatusOK, nil)
+	}
+
+	edit(quoted, "15時から")
+	got := readFrame(t, bobWS, "quote_update")
+	if got["message_id"] != float64(quoted) || got["preview"].(map[string]any)["content"] != "15時から" {
+		t.Errorf("quote_update = %v", got)
+	}
+
+	// 引用されていないメッセージの編集では送らない（次のメッセージまでに届いたフレームで確かめる）
+	edit(plain, "資料は明日")
+	sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "よろしく"})
+	if types := typesOf(readFramesUntil(t, bobWS, "message")); slices.Contains(types, "quote_update") {
+		t.Errorf("引用されていないのに quote_update が届きました: %v", types)
+	}
+}
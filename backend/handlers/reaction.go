@@ -1,18 +1,25 @@
 package handlers
 
 import (
-	"backend/db"
 	"backend/middleware"
+	"backend/protocol"
+	"backend/store"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 )
 
+// errMessageDeleted は削除済みメッセージへの操作を表す
+var errMessageDeleted = errors.New("削除されたメッセージです")
+
 type ReactionRequest struct {
 	MessageID int    `json:"message_id"`
 	Emoji     string `json:"emoji"`
 }
 
-func HandleReaction(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleReaction(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
@@ -25,46 +32,52 @@ func HandleReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var current *string
-	err = db.Conn.QueryRow(`
-    SELECT reaction FROM message_reads
-    WHERE message_id=$1 AND user_id=$2
-  `, req.MessageID, userID).Scan(&current)
-
-	if err != nil && err.Error() != "sql: no rows in result set" {
-		http.Error(w, `{"error": "DB read failed"}`, http.StatusInternalServerError)
+	if _, err := s.reactToMessage(userID, req.MessageID, req.Emoji); err != nil {
+		writeServiceError(w, err, "DB update failed")
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	if current != nil && *current == req.Emoji {
-		// 取り消し
-		_, err = db.Conn.Exec(`
-      UPDATE message_reads SET reaction = NULL WHERE message_id=$1 AND user_id=$2
-    `, req.MessageID, userID)
-	} else if current == nil {
-		// 追加
-		_, err = db.Conn.Exec(`
-      INSERT INTO message_reads (message_id, user_id, reaction, read_at)
-      VALUES ($1, $2, $3, NOW())
-    `, req.MessageID, userID, req.Emoji)
-	} else {
-		// 変更
-		_, err = db.Conn.Exec(`
-      UPDATE message_reads SET reaction = $3 WHERE message_id=$1 AND user_id=$2
-    `, req.MessageID, userID, req.Emoji)
+// reactToMessage はリアクションを付け外しして、本人（全端末）とメッセージの送信者に知らせる。
+// 同じ絵文字なら取り消し、それ以外なら追加・変更する。ルームのメンバーだけが付けられる。
+// 付け外ししたあとの自分のリアクションを返す（取り消したなら nil）。
+func (s *Server) reactToMessage(userID, messageID int, emoji string) (*string, error) {
+	m, err := s.store.GetMessage(messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("メッセージ取得失敗: %w", err)
+	}
+	if m.IsDeleted() {
+		return nil, errMessageDeleted
+	}
+	if err := s.requireRoomMember(m.RoomID, userID); err != nil {
+		return nil, err
 	}
 
+	current, err := s.store.GetReaction(messageID, userID)
 	if err != nil {
-		http.Error(w, `{"error": "DB update failed"}`, http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("リアクション取得失敗: %w", err)
+	}
+	reaction := &emoji
+	if current != nil && *current == emoji {
+		reaction = nil // 取り消し
+	}
+	if err := s.store.SetReaction(messageID, userID, reaction); err != nil {
+		return nil, fmt.Errorf("リアクション保存失敗: messageID=%d: %w", messageID, err)
 	}
+	log.Printf("😀 リアクション: userID=%d messageID=%d emoji=%s", userID, messageID, emoji)
 
-	NotifyUser(userID, map[string]interface{}{
-		"type":       "reaction",
-		"message_id": req.MessageID,
-		"emoji":      req.Emoji,
-		"user_id":    userID,
-	})
+	payload := protocol.ReactionEvent{MessageID: messageID, Emoji: emoji, UserID: userID}
 
-	w.WriteHeader(http.StatusOK)
+	// 🔁 自分にも通知（これが必要）
+	s.NotifyUser(userID, payload)
+
+	// 🔁 相手にも通知（同一人物でなければ）
+	if m.SenderID != userID {
+		s.NotifyUser(m.SenderID, payload)
+	}
+	return reaction, nil
 }
@@ -1,32 +1,28 @@
 package handlers
 
 import (
-	"backend/db"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 // 既読人数を取得するハンドラー
-func GetReadCount(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetReadCount(w http.ResponseWriter, r *http.Request) {
 	// room_idをクエリパラメータから取得
-	roomID := r.URL.Query().Get("room_id")
-	if roomID == "" {
+	roomIDStr := r.URL.Query().Get("room_id")
+	if roomIDStr == "" {
 		http.Error(w, "room_id is required", http.StatusBadRequest)
 		return
 	}
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		http.Error(w, "invalid room_id", http.StatusBadRequest)
+		return
+	}
 
-	// 既読人数を取得するSQLクエリ（message_readsテーブルとmessageテーブルを結合）
-	query := `
-		SELECT COUNT(DISTINCT mr.user_id) 
-		FROM message_reads mr
-		JOIN messages m ON mr.message_id = m.id
-		WHERE m.room_id = $1 AND mr.read_at IS NOT NULL
-	`
-
-	var readCount int
-	// データベースから既読ユーザー数を取得
-	err := db.Conn.QueryRow(query, roomID).Scan(&readCount)
+	// 既読ユーザー数を取得
+	readCount, err := s.store.CountRoomReaders(roomID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching read count: %v", err), http.StatusInternalServerError)
 		return
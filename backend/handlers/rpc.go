@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"backend/hub"
+	"backend/models"
+	"backend/protocol"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// rpcMethods は JSON-RPC のサブプロトコルで呼び出せるメソッド。
+// どれも HTTP のハンドラーと同じ処理（postMessage・editMessage など）を呼び、エラーも同じように分類して返す。
+var rpcMethods = map[string]func(s *Server, c *hub.Conn, params json.RawMessage) (any, error){
+	"sendMessage":   (*Server).rpcSendMessage,
+	"editMessage":   (*Server).rpcEditMessage,
+	"deleteMessage": (*Server).rpcDeleteMessage,
+	"react":         (*Server).rpcReact,
+	"markRead":      (*Server).rpcMarkRead,
+	"getMessages":   (*Server).rpcGetMessages,
+	"createRoom":    (*Server).rpcCreateRoom,
+	"startTyping":   (*Server).rpcStartTyping,
+	"stopTyping":    (*Server).rpcStopTyping,
+	"setPresence":   (*Server).rpcSetPresence,
+}
+
+// handleRPCFrame は JSON-RPC のフレーム（単体またはバッチ）を処理し、レスポンスを送信元の接続に返す
+func (s *Server) handleRPCFrame(c *hub.Conn, frame []byte) {
+	resp := protocol.ServeJSONRPC(frame, func(method string, params json.RawMessage) (any, error) {
+		call, ok := rpcMethods[method]
+		if !ok {
+			return nil, &protocol.RPCError{Code: protocol.RPCMethodNotFound, Message: "未対応のメソッドです: " + method}
+		}
+		result, err := call(s, c, params)
+		if err != nil {
+			return nil, rpcError(c.UserID, method, err)
+		}
+		return result, nil
+	})
+	if resp != nil {
+		c.SendRaw(resp)
+	}
+}
+
+// rpcError は処理のエラーを JSON-RPC のエラーにする（HTTP のステータスと同じ分類）。
+// サーバー側の失敗はログに残し、そのまま返す（ServeJSONRPC が中身を伏せる）。
+func rpcError(userID int, method string, err error) error {
+	var rpcErr *protocol.RPCError
+	var perr *protocol.Error
+	switch {
+	case errors.As(err, &rpcErr):
+	case errors.As(err, &perr) && perr.Code == protocol.CodeRejected:
+		rpcErr = &protocol.RPCError{Code: protocol.RPCRejected, Message: perr.Message}
+	default:
+		code := map[int]int{
+			http.StatusBadRequest: protocol.RPCRejected,
+			http.StatusForbidden:  protocol.RPCForbidden,
+			http.StatusNotFound:   protocol.RPCNotFound,
+		}[serviceErrorStatus(err)]
+		if code == 0 {
+			log.Printf("❌ JSON-RPC処理失敗: userID=%d method=%s err=%v", userID, method, err)
+			return err
+		}
+		rpcErr = &protocol.RPCError{Code: code, Message: err.Error()}
+	}
+	log.Printf("⚠️ JSON-RPCを拒否: userID=%d method=%s %v", userID, method, rpcErr)
+	return rpcErr
+}
+
+// rpcOK は返す値のないメソッドの結果
+var rpcOK = struct{}{}
+
+func (s *Server) rpcSendMessage(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.SendMessageParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	msg, dup, err := s.postMessage(models.Message{
+		RoomID:           p.RoomID,
+		SenderID:         c.UserID,
+		Content:          p.Content,
+		ParentMessageID:  p.ParentMessageID,
+		ReplyToMessageID: p.ReplyToMessageID,
+		ClientRequestID:  p.RequestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		models.Message
+		Duplicate bool `json:"duplicate,omitempty"` // 同じ request_id で保存済みだった
+	}{msg, dup}, nil
+}
+
+func (s *Server) rpcEditMessage(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.EditMessageParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return s.editMessage(c.UserID, p.MessageID, p.Content)
+}
+
+func (s *Server) rpcDeleteMessage(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.DeleteMessageParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.deleteMessage(c.UserID, p.MessageID); err != nil {
+		return nil, err
+	}
+	return map[string]int{"message_id": p.MessageID}, nil
+}
+
+func (s *Server) rpcReact(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.ReactionRequest
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	reaction, err := s.reactToMessage(c.UserID, p.MessageID, p.Emoji)
+	if err != nil {
+		return nil, err
+	}
+	// emoji は付け外ししたあとの自分のリアクション（取り消したなら null）
+	return struct {
+		MessageID int     `json:"message_id"`
+		Emoji     *string `json:"emoji"`
+	}{p.MessageID, reaction}, nil
+}
+
+func (s *Server) rpcMarkRead(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.MarkReadParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.RoomID > 0 {
+		err = s.markRoomRead(c.UserID, p.RoomID)
+	} else {
+		err = s.markMessageRead(c.UserID, p.MessageID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rpcOK, nil
+}
+
+func (s *Server) rpcGetMessages(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.GetMessagesParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	limit := p.Limit
+	if limit == 0 {
+		limit = models.DefaultPageLimit
+	}
+	page, err := pageQueryOf(p.Before, p.After, limit)
+	if err != nil {
+		return nil, &protocol.RPCError{Code: protocol.RPCInvalidParams, Message: err.Error()}
+	}
+	return s.listMessages(c.UserID, p.RoomID, page)
+}
+
+func (s *Server) rpcCreateRoom(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.CreateRoomParams
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	roomID, err := s.createGroupRoom(c.UserID, p.Name, p.UserIDs)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"room_id": roomID}, nil
+}
+
+func (s *Server) rpcStartTyping(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.TypingStartRequest
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.handleTypingRequest(c.UserID, p.RoomID, true); err != nil {
+		return nil, err
+	}
+	return rpcOK, nil
+}
+
+func (s *Server) rpcStopTyping(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.TypingStopRequest
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.handleTypingRequest(c.UserID, p.RoomID, false); err != nil {
+		return nil, err
+	}
+	return rpcOK, nil
+}
+
+func (s *Server) rpcSetPresence(c *hub.Conn, params json.RawMessage) (any, error) {
+	var p protocol.PresenceRequest
+	if err := protocol.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if err := s.handlePresenceRequest(c, &p); err != nil {
+		return nil, err
+	}
+	return rpcOK, nil
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"backend/protocol"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readRPC は id のレスポンス（id が nil なら method の通知）が届くまで読む
+func readRPC(t *testing.T, conn *websocket.Conn, id any, method string) map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("id=%v method=%s が届きません: %v", id, method, err)
+		}
+		var frame map[string]any
+		if err := json.Unmarshal(b, &frame); err != nil {
+			t.Fatalf("フレームを読めません: %s", b)
+		}
+		if (id != nil && frame["id"] == id) || (id == nil && frame["method"] == method) {
+			return frame
+		}
+	}
+}
+
+// JSON-RPC のサブプロトコルでメソッドを呼び出し、イベントは通知として受け取る
+func TestJSONRPCOverWebSocket(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	carol := signUp(t, ts, "carol")
+	aliceRPC := dialWSWith(t, ts, alice, "", protocol.JSONRPCSubprotocol)
+	bobRPC := dialWSWith(t, ts, bob, "", protocol.JSONRPCSubprotocol)
+	carolRPC := dialWSWith(t, ts, carol, "", protocol.JSONRPCSubprotocol)
+	for _, conn := range []*websocket.Conn{aliceRPC, bobRPC, carolRPC} {
+		readRPC(t, conn, nil, "ready")
+	}
+
+	call := func(conn *websocket.Conn, id float64, method string, params any) map[string]any {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+			t.Fatal(err)
+		}
+		return readRPC(t, conn, id, "")
+	}
+
+	res := call(aliceRPC, 1, "sendMessage", map[string]any{"room_id": roomID, "content": "RPC から"})
+	result, _ := res["result"].(map[string]any)
+	if result == nil || result["content"] != "RPC から" || result["sender_id"] != float64(alice.ID) {
+		t.Fatalf("sendMessage = %v", res)
+	}
+	note := readRPC(t, bobRPC, nil, "message")
+	if params := note["params"].(map[string]any); params["id"] != result["id"] || params["seq"] == nil {
+		t.Errorf("bob に届いた通知 = %v", note)
+	}
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		method   string
+		params   any
+		wantCode float64
+	}{
+		{"メンバーでないルーム", carolRPC, "sendMessage", map[string]any{"room_id": roomID, "content": "割り込み"}, protocol.RPCForbidden},
+		{"room_id がない", aliceRPC, "sendMessage", map[string]any{"content": "どこへ"}, protocol.RPCInvalidParams},
+		{"空のメッセージ", aliceRPC, "sendMessage", map[string]any{"room_id": roomID, "content": ""}, protocol.RPCRejected},
+		{"他人のメッセージの編集", bobRPC, "editMessage", map[string]any{"message_id": result["id"], "content": "書き換え"}, protocol.RPCForbidden},
+		{"存在しないメッセージの既読", bobRPC, "markRead", map[string]any{"message_id": 9999}, protocol.RPCNotFound},
+		{"未対応のメソッド", aliceRPC, "call", nil, protocol.RPCMethodNotFound},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := call(tt.conn, float64(100+i), tt.method, tt.params)
+			rpcErr, _ := res["error"].(map[string]any)
+			if rpcErr == nil || rpcErr["code"] != tt.wantCode {
+				t.Errorf("%s = %v, want code %v", tt.method, res, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+	"backend/search"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// スニペットでヒット箇所の前後に残す文字数
+const snippetRadius = 40
+
+// SearchResult は検索結果1件。snippet はHTMLエスケープ済みでヒット箇所を <mark> で囲む。
+type SearchResult struct {
+	ID        int       `json:"id"`
+	RoomID    int       `json:"room_id"`
+	SenderID  int       `json:"sender_id"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	Snippet   string    `json:"snippet"`
+}
+
+// SearchMessages は参加中のルームのメッセージを検索する（新しい順）
+// GET /messages/search?q=...[&before=cursor][&limit=50]
+// q には from:ユーザー名 in:ルーム名(またはID) has:attachment after:2024-01-01 before:2024-02-01 と "フレーズ" が使える。
+// 日付はユーザーのタイムゾーン（tz=Asia/Tokyo など。なければ設定の time_zone）の0時で区切る。
+func (s *Server) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := searchLocation(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := search.Parse(r.URL.Query().Get("q"), loc)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.IsEmpty() {
+		http.Error(w, `{"error": "検索条件を指定してください"}`, http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePageQuery(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if page.After != nil {
+		http.Error(w, `{"error": "検索では after は使えません"}`, http.StatusBadRequest)
+		return
+	}
+
+	rows, hasMore, err := s.store.SearchMessages(userID, q, page)
+	if err != nil {
+		log.Println("❌ メッセージ検索失敗:", err)
+		http.Error(w, `{"error": "検索に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Results    []SearchResult `json:"results"`
+		NextCursor *string        `json:"next_cursor"`
+	}{Results: make([]SearchResult, len(rows))}
+	for i, m := range rows {
+		resp.Results[i] = SearchResult{
+			ID:        m.ID,
+			RoomID:    m.RoomID,
+			SenderID:  m.SenderID,
+			Content:   m.Content,
+			Timestamp: m.Timestamp,
+			Snippet:   search.Snippet(m.Content, q.Terms, snippetRadius),
+		}
+	}
+	// 結果は新しい順なので、続き（より古い結果）は最後の1件を before= に渡して取得する
+	if hasMore {
+		next := models.CursorOf(rows[len(rows)-1]).Encode()
+		resp.NextCursor = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// searchLocation は日付の絞り込みに使うタイムゾーン（クエリの tz、なければ設定の time_zone）
+func searchLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return config.Get().Location()
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("tz が不正です: %s", tz)
+	}
+	return loc, nil
+}
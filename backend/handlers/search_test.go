@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSearchMessages(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "明日は東京で会議です"})
+	sendMessage(t, ts, bob, map[string]any{"room_id": roomID, "content": "大阪の件は来週"})
+
+	search := func(u testUser, params url.Values, want int) []SearchResult {
+		t.Helper()
+		var resp struct {
+			Results []SearchResult `json:"results"`
+		}
+		res := u.do(t, ts, "GET", "/messages/search?"+params.Encode(), nil)
+		if want != http.StatusOK {
+			decodeBody(t, res, want, nil)
+			return nil
+		}
+		decodeBody(t, res, want, &resp)
+		return resp.Results
+	}
+
+	results := search(bob, url.Values{"q": {"東京"}}, http.StatusOK)
+	if len(results) != 1 || results[0].Snippet != "明日は<mark>東京</mark>で会議です" {
+		t.Fatalf("東京 の検索結果 = %+v", results)
+	}
+	if results := search(bob, url.Values{"q": {"from:bob"}}, http.StatusOK); len(results) != 1 || results[0].SenderID != bob.ID {
+		t.Errorf("from:bob の検索結果 = %+v", results)
+	}
+
+	// 参加していないルームは検索できない
+	carol := signUp(t, ts, "carol")
+	if results := search(carol, url.Values{"q": {"東京"}}, http.StatusOK); len(results) != 0 {
+		t.Errorf("参加していないルームが検索できました: %+v", results)
+	}
+
+	search(bob, url.Values{"q": {"東京 after:2025-01-01"}, "tz": {"America/New_York"}}, http.StatusOK)
+	search(bob, url.Values{"q": {"東京 after:2025-01-01"}, "tz": {"Mars/Olympus"}}, http.StatusBadRequest)
+	search(bob, url.Values{"q": {""}}, http.StatusBadRequest)
+}
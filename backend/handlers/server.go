@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/hub"
+	"backend/store"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Server はハンドラーが使う状態（ストア・バス・接続・入力中・プレゼンス）をまとめたもの。
+// 1台のサーバー（レプリカ）に1つ作る。同じバスとストアにつないだ Server を1プロセスに複数作れば、
+// レプリカ間の配送をそのまま確かめられる。
+type Server struct {
+	store store.Store
+	bus   bus.Bus
+
+	clients        *hub.Registry   // WebSocket・SSE接続管理（ユーザーごとに複数の端末・タブ）
+	recentRequests *hub.RequestLog // 最近受け付けた request_id（再接続後の再送を重複として無視するため）
+	typing         *typingTracker
+	presence       *presenceTracker
+	replicaID      string // user_presence でこのサーバーを区別するID
+}
+
+// NewServer は st と b を使う Server を作り、b から届いたイベントをこのサーバーの接続に届けるようにする
+func NewServer(st store.Store, b bus.Bus) *Server {
+	s := &Server{
+		store:          st,
+		bus:            b,
+		clients:        hub.NewRegistry(),
+		recentRequests: hub.NewRequestLog(requestIDTTL),
+		replicaID:      newReplicaID(),
+	}
+	s.typing = newTypingTracker(s.clients)
+	s.presence = newPresenceTracker(s)
+	b.Subscribe(s.deliverEvent, s.clients.ResyncAll)
+	return s
+}
+
+// RegisterRoutes は r にエンドポイントを登録する
+func (s *Server) RegisterRoutes(r *mux.Router) {
+	// 🔐 認証
+	r.HandleFunc("/signup", s.SignUp).Methods("POST")
+	r.HandleFunc("/login", s.Login).Methods("POST")
+	r.HandleFunc("/logout", Logout).Methods("POST")
+	r.HandleFunc("/me", GetMe).Methods("GET")
+	r.HandleFunc("/me/presence", s.UpdatePresenceSettings).Methods("PUT") // 最終ログインを隠す
+
+	// 👤 ユーザー一覧
+	r.HandleFunc("/users", s.GetUsers).Methods("GET")
+	r.HandleFunc("/room/members", s.GetRoomMembers).Methods("GET")
+
+	// 💬 メッセージ・ルーム関連
+	r.HandleFunc("/messages", s.SendMessage).Methods("POST")
+	r.HandleFunc("/messages", s.GetMessages).Methods("GET")
+
+	// 指定メッセージの前後（メンション通知・検索結果からのジャンプ用）
+	r.HandleFunc("/messages/context", s.GetMessageContext).Methods("GET")
+
+	// 🔍 メッセージ検索（参加中のルームのみ）
+	r.HandleFunc("/messages/search", s.SearchMessages).Methods("GET")
+
+	// ✏️ 編集履歴（ルームメンバーのみ）
+	r.HandleFunc("/messages/revisions", s.GetMessageRevisions).Methods("GET")
+
+	// 🧵 スレッド
+	r.HandleFunc("/messages/thread", s.GetThread).Methods("GET")
+	r.HandleFunc("/messages/thread/follow", s.FollowThread).Methods("POST")
+
+	r.HandleFunc("/room", s.GetOrCreateRoom).Methods("GET")             // 1対1チャット
+	r.HandleFunc("/rooms", s.CreateGroupRoom).Methods("POST")           // グループチャット
+	r.HandleFunc("/create-chat-room", s.CreateChatRoom).Methods("POST") // 旧名APIなら整理も検討
+	r.HandleFunc("/my-rooms", s.GetMyRooms).Methods("GET")
+	r.HandleFunc("/group_rooms", s.GetGroupRooms).Methods("GET")
+	r.HandleFunc("/messages/read", s.MarkAllAsRead).Methods("POST")
+	r.HandleFunc("/upload", UploadImage).Methods("POST")
+	r.HandleFunc("/reactions", s.AddReaction).Methods("POST")
+	r.HandleFunc("/messages/edit", s.EditMessage).Methods("PUT")
+	r.HandleFunc("/room/unread_count", s.GetUnreadCount)
+	r.HandleFunc("/unread_counts", s.GetUnreadCount).Methods("GET")
+	r.HandleFunc("/messages/delete", s.DeleteMessage).Methods("DELETE")
+
+	// 🛡️ 管理者: 保持期間内の削除済みメッセージを復元
+	r.HandleFunc("/admin/messages/restore", s.RestoreMessage).Methods("POST")
+
+	// 🛡️ 管理者: メッセージの完全削除（復元できない）
+	r.HandleFunc("/admin/messages/hard_delete", s.HardDeleteMessage).Methods("DELETE")
+
+	// 📊 管理者: WebSocket接続数と回収した接続数
+	r.HandleFunc("/admin/ws/stats", s.GetWebSocketStats).Methods("GET")
+
+	// 静的ファイル配信（画像URLアクセス用）
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./uploads"))))
+
+	// 🌐 WebSocket
+	r.HandleFunc("/ws", s.HandleWebSocket)
+
+	// 📡 Server-Sent Events（WebSocket が使えない環境向け。送信は REST で行う）
+	r.HandleFunc("/events", s.HandleSSE).Methods("GET")
+
+	// 既読処理エンドポイント追加
+	r.HandleFunc("/api/mark_as_read", s.MarkMessageAsRead).Methods("POST")
+}
+
+// Start は定期的に行う処理（削除済みメッセージ・イベントログの消去、入力中の期限切れ、プレゼンスの更新）を始める
+func (s *Server) Start() {
+	// 保持期間を過ぎた削除済みメッセージの本文を定期的に消去
+	s.StartDeletedMessagePurge()
+
+	// 保持期間を過ぎたWebSocketイベント（再接続時の再送用）を定期的に削除
+	s.StartEventLogPurge()
+
+	// 送り直されなくなった「入力中」を数秒で消す
+	s.StartTypingExpiry()
+
+	// 操作のない接続を idle にし、プレゼンスの記録を更新し続ける
+	s.StartPresenceSweep()
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/config"
+	"backend/store"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// testUser はサインアップしてログインしたユーザー（Cookie 付きでリクエストを送る）
+type testUser struct {
+	ID     int
+	cookie *http.Cookie
+}
+
+// setConfig は dev のデフォルト値を change で書き換えた設定を、テストの間だけ使う
+func setConfig(t *testing.T, change func(c *config.Config)) {
+	t.Helper()
+	c := config.Defaults(config.EnvDev)
+	change(c)
+	config.Set(c)
+	t.Cleanup(func() { config.Set(nil) })
+}
+
+// startTestServer は st と b を使う Server を httptest で起動する
+func startTestServer(t *testing.T, st store.Store, b bus.Bus) *httptest.Server {
+	t.Helper()
+	_, ts := newTestServer(t, st, b)
+	return ts
+}
+
+// newTestServer は startTestServer と同じく起動し、中身を直接触るテスト向けに Server も返す
+func newTestServer(t *testing.T, st store.Store, b bus.Bus) (*Server, *httptest.Server) {
+	t.Helper()
+	srv := NewServer(st, b)
+	r := mux.NewRouter()
+	srv.RegisterRoutes(r)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// signUp は name でサインアップしてログインする
+func signUp(t *testing.T, ts *httptest.Server, name string) testUser {
+	t.Helper()
+	body := fmt.Sprintf(`{"username": %q, "password_hash": "pw"}`, name)
+
+	res, err := http.Post(ts.URL+"/signup", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		ID int `json:"id"`
+	}
+	decodeBody(t, res, http.StatusCreated, &created)
+
+	res, err = http.Post(ts.URL+"/login", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, res, http.StatusOK, nil)
+	for _, c := range res.Cookies() {
+		if c.Name == "token" {
+			return testUser{ID: created.ID, cookie: c}
+		}
+	}
+	t.Fatalf("ログインの応答に token の Cookie がありません: %s", name)
+	return testUser{}
+}
+
+// do は u として method path にリクエストを送る（body が nil でなければ JSON にして送る）
+func (u testUser) do(t *testing.T, ts *httptest.Server, method, path string, body any) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(u.cookie)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// decodeBody はステータスが want であることを確かめ、v が nil でなければ本文を読み込む
+func decodeBody(t *testing.T, res *http.Response, want int, v any) {
+	t.Helper()
+	defer res.Body.Close()
+	if res.StatusCode != want {
+		b, _ := io.ReadAll(res.Body)
+		t.Fatalf("%s %s: ステータス %d（期待値 %d）: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, want, b)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: 応答を読めません: %v", res.Request.Method, res.Request.URL.Path, err)
+	}
+}
+
+// openRoom は u と other の1対1ルームを作り、ルームIDを返す
+func openRoom(t *testing.T, ts *httptest.Server, u, other testUser) int {
+	t.Helper()
+	var room struct {
+		RoomID int `json:"room_id"`
+	}
+	decodeBody(t, u.do(t, ts, "GET", fmt.Sprintf("/room?user_id=%d", other.ID), nil), http.StatusOK, &room)
+	return room.RoomID
+}
+
+// dialWS は u として WebSocket で接続し、再送の終わり（ready）まで読む
+func dialWS(t *testing.T, ts *httptest.Server, u testUser) *websocket.Conn {
+	t.Helper()
+	conn := dialWSWith(t, ts, u, "", "")
+	readFrame(t, conn, "ready")
+	return conn
+}
+
+// dialWSWith は u として /ws?query に subprotocol（空なら指定しない）で接続する。最初のフレームは読まない。
+func dialWSWith(t *testing.T, ts *httptest.Server, u testUser, query, subprotocol string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	if query != "" {
+		url += "?" + query
+	}
+	dialer := *websocket.DefaultDialer
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	conn, _, err := dialer.Dial(url, http.Header{"Cookie": {u.cookie.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readFrame は type が eventType のフレームが届くまで読み、その中身を返す
+func readFrame(t *testing.T, conn *websocket.Conn, eventType string) map[string]any {
+	t.Helper()
+	frames := readFramesUntil(t, conn, eventType)
+	return frames[len(frames)-1]
+}
+
+// readFramesUntil は type が eventType のフレームが届くまで読み、それまでに届いたフレームを順に返す
+func readFramesUntil(t *testing.T, conn *websocket.Conn, eventType string) []map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var frames []map[string]any
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s が届きません: %v", eventType, err)
+		}
+		var frame map[string]any
+		if err := json.Unmarshal(b, &frame); err != nil {
+			t.Fatalf("フレームを読めません: %s", b)
+		}
+		frames = append(frames, frame)
+		if frame["type"] == eventType {
+			return frames
+		}
+	}
+}
+
+// typesOf はフレームの type を順に並べる
+func typesOf(frames []map[string]any) []any {
+	types := make([]any, len(frames))
+	for i, f := range frames {
+		types[i] = f["type"]
+	}
+	return types
+}
+
+// 同じバスとストアにつないだ2台のうち、A で送ったメッセージが B の接続に届く
+func TestServersShareBus(t *testing.T) {
+	st := store.NewMemory()
+	b := bus.NewLocal()
+	defer b.Close()
+	a := startTestServer(t, st, b)
+	bsrv := startTestServer(t, st, b)
+
+	alice := signUp(t, a, "alice")
+	bob := signUp(t, a, "bob")
+	roomID := openRoom(t, a, alice, bob)
+
+	conn := dialWS(t, bsrv, bob)
+
+	res := alice.do(t, a, "POST", "/messages", map[string]any{"room_id": roomID, "content": "こんにちは"})
+	decodeBody(t, res, http.StatusOK, nil)
+
+	frame := readFrame(t, conn, "message")
+	if frame["content"] != "こんにちは" || frame["room_id"] != float64(roomID) {
+		t.Errorf("B に届いたメッセージが違います: %v", frame)
+	}
+}
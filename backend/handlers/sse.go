@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// EventSource が切断後に再接続するまでの待ち時間
+const sseRetry = 3 * time.Second
+
+// HandleSSE は /ws と同じサーバー→クライアントのイベントを Server-Sent Events で送る。
+// プロキシが WebSocket のアップグレードを通さない環境向けで、送信は POST /messages などの REST で行う。
+// seq 付きのイベントは id に seq が入るので、再接続時の Last-Event-ID（初回は ?last_seq=）から続きを再送する。
+// GET /events
+func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx などにバッファさせない
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		log.Println("❌ SSE: レスポンスをフラッシュできません:", err)
+		return
+	}
+
+	// ここから先のレスポンスへの書き込みは、接続の writer ゴルーチンだけが行う
+	c := s.clients.AddSSE(userID, w, wsOptions())
+	log.Printf("✅ SSE接続: userID=%d connID=%d", userID, c.ID)
+	s.presence.update(userID)
+	s.subscribeUserRooms(userID)
+
+	lastSeq := r.Header.Get("Last-Event-ID")
+	if lastSeq == "" {
+		lastSeq = r.URL.Query().Get("last_seq")
+	}
+	s.resumeEvents(c, lastSeq)
+
+	// クライアントが切断するか、書き込みに失敗するまで待つ
+	select {
+	case <-r.Context().Done():
+	case <-c.Stopped():
+	}
+	c.Close()
+	<-c.Stopped()
+	s.unregisterConn(c)
+	log.Printf("👋 SSE切断: userID=%d connID=%d", userID, c.ID)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent は text/event-stream の1イベント
+type sseEvent struct {
+	id    string
+	frame map[string]any
+}
+
+// openSSE は u として /events に接続する（lastEventID が空でなければ Last-Event-ID を付ける）
+func openSSE(t *testing.T, ts *httptest.Server, u testUser, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(u.cookie)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events: ステータス %d, Content-Type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(res.Body)
+}
+
+// readSSEUntil は type が eventType のイベントが届くまで読み、それまでのイベントを順に返す
+func readSSEUntil(t *testing.T, r *bufio.Reader, eventType string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s が届きません: %v", eventType, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.frame); err != nil {
+				t.Fatalf("data を読めません: %s", line)
+			}
+		case line == "" && ev.frame != nil:
+			events = append(events, ev)
+			if ev.frame["type"] == eventType {
+				return events
+			}
+			ev = sseEvent{}
+		}
+	}
+}
+
+// SSE でも WebSocket と同じイベントが届き、Last-Event-ID で取りこぼした分を再送する
+func TestSSE(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	stream := openSSE(t, ts, bob, "")
+	readSSEUntil(t, stream, "ready")
+
+	first := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "SSE へ"})
+	events := readSSEUntil(t, stream, "message")
+	got := events[len(events)-1]
+	if got.frame["id"] != float64(first) || got.id == "" || got.id != jsonNumber(got.frame["seq"]) {
+		t.Fatalf("message = id %q, %v", got.id, got.frame)
+	}
+
+	// 切断中に送られたメッセージは、最後に受け取った id から再送される
+	second := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "切断中に"})
+	resumed := openSSE(t, ts, bob, got.id)
+	var replayed []any
+	for _, ev := range readSSEUntil(t, resumed, "ready") {
+		if ev.frame["type"] == "message" {
+			replayed = append(replayed, ev.frame["id"])
+		}
+	}
+	if len(replayed) != 1 || replayed[0] != float64(second) {
+		t.Errorf("再送されたメッセージ = %v, want [%d]", replayed, second)
+	}
+
+	res, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, res, http.StatusUnauthorized, nil)
+}
+
+// jsonNumber は JSON の数値を SSE の id と同じ10進の文字列にする
+func jsonNumber(v any) string {
+	f, _ := v.(float64)
+	b, _ := json.Marshal(int64(f))
+	return string(b)
+}
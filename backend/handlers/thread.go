@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/protocol"
+	"backend/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// errInvalidParent は返信先が存在しない・別ルーム・削除済みであることを表す
+var errInvalidParent = errors.New("返信先のメッセージが見つかりません")
+
+// resolveThreadParent は返信先を検証し、msg.ParentMessageID をスレッドの親に揃える。
+// スレッドは1階層なので、返信への返信は親への返信として扱う。
+func (s *Server) resolveThreadParent(msg *models.Message) error {
+	if msg.ParentMessageID == nil {
+		return nil
+	}
+	parent, err := s.store.GetMessage(*msg.ParentMessageID)
+	if err == nil && parent.ParentMessageID != nil {
+		parent, err = s.store.GetMessage(*parent.ParentMessageID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidParent
+	}
+	if err != nil {
+		return err
+	}
+	if parent.RoomID != msg.RoomID || parent.IsDeleted() {
+		return errInvalidParent
+	}
+	rootID := parent.ID
+	msg.ParentMessageID = &rootID
+	return nil
+}
+
+// notifyThreadReply はスレッドに返信が保存されたあとの処理。
+// 親の投稿者と返信者をフォローさせ、フォロワーに "thread_reply"、ルーム全員に返信数の "thread_update" を送る。
+func (s *Server) notifyThreadReply(msg models.Message) {
+	rootID := *msg.ParentMessageID
+	root, err := s.store.GetMessage(rootID)
+	if err != nil {
+		log.Printf("❌ スレッドの親取得失敗: rootID=%d err=%v", rootID, err)
+		return
+	}
+
+	for _, uid := range []int{root.SenderID, msg.SenderID} {
+		if err := s.store.FollowThread(rootID, uid); err != nil {
+			log.Printf("❌ スレッドの自動フォロー失敗: rootID=%d userID=%d err=%v", rootID, uid, err)
+		}
+	}
+
+	replyTo := s.quotePreviewFor(msg)
+	followers, err := s.store.ListThreadFollowerIDs(rootID)
+	if err != nil {
+		log.Println("❌ スレッドのフォロワー取得失敗:", err)
+	}
+	for _, uid := range followers {
+		if uid == msg.SenderID {
+			continue
+		}
+		s.NotifyUser(uid, protocol.ThreadReplyEvent{
+			RootID:    rootID,
+			ID:        msg.ID,
+			RoomID:    msg.RoomID,
+			SenderID:  msg.SenderID,
+			Content:   msg.Content,
+			Timestamp: msg.Timestamp,
+			ReplyTo:   replyTo,
+		})
+	}
+
+	s.notifyThreadUpdate(*root)
+}
+
+// notifyThreadUpdate はスレッドの返信数・最後の返信時刻をルーム全員に知らせる
+func (s *Server) notifyThreadUpdate(root models.Message) {
+	s.publishToRoom(root.RoomID, protocol.ThreadUpdateEvent{
+		RootID:      root.ID,
+		RoomID:      root.RoomID,
+		ReplyCount:  root.ReplyCount,
+		LastReplyAt: root.LastReplyAt,
+	})
+}
+
+// threadRootForMember は messageID のスレッドの親を返す（返信のIDでもよい）。
+// 見つからなければ 404、ルームのメンバーでなければ 403 を書いて nil を返す。
+func (s *Server) threadRootForMember(w http.ResponseWriter, messageID, userID int) *models.Message {
+	root, err := s.store.GetMessage(messageID)
+	if err == nil && root.ParentMessageID != nil {
+		root, err = s.store.GetMessage(*root.ParentMessageID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, `{"error": "メッセージが見つかりません"}`, http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, `{"error": "メッセージ取得に失敗しました"}`, http.StatusInternalServerError)
+		return nil
+	}
+
+	isMember, err := s.store.IsRoomMember(root.RoomID, userID)
+	if err != nil {
+		http.Error(w, `{"error": "DB error"}`, http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+		return nil
+	}
+	return root
+}
+
+// GetThread はスレッドの親と返信（古い順）を返す。返信は既読にする。
+// GET /messages/thread?message_id=xx[&before=cursor|&after=cursor][&limit=50]
+func (s *Server) GetThread(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, `{"error": "message_id の形式が正しくありません"}`, http.StatusBadRequest)
+		return
+	}
+	page, err := parsePageQuery(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root := s.threadRootForMember(w, messageID, userID)
+	if root == nil {
+		return
+	}
+
+	updates, err := s.store.MarkThreadRead(root.ID, userID)
+	if err != nil {
+		log.Println("❌ スレッド既読UPDATE失敗:", err)
+	}
+	s.notifyReadUpdates(updates)
+	if len(updates) > 0 {
+		s.NotifyUnreadCount(userID, root.RoomID)
+	}
+
+	rows, hasMore, err := s.store.ListThreadPage(root.ID, page)
+	if err != nil {
+		log.Println("❌ スレッド取得失敗:", err)
+		http.Error(w, `{"error": "スレッド取得に失敗しました"}`, http.StatusInternalServerError)
+		return
+	}
+
+	all, err := s.withReadStatus(append([]models.Message{*root}, rows...), userID)
+	if err != nil {
+		log.Println("❌ message_reads 取得失敗:", err)
+	}
+	following, err := s.store.IsFollowingThread(root.ID, userID)
+	if err != nil {
+		log.Println("❌ フォロー状態取得失敗:", err)
+	}
+
+	resp := struct {
+		Root MessageWithStatus `json:"root"`
+		MessagePage
+		Following bool `json:"following"`
+	}{Root: all[0], MessagePage: MessagePage{Messages: all[1:]}, Following: following}
+	resp.PrevCursor, resp.NextCursor = pageCursors(rows, page, hasMore)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// FollowThread はスレッドをフォロー / フォロー解除する
+// POST /messages/thread/follow {"message_id": xx, "follow": true}
+func (s *Server) FollowThread(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.ValidateToken(r)
+	if err != nil {
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		MessageID int  `json:"message_id"`
+		Follow    bool `json:"follow"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	root := s.threadRootForMember(w, req.MessageID, userID)
+	if root == nil {
+		return
+	}
+
+	if req.Follow {
+		err = s.store.FollowThread(root.ID, userID)
+	} else {
+		err = s.store.UnfollowThread(root.ID, userID)
+	}
+	if err != nil {
+		log.Println("❌ スレッドのフォロー更新失敗:", err)
+		http.Error(w, `{"error": "DB error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// フォロー状態で未読数が変わるのでバッジを更新
+	s.NotifyUnreadCount(userID, root.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"root_id":   root.ID,
+		"following": req.Follow,
+	})
+}
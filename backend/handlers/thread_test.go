@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// スレッドの返信はタイムラインに出ず、親の返信数とフォロワーへの通知で知らせる
+func TestThreads(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	carol := signUp(t, ts, "carol")
+	var group struct {
+		RoomID int `json:"room_id"`
+	}
+	decodeBody(t, alice.do(t, ts, "POST", "/rooms", map[string]any{"name": "企画", "user_ids": []int{bob.ID, carol.ID}}), http.StatusOK, &group)
+	root := sendMessage(t, ts, alice, map[string]any{"room_id": group.RoomID, "content": "来週の議題"})
+	aliceWS := dialWS(t, ts, alice)
+	carolWS := dialWS(t, ts, carol)
+
+	first := sendMessage(t, ts, bob, map[string]any{"room_id": group.RoomID, "content": "予算", "parent_message_id": root})
+	// 返信への返信は親への返信になる
+	second := sendMessage(t, ts, alice, map[string]any{"room_id": group.RoomID, "content": "了解", "parent_message_id": first})
+
+	if got := readFrame(t, aliceWS, "thread_reply"); got["id"] != float64(first) || got["root_id"] != float64(root) {
+		t.Errorf("alice に届いた thread_reply = %v", got)
+	}
+	// フォローしていない carol には返信数だけが届く
+	frames := readFramesUntil(t, carolWS, "thread_update")
+	if slices.Contains(typesOf(frames), "thread_reply") {
+		t.Errorf("フォローしていないのに thread_reply が届きました: %v", typesOf(frames))
+	}
+
+	if msgs := getMessages(t, ts, carol, group.RoomID); len(msgs) != 1 || msgs[0].ID != root || msgs[0].ReplyCount != 2 {
+		t.Fatalf("タイムライン = %+v", msgs)
+	}
+
+	type threadPage struct {
+		Root      MessageWithStatus   `json:"root"`
+		Messages  []MessageWithStatus `json:"messages"`
+		Following bool                `json:"following"`
+	}
+	thread := func(u testUser, messageID int) threadPage {
+		t.Helper()
+		var p threadPage
+		decodeBody(t, u.do(t, ts, "GET", fmt.Sprintf("/messages/thread?message_id=%d", messageID), nil), http.StatusOK, &p)
+		return p
+	}
+	tests := []struct {
+		name          string
+		user          testUser
+		messageID     int
+		wantFollowing bool
+	}{
+		{"親の投稿者は自動でフォロー", alice, root, true},
+		{"返信者は自動でフォロー", bob, root, true},
+		{"返信のIDからも開ける", carol, second, false},
+	}
+	for _, tt := range tests {
+		p := thread(tt.user, tt.messageID)
+		var ids []int
+		for _, m := range p.Messages {
+			ids = append(ids, m.ID)
+		}
+		if p.Root.ID != root || !slices.Equal(ids, []int{first, second}) || p.Following != tt.wantFollowing {
+			t.Errorf("%s: root=%d messages=%v following=%v", tt.name, p.Root.ID, ids, p.Following)
+		}
+	}
+
+	// フォローすると次の返信から thread_reply が届く
+	decodeBody(t, carol.do(t, ts, "POST", "/messages/thread/follow", map[string]any{"message_id": root, "follow": true}), http.StatusOK, nil)
+	third := sendMessage(t, ts, bob, map[string]any{"room_id": group.RoomID, "content": "日程も", "parent_message_id": root})
+	if got := readFrame(t, carolWS, "thread_reply"); got["id"] != float64(third) {
+		t.Errorf("carol に届いた thread_reply = %v", got)
+	}
+
+	// 別のルームのメッセージには返信できず、メンバー以外はスレッドを開けない
+	other := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "別件"})
+	decodeBody(t, bob.do(t, ts, "POST", "/messages", map[string]any{"room_id": group.RoomID, "content": "返信", "parent_message_id": other}), http.StatusBadRequest, nil)
+	dave := signUp(t, ts, "dave")
+	decodeBody(t, dave.do(t, ts, "GET", fmt.Sprintf("/messages/thread?message_id=%d", root), nil), http.StatusForbidden, nil)
+	decodeBody(t, dave.do(t, ts, "POST", "/messages/thread/follow", map[string]any{"message_id": root, "follow": true}), http.StatusForbidden, nil)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/hub"
+	"backend/protocol"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// この間に typing_start が送り直されなければ入力をやめたとみなす
+	typingTTL = 6 * time.Second
+	// ユーザー・ルームごとに typing_start を受け付ける最短間隔（これより頻繁なものは捨てる）
+	typingMinInterval = time.Second
+	// 入力中の人数がこれより多ければ、ユーザーIDを省いて人数だけを送る
+	typingListLimit = 5
+	// 期限切れの入力中を消す間隔
+	typingSweepInterval = time.Second
+)
+
+// typingTracker はルームごとの入力中のユーザーを持つ。
+// 入力の開始・終了はバスで全レプリカに届くので、どのレプリカも同じ状態を持ち、自分の接続に送る。
+type typingTracker struct {
+	clients *hub.Registry // 入力中を送る、このレプリカの接続
+
+	mu        sync.Mutex
+	rooms     map[int]map[int]time.Time // roomID → 入力中のユーザー → 期限
+	lastStart map[typingKey]time.Time   // 最後に受け付けた typing_start（このレプリカで受けた分）
+}
+
+// typingKey は typing_start の送りすぎをユーザー・ルームごとに数えるためのキー
+type typingKey struct {
+	userID, roomID int
+}
+
+func newTypingTracker(clients *hub.Registry) *typingTracker {
+	return &typingTracker{
+		clients:   clients,
+		rooms:     make(map[int]map[int]time.Time),
+		lastStart: make(map[typingKey]time.Time),
+	}
+}
+
+// handleTypingRequest は typing_start / typing_stop を受けて、バス経由で全レプリカに知らせる。
+// メンバーでなければ start・stop とも拒否する（拒否したフレームは送りすぎの判定に数えない）。
+func (s *Server) handleTypingRequest(userID, roomID int, start bool) error {
+	isMember, err := s.store.IsRoomMember(roomID, userID)
+	if err != nil {
+		return fmt.Errorf("ルームメンバー確認失敗: %w", err)
+	}
+	if !isMember {
+		eventType := "typing_stop"
+		if start {
+			eventType = "typing_start"
+		}
+		return &protocol.Error{Code: protocol.CodeRejected, Type: eventType, Message: "このルームのメンバーではありません"}
+	}
+	if start && !s.typing.allow(userID, roomID, time.Now()) {
+		return nil // 送りすぎ: 捨てる（直前の typing_start で入力中になっている）
+	}
+	return s.publishTyping(roomID, userID, start)
+}
+
+func (s *Server) publishTyping(roomID, userID int, start bool) error {
+	err := s.bus.Publish(bus.Event{Kind: bus.KindTyping, RoomID: roomID, UserIDs: []int{userID}, Typing: start})
+	if err != nil {
+		return fmt.Errorf("入力中の配送失敗: %w", err)
+	}
+	return nil
+}
+
+// stopTypingAll は userID の入力中をすべて取り消す（最後の接続が切れたとき）
+func (s *Server) stopTypingAll(userID int) {
+	for _, roomID := range s.typing.roomsOf(userID) {
+		if err := s.publishTyping(roomID, userID, false); err != nil {
+			log.Printf("❌ %v: userID=%d roomID=%d", err, userID, roomID)
+		}
+	}
+}
+
+// allow は userID の roomID への typing_start を受け付けてよいかどうか（受け付けたら時刻を記録する）
+func (t *typingTracker) allow(userID, roomID int, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := typingKey{userID: userID, roomID: roomID}
+	if last, ok := t.lastStart[key]; ok && now.Sub(last) < typingMinInterval {
+		return false
+	}
+	t.lastStart[key] = now
+	return true
+}
+
+func (t *typingTracker) has(roomID, userID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.rooms[roomID][userID]
+	return ok
+}
+
+func (t *typingTracker) roomsOf(userID int) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []int
+	for roomID, typers := range t.rooms {
+		if _, ok := typers[userID]; ok {
+			out = append(out, roomID)
+		}
+	}
+	return out
+}
+
+// observe はバスから届いた入力の開始・終了を反映し、入力中の顔ぶれが変わればルームに知らせる。
+// 送り直し（期限の延長）だけなら知らせない。
+func (t *typingTracker) observe(e bus.Event) {
+	var changed []int
+	t.mu.Lock()
+	typers := t.rooms[e.RoomID]
+	for _, uid := range e.UserIDs {
+		_, was := typers[uid]
+		if e.Typing {
+			if typers == nil {
+				typers = make(map[int]time.Time)
+				t.rooms[e.RoomID] = typers
+			}
+			typers[uid] = time.Now().Add(typingTTL)
+		} else {
+			delete(typers, uid)
+		}
+		if was != e.Typing {
+			changed = append(changed, uid)
+		}
+	}
+	if len(typers) == 0 {
+		delete(t.rooms, e.RoomID)
+	}
+	t.mu.Unlock()
+
+	if len(changed) > 0 {
+		t.notify(e.RoomID, changed)
+	}
+}
+
+// sweep は期限を過ぎた入力中を消し、変わったルームに知らせる
+func (t *typingTracker) sweep(now time.Time) {
+	expired := make(map[int][]int)
+	t.mu.Lock()
+	for roomID, typers := range t.rooms {
+		for uid, until := range typers {
+			if now.After(until) {
+				delete(typers, uid)
+				expired[roomID] = append(expired[roomID], uid)
+			}
+		}
+		if len(typers) == 0 {
+			delete(t.rooms, roomID)
+		}
+	}
+	for key, last := range t.lastStart {
+		if now.Sub(last) >= typingMinInterval {
+			delete(t.lastStart, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for roomID, uids := range expired {
+		t.notify(roomID, uids)
+	}
+}
+
+// notify はこのレプリカに接続しているルームのメンバーに、自分以外の入力中を送る。
+// 変わったのが自分の入力だけのメンバーには、見え方が変わらないので送らない。
+func (t *typingTracker) notify(roomID int, changed []int) {
+	t.mu.Lock()
+	typers := make([]int, 0, len(t.rooms[roomID]))
+	for uid := range t.rooms[roomID] {
+		typers = append(typers, uid)
+	}
+	t.mu.Unlock()
+	sort.Ints(typers)
+
+	for _, c := range t.clients.RoomConns(roomID) {
+		if onlySelf(changed, c.UserID) {
+			continue
+		}
+		others := make([]int, 0, len(typers))
+		for _, uid := range typers {
+			if uid != c.UserID {
+				others = append(others, uid)
+			}
+		}
+		ev := protocol.TypingEvent{RoomID: roomID, Count: len(others)}
+		if len(others) <= typingListLimit {
+			ev.UserIDs = others
+		}
+		c.Send(ev)
+	}
+}
+
+// onlySelf は changed が userID の入力だけかどうか
+func onlySelf(changed []int, userID int) bool {
+	for _, uid := range changed {
+		if uid != userID {
+			return false
+		}
+	}
+	return true
+}
+
+// StartTypingExpiry は送り直されなくなった入力中を定期的に消す
+func (s *Server) StartTypingExpiry() {
+	go func() {
+		ticker := time.NewTicker(typingSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.typing.sweep(now)
+		}
+	}()
+}
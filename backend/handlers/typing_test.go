@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"backend/bus"
+	"backend/store"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTyping は conn から roomID に typing_start を送る
+func startTyping(t *testing.T, conn *websocket.Conn, roomID int) {
+	t.Helper()
+	requestID := fmt.Sprintf("typing-%d", time.Now().UnixNano())
+	if err := conn.WriteJSON(map[string]any{"v": 1, "type": "typing_start", "request_id": requestID, "room_id": roomID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// typingUsers は typing フレームの user_ids を []float64 にする
+func typingUsers(frame map[string]any) []float64 {
+	var out []float64
+	ids, _ := frame["user_ids"].([]any)
+	for _, id := range ids {
+		out = append(out, id.(float64))
+	}
+	return out
+}
+
+// 期限切れで複数人の入力中が同時に消えても、入力していた人どうしにも互いの終了が届く
+func TestTypingExpiry(t *testing.T) {
+	srv, ts := newTestServer(t, store.NewMemory(), bus.NewLocal())
+	alice := signUp(t, ts, "alice")
+	bob := signUp(t, ts, "bob")
+	carol := signUp(t, ts, "carol")
+	var room struct {
+		RoomID int `json:"room_id"`
+	}
+	res := alice.do(t, ts, "POST", "/rooms", map[string]any{"name": "企画", "user_ids": []int{bob.ID, carol.ID}})
+	decodeBody(t, res, http.StatusOK, &room)
+	aliceWS := dialWS(t, ts, alice)
+	bobWS := dialWS(t, ts, bob)
+	carolWS := dialWS(t, ts, carol)
+
+	startTyping(t, aliceWS, room.RoomID)
+	if got := typingUsers(readFrame(t, bobWS, "typing")); len(got) != 1 || got[0] != float64(alice.ID) {
+		t.Fatalf("bob に届いた入力中 = %v", got)
+	}
+	startTyping(t, bobWS, room.RoomID)
+	if got := typingUsers(readFrame(t, aliceWS, "typing")); len(got) != 1 || got[0] != float64(bob.ID) {
+		t.Fatalf("alice に届いた入力中 = %v", got)
+	}
+	readFrame(t, carolWS, "typing")
+	if got := typingUsers(readFrame(t, carolWS, "typing")); len(got) != 2 {
+		t.Fatalf("carol に届いた入力中 = %v", got)
+	}
+
+	srv.typing.sweep(time.Now().Add(typingTTL + time.Second))
+
+	for name, conn := range map[string]*websocket.Conn{"alice": aliceWS, "bob": bobWS, "carol": carolWS} {
+		if got := readFrame(t, conn, "typing"); got["count"] != float64(0) {
+			t.Errorf("%s に届いた期限切れ後の入力中 = %v", name, got)
+		}
+	}
+}
+
+// typing_start の送りすぎはユーザー・ルームごとに数える
+func TestTypingAllow(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		userID, roomID int
+		at             time.Time
+		want           bool
+	}{
+		{"最初の typing_start", 1, 10, now, true},
+		{"間隔が短い送り直し", 1, 10, now.Add(typingMinInterval / 2), false},
+		{"別のルーム", 1, 20, now.Add(typingMinInterval / 2), true},
+		{"別のユーザー", 2, 10, now.Add(typingMinInterval / 2), true},
+		{"間隔を空けた送り直し", 1, 10, now.Add(typingMinInterval), true},
+	}
+	tr := newTypingTracker(nil)
+	for _, tt := range tests {
+		if got := tr.allow(tt.userID, tt.roomID, tt.at); got != tt.want {
+			t.Errorf("%s: allow(%d, %d) = %v, want %v", tt.name, tt.userID, tt.roomID, got, tt.want)
+		}
+	}
+}
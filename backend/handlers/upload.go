@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"backend/config"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -49,7 +51,7 @@ func UploadImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 公開用URLを生成
-	url := fmt.Sprintf("http://localhost:8080/static/%s", fileName)
+	url := fmt.Sprintf("%s/static/%s", strings.TrimRight(config.Get().PublicURL, "/"), fileName)
 
 	// JSONでURLを返す
 	w.Header().Set("Content-Type", "application/json")
@@ -1,354 +1,301 @@
-// ✅ Go: handlers/ws.go - WebSocket で reaction を受信し処理する
+// ✅ Go: handlers/ws.go - WebSocket の接続と、クライアントのフレーム（protocol パッケージの型）の振り分け
 
 package handlers
 
 import (
-	"backend/db"
+	"backend/config"
+	"backend/hub"
 	"backend/middleware"
 	"backend/models"
-	"bytes"
-	"encoding/json"
-	"io"
+	"backend/protocol"
+	"errors"
 	"log"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// WebSocket接続管理
-var clients = make(map[int]*websocket.Conn)
-var clientsMu sync.Mutex
+// 再送とみなす request_id を覚えておく期間
+const requestIDTTL = 10 * time.Minute
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:  checkOrigin,
+	Subprotocols: []string{protocol.JSONRPCSubprotocol, protocol.MsgpackSubprotocol}, // クライアントが指定したときだけ使う
 }
 
-func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+// checkOrigin は設定で許可されたオリジンからの接続だけを受け付ける
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // ブラウザ以外のクライアント
+	}
+	for _, allowed := range config.Get().AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	log.Printf("⚠️ 許可されていないオリジンからのWebSocket接続: %s", origin)
+	return false
+}
+
+// wsOptions は設定からハートビート・タイムアウトの値を取り出す
+func wsOptions() hub.Options {
+	ws := config.Get().WS
+	return hub.Options{
+		PingInterval:   ws.PingInterval,
+		PongWait:       ws.PongWait,
+		WriteWait:      ws.WriteWait,
+		MaxMessageSize: ws.MaxMessageSize,
+	}
+}
+
+func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.ValidateToken(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	up := upgrader
+	up.EnableCompression = config.Get().WS.Compression
+	conn, err := up.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 
-	clientsMu.Lock()
-	clients[userID] = conn
-	clientsMu.Unlock()
+	// ?batch=1 のクライアントには、続けて届いたイベントを "batch" に束ねて送る
+	opts := wsOptions()
+	if r.URL.Query().Get("batch") == "1" {
+		opts.BatchWindow = config.Get().WS.BatchWindow
+	}
+	c := s.clients.Add(userID, conn, opts)
+	log.Printf("✅ WebSocket接続: userID=%d connID=%d", userID, c.ID)
+	s.presence.update(userID)
+
+	// 登録してから参加ルームを読み込む（読み込み中に作られたルームは JoinRooms で追加される）
+	s.subscribeUserRooms(userID)
 
-	log.Printf("✅ WebSocket接続: userID=%d", userID)
+	// 読み込みは先に始める（再送中も ping/pong を処理するため）
+	go s.handleIncomingMessages(c, conn.Subprotocol() == protocol.JSONRPCSubprotocol)
 
-	go handleIncomingMessages(userID, conn)
+	// 再接続なら取りこぼしたイベントを再送してから、新しいイベントを流し始める
+	s.resumeEvents(c, r.URL.Query().Get("last_seq"))
 }
 
-func handleIncomingMessages(userID int, conn *websocket.Conn) {
+// handleIncomingMessages はクライアントのフレームを順に処理する。
+// rpc なら JSON-RPC のリクエストとして、そうでなければ従来の {"type": ...} のフレームとして読む。
+func (s *Server) handleIncomingMessages(c *hub.Conn, rpc bool) {
+	userID := c.UserID
 	defer func() {
-		conn.Close()
-		clientsMu.Lock()
-		delete(clients, userID)
-		clientsMu.Unlock()
-		log.Printf("👋 WebSocket切断: userID=%d", userID)
+		c.Close()
+		s.unregisterConn(c)
+		log.Printf("👋 WebSocket切断: userID=%d connID=%d", userID, c.ID)
 	}()
 
 	for {
-		var raw map[string]interface{}
-		if err := conn.ReadJSON(&raw); err != nil {
+		frame, err := c.ReadMessage()
+		if err != nil {
 			log.Println("WebSocketの接続終了:", err)
 			break
 		}
 
-		msgType, ok := raw["type"].(string)
-		if !ok {
-			log.Println("⚠️ 無効なメッセージ形式（typeがない）:", raw)
+		if rpc {
+			s.handleRPCFrame(c, frame)
+			s.presence.touch(userID) // 操作があったので idle から戻す
 			continue
 		}
 
-		switch msgType {
-		case "message":
-			var msg models.Message
-			rawBytes, _ := json.Marshal(raw)
-			if err := json.Unmarshal(rawBytes, &msg); err != nil {
-				log.Println("❌ メッセージ変換失敗:", err)
-				continue
-			}
-
-			log.Printf("📨 受信: %d → %s", msg.SenderID, msg.Content)
-
-			query := `
-    INSERT INTO messages (room_id, sender_id, content)
-    VALUES ($1, $2, $3)
-    RETURNING id, created_at
-  `
-			err := db.Conn.QueryRow(query, msg.RoomID, msg.SenderID, msg.Content).
-				Scan(&msg.ID, &msg.Timestamp)
-			if err != nil {
-				log.Println("❌ メッセージ保存失敗:", err)
-				continue
-			}
-
-			err = models.InsertMessageReads(db.Conn, msg.ID, msg.RoomID)
-			if err != nil {
-				log.Println("❌ 未読挿入失敗:", err)
-			}
-
-			members, err := models.GetRoomMembers(db.Conn, msg.RoomID)
-			if err != nil {
-				log.Println("❌ ルームメンバー取得失敗:", err)
-				continue
-			}
+		h, ev, err := protocol.Decode(frame)
+		if err != nil {
+			replyError(c, h, err)
+			continue
+		}
 
-			clientsMu.Lock()
-			for _, member := range members {
-				if conn, ok := clients[member.ID]; ok {
-					// 📩 メッセージ通知
-					err := conn.WriteJSON(map[string]interface{}{
-						"type":      "message",
-						"id":        msg.ID,
-						"room_id":   msg.RoomID,
-						"sender_id": msg.SenderID,
-						"content":   msg.Content,
-						"timestamp": msg.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
-					})
-					if err != nil {
-						log.Println("⚠️ メッセージ送信エラー:", err)
-					}
-
-					// 📡 未読バッジ通知（自分以外）
-					// 🔁 member.ID に関係なく全員に通知（自分にも含める）
-					var count int
-					err = db.Conn.QueryRow(`
-  SELECT COUNT(*) FROM message_reads mr
-  JOIN messages m ON mr.message_id = m.id
-  WHERE mr.user_id = $1 AND mr.read_at IS NULL AND m.room_id = $2
-`, member.ID, msg.RoomID).Scan(&count)
-					if err != nil {
-						log.Printf("❌ 未読数取得失敗: userID=%d roomID=%d err=%v", member.ID, msg.RoomID, err)
-					} else {
-						err := conn.WriteJSON(map[string]interface{}{
-							"type":    "unread",
-							"room_id": msg.RoomID,
-							"count":   count,
-						})
-						if err != nil {
-							log.Println("⚠️ 未読数送信エラー:", err)
-						}
-					}
-
-				}
-			}
-			clientsMu.Unlock()
-
-		case "read":
-			messageIDFloat, ok1 := raw["message_id"].(float64)
-			readAtStr, ok2 := raw["read_at"].(string)
-			if !ok1 || !ok2 {
-				log.Println("⚠️ read メッセージ形式エラー:", raw)
-				continue
+		// 再送: 処理済みなら前回の ack を返し直し、処理中なら何もしない（処理が終われば ack が届く）
+		prev, dup := s.recentRequests.Begin(userID, h.RequestID)
+		if dup {
+			log.Printf("🔁 再送を無視: userID=%d type=%s request_id=%s", userID, h.Type, h.RequestID)
+			if prev != nil {
+				prev.Duplicate = true
+				c.Send(*prev)
 			}
-			messageID := int(messageIDFloat)
-			log.Printf("📩 read 受信: userID=%d messageID=%d read_at=%s", userID, messageID, readAtStr)
+			continue
+		}
 
-			senderID, err := models.GetSenderIDByMessageID(db.Conn, messageID)
-			if err != nil {
-				log.Printf("❌ senderID取得失敗: messageID=%d err=%v", messageID, err)
-				continue
-			}
-			if senderID == userID {
-				continue
-			}
+		ack, err := s.handleClientEvent(c, h, ev)
+		s.presence.touch(userID) // 操作があったので idle から戻す
+		if err != nil {
+			s.recentRequests.Forget(userID, h.RequestID)
+			replyError(c, h, err)
+			continue
+		}
+		s.recentRequests.Finish(userID, h.RequestID, ack)
+		c.Send(ack)
+	}
+}
 
-			NotifyUser(senderID, map[string]interface{}{
-				"type":       "read",
-				"message_id": messageID,
-				"read_at":    readAtStr,
-			})
-
-			// ✅ 自分のバッジを即時更新（未読が減る）
-			var roomID int
-			err = db.Conn.QueryRow("SELECT room_id FROM messages WHERE id = $1", messageID).Scan(&roomID)
-			if err != nil {
-				log.Printf("❌ roomID取得失敗: messageID=%d err=%v", messageID, err)
-			} else {
-				NotifyUnreadCount(userID, roomID)
-			}
+// unregisterConn は切断した接続だけを外す（同じユーザーの他の端末はそのまま）。
+// 最後の接続だったら入力中を取り消し、プレゼンスを計算し直す。WebSocket と SSE で共通。
+func (s *Server) unregisterConn(c *hub.Conn) {
+	s.clients.Remove(c)
+	if !s.clients.IsOnline(c.UserID) {
+		s.stopTypingAll(c.UserID)
+	}
+	s.presence.update(c.UserID)
+}
 
-		case "mention":
-			fromFloat, ok1 := raw["from"].(float64)
-			toFloat, ok2 := raw["to"].(float64)
-			roomIDFloat, ok3 := raw["room_id"].(float64)
-			message, ok4 := raw["message"].(string)
+// handleClientEvent は受信したイベントを種類ごとの処理に振り分け、返す ack を作る
+func (s *Server) handleClientEvent(c *hub.Conn, h protocol.Header, ev protocol.ClientEvent) (protocol.AckEvent, error) {
+	ack := protocol.AckEvent{RequestID: h.RequestID, RequestType: h.Type}
+
+	var err error
+	switch ev := ev.(type) {
+	case *protocol.MessageRequest:
+		var msg models.Message
+		msg, ack.Duplicate, err = s.handleMessageRequest(c.UserID, ev, h.RequestID)
+		ack.ID, ack.Timestamp = msg.ID, &msg.Timestamp
+	case *protocol.ReadRequest:
+		err = s.handleReadRequest(c.UserID, ev)
+	case *protocol.ReactionRequest:
+		err = s.handleReactionRequest(c.UserID, ev)
+	case *protocol.TypingStartRequest:
+		err = s.handleTypingRequest(c.UserID, ev.RoomID, true)
+	case *protocol.TypingStopRequest:
+		err = s.handleTypingRequest(c.UserID, ev.RoomID, false)
+	case *protocol.PresenceRequest:
+		err = s.handlePresenceRequest(c, ev)
+	default:
+		err = &protocol.Error{Code: protocol.CodeUnknownType, Message: "未対応の type です"}
+	}
+	return ack, err
+}
 
-			if !ok1 || !ok2 || !ok3 || !ok4 {
-				log.Println("⚠️ mention メッセージ形式エラー:", raw)
-				continue
-			}
+// replyError はフレームを処理できなかったことを送信元の接続に返す。
+// *protocol.Error 以外はサーバー側の失敗として中身を伏せる。
+func replyError(c *hub.Conn, h protocol.Header, err error) {
+	var perr *protocol.Error
+	if !errors.As(err, &perr) {
+		log.Printf("❌ WebSocketイベント処理失敗: userID=%d type=%s err=%v", c.UserID, h.Type, err)
+		perr = &protocol.Error{Code: protocol.CodeInternal, Message: "処理に失敗しました"}
+	} else {
+		log.Printf("⚠️ WebSocketイベントを拒否: userID=%d %v", c.UserID, perr)
+	}
+	ev := perr.Event()
+	if ev.RequestType == "" {
+		ev.RequestType = h.Type
+	}
+	if ev.RequestID == "" {
+		ev.RequestID = h.RequestID
+	}
+	c.Send(ev)
+}
 
-			from := int(fromFloat)
-			to := int(toFloat)
-			roomID := int(roomIDFloat)
-
-			log.Printf("📣 mention通知: from=%d → to=%d (%s)", from, to, message)
-
-			NotifyUser(to, map[string]interface{}{
-				"type":    "mention",
-				"from":    from,
-				"room_id": roomID,
-				"message": message,
-			})
-
-		case "reaction":
-			messageIDFloat, ok1 := raw["message_id"].(float64)
-			emojiStr, ok2 := raw["emoji"].(string)
-			if !ok1 || !ok2 {
-				log.Println("⚠️ reaction メッセージ形式エラー:", raw)
-				continue
-			}
-			messageID := int(messageIDFloat)
-			log.Printf("📩 reaction 受信: userID=%d messageID=%d emoji=%s", userID, messageID, emojiStr)
-
-			req := ReactionRequest{MessageID: messageID, Emoji: emojiStr}
-			body, _ := json.Marshal(req)
-			r := &http.Request{Body: io.NopCloser(bytes.NewReader(body))}
-			HandleReaction(nil, r)
-
-			senderID, err := models.GetSenderIDByMessageID(db.Conn, messageID)
-			if err != nil {
-				log.Printf("❌ senderID取得失敗: messageID=%d err=%v", messageID, err)
-				continue
-			}
+// handleMessageRequest は接続ユーザーからのメッセージとして postMessage で保存・配信する。
+// 同じ request_id のメッセージが既にあれば、保存も配信もせずにそのメッセージと dup=true を返す。
+func (s *Server) handleMessageRequest(userID int, req *protocol.MessageRequest, requestID string) (models.Message, bool, error) {
+	// sender_id は接続の認証から決まる。送ってきた場合は一致しなければ拒否する
+	if req.SenderID != 0 && req.SenderID != userID {
+		return models.Message{}, false, &protocol.Error{Code: protocol.CodeRejected, Type: req.EventType(), Message: "sender_id が接続ユーザーと一致しません"}
+	}
+	log.Printf("📨 受信: userID=%d roomID=%d", userID, req.RoomID)
+
+	msg, dup, err := s.postMessage(models.Message{
+		RoomID:           req.RoomID,
+		SenderID:         userID,
+		Content:          req.Content,
+		ParentMessageID:  req.ParentMessageID,
+		ReplyToMessageID: req.ReplyToMessageID,
+		ClientRequestID:  requestID,
+	})
+	if isMessageRejection(err) {
+		return msg, false, &protocol.Error{Code: protocol.CodeRejected, Type: req.EventType(), Message: err.Error()}
+	}
+	return msg, dup, err
+}
 
-			payload := map[string]interface{}{
-				"type":       "reaction",
-				"message_id": messageID,
-				"emoji":      emojiStr,
-			}
+// handleReadRequest は REST・JSON-RPC と同じ markMessageRead で既読にする（既読の時刻はサーバーが決める）
+func (s *Server) handleReadRequest(userID int, req *protocol.ReadRequest) error {
+	log.Printf("📩 read 受信: userID=%d messageID=%d", userID, req.MessageID)
 
-			// 🔁 自分にも通知（これが必要）
-			NotifyUser(userID, payload)
+	err := s.markMessageRead(userID, req.MessageID)
+	if isMessageRejection(err) {
+		return &protocol.Error{Code: protocol.CodeRejected, Type: req.EventType(), Message: err.Error()}
+	}
+	return err
+}
 
-			// 🔁 相手にも通知（同一人物でなければ）
-			if senderID != userID {
-				NotifyUser(senderID, payload)
-			}
+func (s *Server) handleReactionRequest(userID int, req *protocol.ReactionRequest) error {
+	log.Printf("📩 reaction 受信: userID=%d messageID=%d emoji=%s", userID, req.MessageID, req.Emoji)
 
-		default:
-			log.Println("⚠️ 未対応のメッセージtype:", msgType)
-		}
+	_, err := s.reactToMessage(userID, req.MessageID, req.Emoji)
+	if isMessageRejection(err) {
+		return &protocol.Error{Code: protocol.CodeRejected, Type: req.EventType(), Message: err.Error()}
 	}
+	return err
 }
 
-// 特定ユーザーにWebSocketで通知
-func NotifyUser(userID int, payload interface{}) {
-	log.Printf("📡 NotifyUser呼び出し: userID=%d payload=%v", userID, payload)
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-
-	if conn, ok := clients[userID]; ok {
-		if err := conn.WriteJSON(payload); err != nil {
-			log.Printf("⚠️ WebSocket通知エラー: userID=%d, err=%v", userID, err)
-		} else {
-			log.Printf("✅ WebSocket通知成功: userID=%d", userID)
-		}
-	} else {
-		log.Printf("❌ WebSocket未接続: userID=%d", userID)
+// subscribeUserRooms は userID の参加ルームをすべて購読させる
+func (s *Server) subscribeUserRooms(userID int) {
+	rooms, err := s.store.ListUserRooms(userID, false)
+	if err != nil {
+		log.Printf("❌ 参加ルーム取得失敗（ルーム宛ての通知が届きません）: userID=%d err=%v", userID, err)
+		return
+	}
+	roomIDs := make([]int, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.ID)
 	}
+	s.clients.JoinRooms(userID, roomIDs...)
 }
 
-// メッセージ編集をルーム内全員に通知する
-// handlers/ws.go
+// 特定ユーザーにWebSocketで通知（イベントログに記録するので、未接続でも再接続時に届く）
+func (s *Server) NotifyUser(userID int, payload protocol.ServerEvent) {
+	// 同じユーザーの全端末に送る（既読・未読などを端末間で同期するため）。どのレプリカに接続していても届く
+	// 本文はログに残さない（メッセージの内容が含まれるため）
+	if seqs, err := s.publish([]int{userID}, payload); err == nil {
+		log.Printf("📡 WebSocket通知を配送: userID=%d type=%s seq=%d", userID, payload.EventType(), seqs[userID])
+	}
+}
 
 // BroadcastEdit は指定されたルームに編集通知を送信する
-func BroadcastEdit(roomID int, messageID int, content string) {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-
-	for uid, conn := range clients {
-		if conn != nil {
-			err := conn.WriteJSON(map[string]interface{}{
-				"type":       "edit",
-				"message_id": messageID,
-				"content":    content,
-			})
-			if err != nil {
-				log.Printf("⚠️ BroadcastEdit失敗: userID=%d, err=%v", uid, err)
-			}
-		}
-	}
+func (s *Server) BroadcastEdit(roomID int, messageID int, content string, editedAt *time.Time) {
+	s.publishToRoom(roomID, protocol.EditEvent{MessageID: messageID, Content: content, EditedAt: editedAt})
 }
 
 // BroadcastDelete は指定されたルームに削除通知を送信する
-func BroadcastDelete(roomID int, messageID int) {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-
-	for uid, conn := range clients {
-		if conn != nil {
-			err := conn.WriteJSON(map[string]interface{}{
-				"type":       "delete",
-				"message_id": messageID,
-			})
-			if err != nil {
-				log.Printf("⚠️ BroadcastDelete失敗: userID=%d, err=%v", uid, err)
-			}
-		}
-	}
+func (s *Server) BroadcastDelete(roomID int, messageID int) {
+	s.publishToRoom(roomID, protocol.DeleteEvent{MessageID: messageID})
 }
 
-func BroadcastMessage(roomID int, messageID int, senderID int, content string, createdAt time.Time) {
-	msg := map[string]interface{}{
-		"type":      "message",
-		"id":        messageID,
-		"room_id":   roomID,
-		"sender_id": senderID,
-		"content":   content,
-		"read_at":   nil,
-		"timestamp": createdAt.Format(time.RFC3339),
-	}
-	b, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("❌ BroadcastMessage JSONエンコード失敗: %v", err)
-		return
+// BroadcastRestore は管理者が復元したメッセージをルームに通知する
+func (s *Server) BroadcastRestore(roomID int, messageID int, content string) {
+	s.publishToRoom(roomID, protocol.RestoreEvent{MessageID: messageID, Content: content})
+}
+
+func (s *Server) BroadcastMessage(roomID int, messageID int, senderID int, content string, createdAt time.Time, replyTo *models.QuotePreview) {
+	msg := protocol.MessageEvent{
+		ID:        messageID,
+		RoomID:    roomID,
+		SenderID:  senderID,
+		Content:   content,
+		Timestamp: createdAt,
+		ReplyTo:   replyTo,
 	}
 
-	// 全クライアントに送信
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-	for uid, conn := range clients {
-		if conn == nil {
-			continue
-		}
-		if err := conn.WriteMessage(1, b); err != nil {
-			log.Printf("⚠️ メッセージ送信失敗 userID=%d: %v", uid, err)
-		} else {
-			log.Printf("📩 BroadcastMessage: userID=%d に送信", uid)
-		}
+	// ルームのメンバーにだけ送信
+	if err := s.publishToRoom(roomID, msg); err == nil {
+		log.Printf("📩 BroadcastMessage: roomID=%d messageID=%d", roomID, messageID)
 	}
 }
 
-func NotifyUnreadCount(userID int, roomID int) {
-	var count int
-	err := db.Conn.QueryRow(`
-		SELECT COUNT(*) FROM message_reads mr
-		JOIN messages m ON mr.message_id = m.id
-		WHERE mr.user_id = $1 AND mr.read_at IS NULL AND m.room_id = $2
-	`, userID, roomID).Scan(&count)
+func (s *Server) NotifyUnreadCount(userID int, roomID int) {
+	count, err := s.countUnread(userID, roomID)
 	if err != nil {
 		log.Printf("❌ NotifyUnreadCount失敗: userID=%d roomID=%d err=%v", userID, roomID, err)
 		return
 	}
 
-	payload := map[string]interface{}{
-		"type":    "unread",
-		"room_id": roomID,
-		"count":   count,
-	}
-	NotifyUser(userID, payload)
+	s.NotifyUser(userID, protocol.UnreadEvent{RoomID: roomID, Count: count})
 }
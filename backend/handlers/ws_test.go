@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket で送ったメッセージに ack が返り、相手の接続に届く。同じ request_id の再送は保存し直さない。
+func TestWebSocketRoundTrip(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	aliceWS := dialWS(t, ts, alice)
+	bobWS := dialWS(t, ts, bob)
+
+	frame := map[string]any{"v": 1, "type": "message", "request_id": "req-1", "room_id": roomID, "content": "WebSocket から"}
+	if err := aliceWS.WriteJSON(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	ack := readFrame(t, aliceWS, "ack")
+	if ack["request_id"] != "req-1" || ack["request_type"] != "message" || ack["id"] == nil {
+		t.Fatalf("ack = %v", ack)
+	}
+	got := readFrame(t, bobWS, "message")
+	if got["id"] != ack["id"] || got["content"] != "WebSocket から" || got["sender_id"] != float64(alice.ID) {
+		t.Errorf("bob に届いたメッセージ = %v（ack = %v）", got, ack)
+	}
+
+	if err := aliceWS.WriteJSON(frame); err != nil {
+		t.Fatal(err)
+	}
+	again := readFrame(t, aliceWS, "ack")
+	if again["id"] != ack["id"] || again["duplicate"] != true {
+		t.Errorf("再送の ack = %v（最初の ack = %v）", again, ack)
+	}
+	if msgs := getMessages(t, ts, alice, roomID); len(msgs) != 1 {
+		t.Errorf("再送で保存し直されました: %d件", len(msgs))
+	}
+}
+
+// 同じユーザーの複数の接続（端末・タブ）すべてに届き、1つを閉じても残りには届き続ける
+func TestMultipleDevices(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	phone := dialWS(t, ts, bob)
+	laptop := dialWS(t, ts, bob)
+
+	sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "1通目"})
+	for _, conn := range []*websocket.Conn{phone, laptop} {
+		if got := readFrame(t, conn, "message"); got["content"] != "1通目" {
+			t.Errorf("message = %v", got)
+		}
+	}
+
+	phone.Close()
+	sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "2通目"})
+	if got := readFrame(t, laptop, "message"); got["content"] != "2通目" {
+		t.Errorf("message = %v", got)
+	}
+}
+
+// 編集・削除はルームのメンバーにだけ1回ずつ届く（編集した本人にも重ならない）
+func TestRoomScopedBroadcast(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	carol := signUp(t, ts, "carol")
+	id := sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "下書き"})
+	aliceWS := dialWS(t, ts, alice)
+	bobWS := dialWS(t, ts, bob)
+	carolWS := dialWS(t, ts, carol)
+
+	decodeBody(t, alice.do(t, ts, "PUT", fmt.Sprintf("/messages/edit?id=%d", id), map[string]any{"content": "清書"}), http.StatusOK, nil)
+	decodeBody(t, alice.do(t, ts, "DELETE", fmt.Sprintf("/messages/delete?id=%d", id), nil), http.StatusOK, nil)
+	sendMessage(t, ts, alice, map[string]any{"room_id": roomID, "content": "区切り"})
+
+	for name, conn := range map[string]*websocket.Conn{"alice": aliceWS, "bob": bobWS} {
+		types := typesOf(readFramesUntil(t, conn, "message"))
+		if count(types, "edit") != 1 || count(types, "delete") != 1 {
+			t.Errorf("%s に届いたイベント = %v（edit と delete を1回ずつ）", name, types)
+		}
+	}
+
+	// carol には別のルームのメッセージだけが届く
+	other := openRoom(t, ts, carol, alice)
+	sendMessage(t, ts, alice, map[string]any{"room_id": other, "content": "carol さんへ"})
+	for _, f := range readFramesUntil(t, carolWS, "message") {
+		if f["type"] == "edit" || f["type"] == "delete" || f["room_id"] == float64(roomID) {
+			t.Errorf("メンバーでない carol に届きました: %v", f)
+		}
+	}
+}
+
+func count(types []any, eventType string) int {
+	n := 0
+	for _, t := range types {
+		if t == eventType {
+			n++
+		}
+	}
+	return n
+}
+
+// WebSocket の "message" は接続ユーザーとして、そのユーザーがメンバーのルームにだけ送れる
+func TestWebSocketMessageAuthorization(t *testing.T) {
+	ts, alice, bob, roomID := startChat(t)
+	carol := signUp(t, ts, "carol")
+	aliceWS := dialWS(t, ts, alice)
+	carolWS := dialWS(t, ts, carol)
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		frame    map[string]any
+		wantCode string // 空なら ack
+	}{
+		{"sender_id なし", aliceWS, map[string]any{"room_id": roomID, "content": "本人"}, ""},
+		{"自分の sender_id", aliceWS, map[string]any{"room_id": roomID, "sender_id": alice.ID, "content": "本人"}, ""},
+		{"他人の sender_id", aliceWS, map[string]any{"room_id": roomID, "sender_id": bob.ID, "content": "なりすまし"}, "rejected"},
+		{"メンバーでないルーム", carolWS, map[string]any{"room_id": roomID, "content": "割り込み"}, "rejected"},
+		{"存在しないルーム", aliceWS, map[string]any{"room_id": 9999, "content": "どこか"}, "rejected"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestID := fmt.Sprintf("req-%d", i)
+			frame := map[string]any{"v": 1, "type": "message", "request_id": requestID}
+			for k, v := range tt.frame {
+				frame[k] = v
+			}
+			if err := tt.conn.WriteJSON(frame); err != nil {
+				t.Fatal(err)
+			}
+			want := "ack"
+			if tt.wantCode != "" {
+				want = "error"
+			}
+			got := readFrame(t, tt.conn, want)
+			if got["request_id"] != requestID || (tt.wantCode != "" && got["code"] != tt.wantCode) {
+				t.Errorf("%s = %v", want, got)
+			}
+		})
+	}
+
+	msgs := getMessages(t, ts, bob, roomID)
+	for _, m := range msgs {
+		if m.SenderID != alice.ID || m.Content != "本人" {
+			t.Errorf("保存されたメッセージ = %+v", m)
+		}
+	}
+	if len(msgs) != 2 {
+		t.Errorf("保存されたメッセージ数 = %d, want 2", len(msgs))
+	}
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// 1つのフレームに束ねるイベントの最大数
+const batchMaxFrames = 64
+
+// coalesce は束ねるフレームのうち、後のイベントで意味がなくなったものを除く。
+// 今は同じルームの "unread" を最後の1件だけにする（未読数は最新の値だけが意味を持ち、
+// 後のイベントほど seq が大きいので、除いても再接続で渡す last_seq はずれない）。
+func coalesce(frames [][]byte) [][]byte {
+	seen := make(map[int]bool)
+	out := make([][]byte, 0, len(frames))
+	for i := len(frames) - 1; i >= 0; i-- {
+		if roomID, ok := unreadRoom(frames[i]); ok {
+			if seen[roomID] {
+				continue
+			}
+			seen[roomID] = true
+		}
+		out = append(out, frames[i])
+	}
+	// 後ろから見たので元の順に戻す
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+var unreadMarker = []byte(`"type":"unread"`)
+
+// unreadRoom は frame が "unread" ならそのルームIDを返す
+func unreadRoom(frame []byte) (int, bool) {
+	if !bytes.Contains(frame, unreadMarker) {
+		return 0, false // ほとんどのフレームは読まずに済ませる
+	}
+	var ev struct {
+		Type   string `json:"type"`
+		RoomID int    `json:"room_id"`
+	}
+	if err := json.Unmarshal(frame, &ev); err != nil || ev.Type != "unread" {
+		return 0, false
+	}
+	return ev.RoomID, true
+}
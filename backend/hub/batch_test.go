@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCoalesce(t *testing.T) {
+	var (
+		unread1a = `{"v":1,"type":"unread","room_id":1,"count":1}`
+		unread1b = `{"v":1,"type":"unread","room_id":1,"count":2}`
+		unread2  = `{"v":1,"type":"unread","room_id":2,"count":5}`
+		message  = `{"v":1,"type":"message","room_id":1,"content":"\"type\":\"unread\""}`
+		read     = `{"v":1,"type":"read","message_ids":[1,2],"read_at":"2026-01-01T00:00:00Z"}`
+	)
+	tests := []struct {
+		name   string
+		frames []string
+		want   []string
+	}{
+		{"空", nil, []string{}},
+		{"unread 以外はそのまま", []string{message, read}, []string{message, read}},
+		{"同じルームの unread は最後だけ", []string{unread1a, message, unread1b}, []string{message, unread1b}},
+		{"別のルームの unread は残す", []string{unread1a, unread2, unread1b}, []string{unread2, unread1b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := make([][]byte, len(tt.frames))
+			for i, f := range tt.frames {
+				frames[i] = []byte(f)
+			}
+			got := make([]string, 0)
+			for _, f := range coalesce(frames) {
+				got = append(got, string(f))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("coalesce = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package hub
+
+import (
+	"backend/protocol"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 1接続あたりの送信待ちバッファ。溢れたら古いものから捨てずに新しいものを捨て、再同期を促す
+const sendBuffer = 256
+
+// Options は接続ごとのハートビート・タイムアウト設定
+type Options struct {
+	PingInterval   time.Duration // ping を送る間隔（PongWait より短くする）
+	PongWait       time.Duration // この間に pong もメッセージも届かなければ切れたとみなす
+	WriteWait      time.Duration // 1回の書き込みにかけられる時間。超えたら詰まっているとみなして切断する
+	MaxMessageSize int64         // 受信する1メッセージの最大バイト数
+	BatchWindow    time.Duration // 最初のイベントからこの間に積まれたイベントを1フレームに束ねる（0なら束ねない）
+}
+
+// resyncMessage はバッファ溢れでイベントを取りこぼした接続に送る。クライアントは一覧を取り直す。
+var resyncMessage, _ = protocol.Encode(protocol.ResyncEvent{})
+
+// Conn は1本の接続（端末・タブごと。WebSocket か SSE）。ID はプロセス内で一意。
+// 書き込みは専用の writer ゴルーチンだけが行い、送信側は Send でキューに積むだけ（I/Oで待たない）。
+//
+// 接続直後は保留状態で、seq 付きのイベントは送らずに溜めておく。Release で取りこぼした分を再送してから
+// 溜めた分を流すので、再送と新着が入れ替わったり重複したりしない。
+type Conn struct {
+	ID     uint64
+	UserID int
+
+	ws      *websocket.Conn // 読み込み用（SSE なら nil）
+	t       transport
+	opts    Options
+	send    chan []byte
+	done    chan struct{}
+	stopped chan struct{} // writer が終わったら閉じる
+	once    sync.Once
+	resync  atomic.Bool // 取りこぼしがあり、キューが空いたら resync を送る
+	reaped  atomic.Bool // 応答がない・書き込めないため、サーバー側から切断した
+
+	lastActive atomic.Int64 // 最後にクライアントからフレームが届いた時刻（UnixNano）。pong は数えない
+	idle       atomic.Bool  // クライアントが離席を知らせた（次のフレームで解除）
+	passive    bool         // 受信しない接続（SSE）。フレームが届かないので、つながっている間は操作中とみなす
+
+	mu      sync.Mutex
+	held    bool       // Release 前。seq 付きのイベントは pending に溜める
+	pending []seqFrame // 保留中に届いた seq 付きのイベント
+	floor   int64      // この seq 以下は再送済み（または接続前）なので送らない
+}
+
+type seqFrame struct {
+	seq   int64
+	frame []byte
+}
+
+func newConn(id uint64, userID int, t transport, opts Options) *Conn {
+	c := &Conn{
+		ID:      id,
+		UserID:  userID,
+		t:       t,
+		opts:    opts,
+		send:    make(chan []byte, sendBuffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		held:    true,
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c
+}
+
+// newWSConn は WebSocket の接続を作り、writer を起動する。
+// サブプロトコルで接続していれば、イベントを JSON-RPC の通知・MessagePack にして書き出す。
+func newWSConn(id uint64, userID int, ws *websocket.Conn, opts Options) *Conn {
+	var t transport = wsTransport{ws}
+	switch ws.Subprotocol() {
+	case protocol.JSONRPCSubprotocol:
+		t = jsonrpcTransport{wsTransport{ws}}
+	case protocol.MsgpackSubprotocol:
+		t = msgpackTransport{wsTransport{ws}}
+	}
+	c := newConn(id, userID, t, opts)
+	c.ws = ws
+
+	// pong（またはメッセージ）が届くたびに読み込み期限を延ばす。届かなければ ReadJSON がタイムアウトする。
+	ws.SetReadLimit(opts.MaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(opts.PongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(opts.PongWait))
+	})
+
+	go c.writePump()
+	return c
+}
+
+// Send は ev をフレームにして送信キューに積む。キューが一杯なら捨てて false を返す。
+func (c *Conn) Send(ev protocol.ServerEvent) bool {
+	b, err := protocol.Encode(ev)
+	if err != nil {
+		log.Printf("❌ WebSocket送信データのJSON変換失敗: type=%s err=%v", ev.EventType(), err)
+		return false
+	}
+	return c.enqueue(b)
+}
+
+// SendRaw はエンコード済みのフレーム（JSON-RPC のレスポンスなど）をそのまま送信キューに積む
+func (c *Conn) SendRaw(b []byte) bool {
+	return c.enqueue(b)
+}
+
+// enqueueSeq は seq 付きのイベントを積む。保留中なら Release まで溜める。
+// seq が 0（ログに記録できなかった）なら seq なしのイベントとしてそのまま積む。
+func (c *Conn) enqueueSeq(seq int64, b []byte) bool {
+	if seq == 0 {
+		return c.enqueue(b)
+	}
+	c.mu.Lock()
+	if seq <= c.floor {
+		c.mu.Unlock()
+		return true
+	}
+	if c.held {
+		defer c.mu.Unlock()
+		if len(c.pending) >= sendBuffer {
+			c.dropped()
+			return false
+		}
+		c.pending = append(c.pending, seqFrame{seq, b})
+		return true
+	}
+	c.mu.Unlock()
+	return c.enqueue(b)
+}
+
+// Release は保留を解く。replay（floor までの取りこぼし）と ready を順に積んでから、
+// 保留中に溜まったイベントのうち floor より新しいものを流す。
+// replay はキューが空くのを待って積むので、件数が送信バッファより多くてもよい。
+func (c *Conn) Release(floor int64, replay [][]byte, ready []byte) {
+	c.mu.Lock()
+	c.floor = floor
+	c.mu.Unlock()
+
+	for _, b := range append(replay, ready) {
+		select {
+		case c.send <- b:
+		case <-c.done:
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.pending {
+		if f.seq > c.floor {
+			c.enqueue(f.frame)
+		}
+	}
+	c.pending = nil
+	c.held = false
+}
+
+func (c *Conn) enqueue(b []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- b:
+		return true
+	default:
+		c.dropped()
+		return false
+	}
+}
+
+// dropped はイベントを捨てたことを記録し、キューが空いたら resync を送らせる
+func (c *Conn) dropped() {
+	if !c.resync.Swap(true) {
+		log.Printf("🐢 送信バッファが一杯のためイベントを破棄（再同期を要求）: userID=%d connID=%d", c.UserID, c.ID)
+	}
+}
+
+// ReadMessage は次のフレームを読む（読み込みは接続ごとに1ゴルーチンから呼ぶこと）。
+// 中身の解釈は protocol.Decode で行う。バイナリのフレームは MessagePack として JSON に直して返す
+// （直せなければそのまま返し、Decode が malformed として扱う）。
+// 期限内に pong が届かなかった場合は切れた接続として回収対象にする。
+func (c *Conn) ReadMessage() ([]byte, error) {
+	typ, b, err := c.ws.ReadMessage()
+	if err == nil {
+		now := time.Now()
+		c.ws.SetReadDeadline(now.Add(c.opts.PongWait))
+		c.lastActive.Store(now.UnixNano())
+		c.idle.Store(false)
+		if typ == websocket.BinaryMessage {
+			if j, err := protocol.MsgpackToJSON(b); err == nil {
+				b = j
+			}
+		}
+		return b, nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.reap("pong タイムアウト")
+	}
+	return nil, err
+}
+
+// SetIdle はクライアントが離席した（タブを隠したなど）ことを記録する。次にフレームが届くと解除される。
+func (c *Conn) SetIdle() {
+	c.idle.Store(true)
+}
+
+// Activity は最後にクライアントからフレームが届いた時刻と、離席中かどうかを返す。
+// 受信しない接続（SSE）は常に今操作中として返す。
+func (c *Conn) Activity() (lastActive time.Time, idle bool) {
+	if c.passive {
+		return time.Now(), false
+	}
+	return time.Unix(0, c.lastActive.Load()), c.idle.Load()
+}
+
+// Reaped はサーバー側が応答なしとして切断した接続かどうか
+func (c *Conn) Reaped() bool {
+	return c.reaped.Load()
+}
+
+func (c *Conn) reap(reason string) {
+	if !c.reaped.Swap(true) {
+		log.Printf("💀 応答のない接続を回収: userID=%d connID=%d reason=%s", c.UserID, c.ID, reason)
+	}
+	c.Close()
+}
+
+// Close は writer を止めて接続を閉じる。何度呼んでもよい。
+func (c *Conn) Close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// Stopped は writer が終わったら閉じるチャネルを返す（書き込みに失敗したときも含む）。
+// SSE ではこれを待ってからハンドラーを抜ける（抜けたあとにレスポンスへ書き込まないように）。
+func (c *Conn) Stopped() <-chan struct{} {
+	return c.stopped
+}
+
+// writePump は送信キューを順に書き出し、定期的に ping を送る。
+// 書き込みに失敗するか Close されたら接続を閉じて終わる（ws を閉じるので読み込み側も終わる）。
+func (c *Conn) writePump() {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.t.close()
+		close(c.stopped)
+	}()
+
+	for {
+		select {
+		case b := <-c.send:
+			var err error
+			if c.opts.BatchWindow > 0 {
+				err = c.writeBatch(b)
+			} else {
+				err = c.write(b)
+			}
+			if err != nil {
+				c.reap("書き込み失敗: " + err.Error())
+				return
+			}
+			// 取りこぼしがあった接続には、溜まっていた分を書き終えてから再同期を促す
+			if len(c.send) == 0 && c.resync.CompareAndSwap(true, false) {
+				if err := c.write(resyncMessage); err != nil {
+					c.reap("書き込み失敗: " + err.Error())
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := c.t.ping(time.Now().Add(c.opts.WriteWait)); err != nil {
+				c.reap("ping 送信失敗: " + err.Error())
+				return
+			}
+		case <-c.done:
+			c.t.goodbye(time.Now().Add(c.opts.WriteWait))
+			return
+		}
+	}
+}
+
+// writeBatch は first から BatchWindow の間に積まれたフレームを集めて、1回で書き出す。
+// 最初のフレームを BatchWindow より長く待たせない。batchMaxFrames 件たまったらすぐ書き出す。
+func (c *Conn) writeBatch(first []byte) error {
+	frames := [][]byte{first}
+	timer := time.NewTimer(c.opts.BatchWindow)
+	defer timer.Stop()
+collect:
+	for len(frames) < batchMaxFrames {
+		select {
+		case b := <-c.send:
+			frames = append(frames, b)
+		case <-timer.C:
+			break collect
+		case <-c.done:
+			break collect
+		}
+	}
+
+	frames = coalesce(frames)
+	if len(frames) == 1 {
+		return c.write(frames[0])
+	}
+	return c.t.writeBatch(frames, time.Now().Add(c.opts.WriteWait))
+}
+
+func (c *Conn) write(b []byte) error {
+	return c.t.write(b, time.Now().Add(c.opts.WriteWait))
+}
@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTransport は書き込まれたフレームを frames に、ping を pings に流す。
+// block が閉じるまで書き込みを待たせたり、err で書き込みを失敗させたりできる。
+type fakeTransport struct {
+	frames chan string
+	pings  chan struct{}
+	block  chan struct{}
+	err    error
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{frames: make(chan string, 2*sendBuffer), pings: make(chan struct{}, 1)}
+}
+
+func (t *fakeTransport) write(frame []byte, deadline time.Time) error {
+	if t.block != nil {
+		<-t.block
+	}
+	if t.err != nil {
+		return t.err
+	}
+	t.frames <- string(frame)
+	return nil
+}
+
+func (t *fakeTransport) writeBatch(frames [][]byte, deadline time.Time) error {
+	for _, f := range frames {
+		t.write(f, deadline)
+	}
+	return nil
+}
+
+func (t *fakeTransport) ping(deadline time.Time) error {
+	select {
+	case t.pings <- struct{}{}:
+	default:
+	}
+	return nil
+}
+func (t *fakeTransport) goodbye(deadline time.Time) {}
+func (t *fakeTransport) close()                     {}
+
+var testOptions = Options{PingInterval: time.Hour, PongWait: time.Hour, WriteWait: time.Second}
+
+// next は t に次に書き込まれたフレームを返す
+func (t *fakeTransport) next(tb testing.TB) string {
+	tb.Helper()
+	select {
+	case f := <-t.frames:
+		return f
+	case <-time.After(5 * time.Second):
+		tb.Fatal("フレームが書き込まれません")
+		return ""
+	}
+}
+
+// 保留中に届いた seq 付きのイベントは、再送と ready のあとに、再送済みの分を除いて流す
+func TestConnRelease(t *testing.T) {
+	c := newConn(1, 1, newFakeTransport(), testOptions)
+	c.enqueue([]byte("seq なし"))
+	for seq := int64(1); seq <= 3; seq++ {
+		c.enqueueSeq(seq, []byte(fmt.Sprintf("seq %d", seq)))
+	}
+
+	c.Release(2, [][]byte{[]byte("再送 1"), []byte("再送 2")}, []byte("ready"))
+	c.enqueueSeq(2, []byte("seq 2 の重複"))
+	c.enqueueSeq(4, []byte("seq 4"))
+
+	var got []string
+	for len(c.send) > 0 {
+		got = append(got, string(<-c.send))
+	}
+	want := []string{"seq なし", "再送 1", "再送 2", "ready", "seq 3", "seq 4"}
+	if !slices.Equal(got, want) {
+		t.Errorf("送信順 = %q, want %q", got, want)
+	}
+}
+
+// 書き込みが詰まっても Send は待たずに捨て、キューが空いたら resync を送る
+func TestConnDropsWhenFull(t *testing.T) {
+	tr := newFakeTransport()
+	tr.block = make(chan struct{})
+	c := newConn(1, 1, tr, testOptions)
+	go c.writePump()
+	defer c.Close()
+
+	dropped := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < sendBuffer+2; i++ {
+			if !c.SendRaw([]byte(fmt.Sprintf("frame %d", i))) {
+				dropped = true
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("キューが一杯のときに Send が待ちました")
+	}
+	if !dropped {
+		t.Fatal("キューが一杯なのに捨てられませんでした")
+	}
+
+	close(tr.block)
+	for {
+		if f := tr.next(t); strings.Contains(f, `"type":"resync"`) {
+			break
+		}
+	}
+}
+
+func TestRegistryRooms(t *testing.T) {
+	r := NewRegistry()
+	add := func(userID int) *Conn {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.nextID++
+		return r.addLocked(newConn(r.nextID, userID, newFakeTransport(), testOptions))
+	}
+	alicePC, alicePhone, bob := add(1), add(1), add(2)
+	r.JoinRooms(1, 10)
+	r.JoinRooms(2, 10, 20)
+	r.JoinRooms(3, 10) // 接続していないユーザーは購読しない
+
+	connIDs := func(roomID int) []uint64 {
+		var ids []uint64
+		for _, c := range r.RoomConns(roomID) {
+			ids = append(ids, c.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	if got := connIDs(10); !slices.Equal(got, []uint64{alicePC.ID, alicePhone.ID, bob.ID}) {
+		t.Errorf("ルーム10の接続 = %v", got)
+	}
+	if s := r.Stats(); s.Users != 2 || s.Connections != 3 {
+		t.Errorf("Stats = %+v", s)
+	}
+
+	// 最後の接続が切れたときだけ購読を外す
+	r.Remove(alicePC)
+	if got := connIDs(10); !slices.Equal(got, []uint64{alicePhone.ID, bob.ID}) {
+		t.Errorf("1台切断後のルーム10の接続 = %v", got)
+	}
+	r.Remove(alicePhone)
+	if got := connIDs(10); !slices.Equal(got, []uint64{bob.ID}) || r.IsOnline(1) {
+		t.Errorf("全台切断後のルーム10の接続 = %v, online = %v", got, r.IsOnline(1))
+	}
+}
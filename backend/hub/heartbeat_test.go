@@ -0,0 +1,106 @@
+package hub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitStopped は c の writer が終わるまで待つ
+func waitStopped(t *testing.T, c *Conn) {
+	t.Helper()
+	select {
+	case <-c.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer が終わりません")
+	}
+}
+
+func TestConnPing(t *testing.T) {
+	tr := newFakeTransport()
+	c := newConn(1, 1, tr, Options{PingInterval: 10 * time.Millisecond, PongWait: time.Second, WriteWait: time.Second})
+	go c.writePump()
+	defer c.Close()
+
+	select {
+	case <-tr.pings:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping が送られません")
+	}
+}
+
+// 書き込めない接続は回収し、Stats の回収数に数える。Close で閉じた接続は数えない。
+func TestConnReapOnWriteError(t *testing.T) {
+	r := NewRegistry()
+	tests := []struct {
+		name      string
+		err       error
+		wantReap  bool
+		wantTotal uint64 // Stats の回収数（累計）
+	}{
+		{"書き込み失敗", errors.New("broken pipe"), true, 1},
+		{"Close", nil, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newFakeTransport()
+			tr.err = tt.err
+			r.nextID++
+			c := r.addLocked(newConn(r.nextID, 1, tr, testOptions))
+			go c.writePump()
+			if tt.err != nil {
+				c.SendRaw([]byte("frame"))
+			} else {
+				c.Close()
+			}
+			waitStopped(t, c)
+			r.Remove(c)
+
+			if c.Reaped() != tt.wantReap {
+				t.Errorf("Reaped = %v, want %v", c.Reaped(), tt.wantReap)
+			}
+			if s := r.Stats(); s.Reaped != tt.wantTotal || s.Connections != 0 {
+				t.Errorf("Stats = %+v, want reaped %d", s, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// pong を返さないクライアントは PongWait で回収する
+func TestReapWithoutPong(t *testing.T) {
+	r := NewRegistry()
+	conns := make(chan *Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		c := r.Add(1, ws, Options{PingInterval: 20 * time.Millisecond, PongWait: 100 * time.Millisecond, WriteWait: time.Second, MaxMessageSize: 1024})
+		conns <- c
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+		r.Remove(c)
+	}))
+	defer ts.Close()
+
+	// 読み込まないクライアントは ping に pong を返さない
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := <-conns
+	waitStopped(t, c)
+	if !c.Reaped() {
+		t.Error("pong のない接続が回収されていません")
+	}
+}
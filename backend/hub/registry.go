@@ -0,0 +1,234 @@
+// Package hub はWebSocket・SSE接続の管理（ユーザーごとの複数端末・タブ）を行う
+package hub
+
+import (
+	"backend/bus"
+	"backend/protocol"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Registry はユーザーIDごとに接続の集合を持つ。
+// 同じユーザーが複数の端末・タブから接続しても互いに上書きしない。
+//
+// あわせて接続中のユーザーがどのルームに参加しているか（ルームの購読）を持つ。購読はストアのメンバーの
+// 写しで、記録しない「入力中」をこのレプリカの接続に配るときにだけ使う。ルーム宛てのイベントの宛先は
+// ストアのメンバー（handlers の publishToRoom）が正で、購読は参照しない。
+// ルームからメンバーを外す操作はまだないので、購読はユーザーの最後の接続が切れたときにだけ外れる。
+type Registry struct {
+	mu        sync.RWMutex
+	nextID    uint64
+	conns     map[int]map[uint64]*Conn
+	rooms     map[int]map[int]struct{} // roomID → 接続中のメンバーのユーザーID
+	userRooms map[int]map[int]struct{} // userID → 参加ルーム（切断時に rooms から外すため）
+	reaped    atomic.Uint64            // 応答なしで回収した接続の累計
+}
+
+// Stats は接続状況のスナップショット
+type Stats struct {
+	Users       int    `json:"users"`       // 接続中のユーザー数
+	Connections int    `json:"connections"` // 接続数（端末・タブごと）
+	Reaped      uint64 `json:"reaped"`      // 起動以降に応答なしで回収した接続数
+}
+
+// NewRegistry は空のレジストリを作る
+func NewRegistry() *Registry {
+	return &Registry{
+		conns:     make(map[int]map[uint64]*Conn),
+		rooms:     make(map[int]map[int]struct{}),
+		userRooms: make(map[int]map[int]struct{}),
+	}
+}
+
+// Add は WebSocket の接続を登録し、ID を割り当てた Conn を返す（writer ゴルーチンもここで起動する）
+func (r *Registry) Add(userID int, ws *websocket.Conn, opts Options) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	return r.addLocked(newWSConn(r.nextID, userID, ws, opts))
+}
+
+// AddSSE は Server-Sent Events の接続を登録する。ヘッダーは書き込み済みであること。
+// 受信はしないので、ping の応答ではなく書き込みの失敗で切れた接続を見つける。
+// 呼び出し側は Stopped を待ってからハンドラーを抜けること。
+func (r *Registry) AddSSE(userID int, w http.ResponseWriter, opts Options) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	c := newConn(r.nextID, userID, sseTransport{w: w, rc: http.NewResponseController(w)}, opts)
+	c.passive = true
+	go c.writePump()
+	return r.addLocked(c)
+}
+
+func (r *Registry) addLocked(c *Conn) *Conn {
+	if r.conns[c.UserID] == nil {
+		r.conns[c.UserID] = make(map[uint64]*Conn)
+	}
+	r.conns[c.UserID][c.ID] = c
+	return c
+}
+
+// Remove は c だけを登録解除する（同じユーザーの他の接続は残る）。
+// ユーザーの最後の接続なら、ルームの購読も外す。
+func (r *Registry) Remove(c *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userConns := r.conns[c.UserID]
+	delete(userConns, c.ID)
+	if len(userConns) == 0 {
+		delete(r.conns, c.UserID)
+		for roomID := range r.userRooms[c.UserID] {
+			r.leaveLocked(roomID, c.UserID)
+		}
+		delete(r.userRooms, c.UserID)
+	}
+	if c.Reaped() {
+		r.reaped.Add(1)
+	}
+}
+
+// Stats は現在の接続数と回収した接続数を返す
+func (r *Registry) Stats() Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := Stats{Users: len(r.conns), Reaped: r.reaped.Load()}
+	for _, userConns := range r.conns {
+		s.Connections += len(userConns)
+	}
+	return s
+}
+
+// Conns は userID の接続一覧を返す
+func (r *Registry) Conns(userID int) []*Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]*Conn, 0, len(r.conns[userID]))
+	for _, c := range r.conns[userID] {
+		out = append(out, c)
+	}
+	return out
+}
+
+// JoinRooms は userID を roomIDs の購読者にする。接続していないユーザーは何もしない
+// （次に接続したときに参加ルームを読み込み直すため）。
+func (r *Registry) JoinRooms(userID int, roomIDs ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.conns[userID]) == 0 {
+		return
+	}
+	if r.userRooms[userID] == nil {
+		r.userRooms[userID] = make(map[int]struct{})
+	}
+	for _, roomID := range roomIDs {
+		if r.rooms[roomID] == nil {
+			r.rooms[roomID] = make(map[int]struct{})
+		}
+		r.rooms[roomID][userID] = struct{}{}
+		r.userRooms[userID][roomID] = struct{}{}
+	}
+}
+
+func (r *Registry) leaveLocked(roomID, userID int) {
+	members := r.rooms[roomID]
+	delete(members, userID)
+	if len(members) == 0 {
+		delete(r.rooms, roomID)
+	}
+}
+
+// RoomConns は roomID に参加している接続中メンバーの全接続を返す
+func (r *Registry) RoomConns(roomID int) []*Conn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []*Conn
+	for userID := range r.rooms[roomID] {
+		for _, c := range r.conns[userID] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// IsOnline は userID が1本以上接続しているかどうか
+func (r *Registry) IsOnline(userID int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.conns[userID]) > 0
+}
+
+// SendToUser は userID の全接続の送信キューに ev を積み、積めた接続数を返す。
+// I/O は各接続の writer が行うので、遅い接続があってもここでは待たない。
+func (r *Registry) SendToUser(userID int, ev protocol.ServerEvent) int {
+	return send(r.Conns(userID), ev)
+}
+
+// SendFrame は userID の全接続に seq 付きのフレームを積み、積めた接続数を返す。
+// 保留中（再送前）の接続には Release のあとで届く。
+func (r *Registry) SendFrame(userID int, seq int64, frame []byte) int {
+	sent := 0
+	for _, c := range r.Conns(userID) {
+		if c.enqueueSeq(seq, frame) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Deliver はバスから受け取ったイベントを、このレプリカに接続しているユーザーに届ける
+func (r *Registry) Deliver(e bus.Event) {
+	switch e.Kind {
+	case bus.KindUsers:
+		for userID, seq := range e.Seqs {
+			r.SendFrame(userID, seq, protocol.Frame(e.Type, seq, e.Body))
+		}
+	case bus.KindJoin:
+		for _, userID := range e.UserIDs {
+			r.JoinRooms(userID, e.RoomID)
+		}
+	default:
+		log.Printf("⚠️ 未知のバスイベントを無視: kind=%q", e.Kind)
+	}
+}
+
+// ResyncAll は全接続に resync を送る（バスの配送が途切れ、取りこぼしがありうるとき）
+func (r *Registry) ResyncAll() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, userConns := range r.conns {
+		for _, c := range userConns {
+			c.enqueue(resyncMessage)
+		}
+	}
+}
+
+// send は ev を1回だけフレームにして各接続のキューに積む
+func send(conns []*Conn, ev protocol.ServerEvent) int {
+	if len(conns) == 0 {
+		return 0
+	}
+	b, err := protocol.Encode(ev)
+	if err != nil {
+		log.Printf("❌ WebSocket送信データのJSON変換失敗: type=%s err=%v", ev.EventType(), err)
+		return 0
+	}
+	sent := 0
+	for _, c := range conns {
+		if c.enqueue(b) {
+			sent++
+		}
+	}
+	return sent
+}
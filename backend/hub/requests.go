@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"backend/protocol"
+	"sync"
+	"time"
+)
+
+// RequestLog は最近受け付けたクライアントの request_id をユーザーごとに覚え、再送を見分ける。
+// 同じユーザーなら別の接続（再接続後）からの再送も重複として扱う。プロセス内だけの記録なので、
+// 再起動をまたいだ重複はメッセージの client_request_id（DBの一意制約）で防ぐ。
+type RequestLog struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[requestKey]*requestEntry
+	order   []requestKey // 受け付けた順（期限切れの掃除用）
+}
+
+type requestKey struct {
+	userID    int
+	requestID string
+}
+
+type requestEntry struct {
+	at   time.Time
+	ack  protocol.AckEvent
+	done bool // false なら処理中
+}
+
+// NewRequestLog は ttl の間 request_id を覚える RequestLog を作る
+func NewRequestLog(ttl time.Duration) *RequestLog {
+	return &RequestLog{ttl: ttl, entries: make(map[requestKey]*requestEntry)}
+}
+
+// Begin は request_id の処理を始める。初めてなら dup=false。
+// 再送なら dup=true で、処理済みなら前回の ack を、処理中なら nil を返す。
+func (l *RequestLog) Begin(userID int, requestID string) (prev *protocol.AckEvent, dup bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+
+	key := requestKey{userID, requestID}
+	if e, ok := l.entries[key]; ok {
+		if !e.done {
+			return nil, true
+		}
+		ack := e.ack
+		return &ack, true
+	}
+	l.entries[key] = &requestEntry{at: now}
+	l.order = append(l.order, key)
+	return nil, false
+}
+
+// Finish は処理結果の ack を記録する（以降の再送にはこの ack を返す）
+func (l *RequestLog) Finish(userID int, requestID string, ack protocol.AckEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[requestKey{userID, requestID}]; ok {
+		e.ack = ack
+		e.done = true
+	}
+}
+
+// Forget は失敗した request_id を忘れる（再送で処理し直せるようにする）
+func (l *RequestLog) Forget(userID int, requestID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, requestKey{userID, requestID})
+}
+
+// pruneLocked は期限切れの記録を古い順に消す
+func (l *RequestLog) pruneLocked(now time.Time) {
+	for len(l.order) > 0 {
+		key := l.order[0]
+		if e, ok := l.entries[key]; ok {
+			if now.Sub(e.at) < l.ttl {
+				return
+			}
+			delete(l.entries, key)
+		}
+		l.order = l.order[1:]
+	}
+}
@@ -0,0 +1,79 @@
+package hub
+
+import (
+	"backend/protocol"
+	"testing"
+	"time"
+)
+
+func TestRequestLog(t *testing.T) {
+	type step struct {
+		op        string // begin / finish / forget
+		userID    int
+		requestID string
+		wantDup   bool
+		wantAckID int // begin のとき、前回の ack の id（0 なら ack なし）
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"初めての request_id", []step{
+			{op: "begin", userID: 1, requestID: "a"},
+		}},
+		{"処理中の再送", []step{
+			{op: "begin", userID: 1, requestID: "a"},
+			{op: "begin", userID: 1, requestID: "a", wantDup: true},
+		}},
+		{"処理済みの再送には前回の ack", []step{
+			{op: "begin", userID: 1, requestID: "a"},
+			{op: "finish", userID: 1, requestID: "a"},
+			{op: "begin", userID: 1, requestID: "a", wantDup: true, wantAckID: 42},
+		}},
+		{"別のユーザーの同じ request_id", []step{
+			{op: "begin", userID: 1, requestID: "a"},
+			{op: "begin", userID: 2, requestID: "a"},
+		}},
+		{"失敗して忘れたら処理し直せる", []step{
+			{op: "begin", userID: 1, requestID: "a"},
+			{op: "forget", userID: 1, requestID: "a"},
+			{op: "begin", userID: 1, requestID: "a"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRequestLog(time.Minute)
+			for i, s := range tt.steps {
+				switch s.op {
+				case "begin":
+					prev, dup := l.Begin(s.userID, s.requestID)
+					gotAckID := 0
+					if prev != nil {
+						gotAckID = prev.ID
+					}
+					if dup != s.wantDup || gotAckID != s.wantAckID {
+						t.Errorf("%d: Begin = (ack %d, dup %v), want (ack %d, dup %v)", i, gotAckID, dup, s.wantAckID, s.wantDup)
+					}
+				case "finish":
+					l.Finish(s.userID, s.requestID, protocol.AckEvent{RequestID: s.requestID, RequestType: "message", ID: 42})
+				case "forget":
+					l.Forget(s.userID, s.requestID)
+				}
+			}
+		})
+	}
+}
+
+// ttl を過ぎた request_id は忘れる
+func TestRequestLogExpiry(t *testing.T) {
+	l := NewRequestLog(20 * time.Millisecond)
+	l.Begin(1, "a")
+	l.Finish(1, "a", protocol.AckEvent{ID: 1})
+	time.Sleep(40 * time.Millisecond)
+	if _, dup := l.Begin(1, "a"); dup {
+		t.Error("期限切れの request_id が重複扱いになりました")
+	}
+	if len(l.entries) != 1 {
+		t.Errorf("記録 = %d件, want 1", len(l.entries))
+	}
+}
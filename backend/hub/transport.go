@@ -0,0 +1,154 @@
+package hub
+
+import (
+	"backend/protocol"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// transport は Conn の書き込み先（WebSocket / JSON-RPC・MessagePack の WebSocket / SSE）。writer ゴルーチンだけが呼ぶ。
+type transport interface {
+	write(frame []byte, deadline time.Time) error
+	writeBatch(frames [][]byte, deadline time.Time) error // 複数のフレームを1回で書き出す（束ねられなければ順に書く）
+	ping(deadline time.Time) error
+	goodbye(deadline time.Time) // サーバーから閉じることを相手に知らせる
+	close()
+}
+
+type wsTransport struct {
+	ws *websocket.Conn
+}
+
+func (t wsTransport) write(frame []byte, deadline time.Time) error {
+	t.ws.SetWriteDeadline(deadline)
+	return t.ws.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (t wsTransport) writeBatch(frames [][]byte, deadline time.Time) error {
+	return t.write(protocol.BatchFrame(frames), deadline)
+}
+
+func (t wsTransport) ping(deadline time.Time) error {
+	return t.ws.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+func (t wsTransport) goodbye(deadline time.Time) {
+	t.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+}
+
+func (t wsTransport) close() {
+	t.ws.Close()
+}
+
+// jsonrpcTransport は JSON-RPC のサブプロトコルの WebSocket。
+// イベントのフレームは通知に包み、JSON-RPC のレスポンスはそのまま書き出す。
+type jsonrpcTransport struct {
+	wsTransport
+}
+
+func (t jsonrpcTransport) write(frame []byte, deadline time.Time) error {
+	if !protocol.IsJSONRPC(frame) {
+		var err error
+		if frame, err = protocol.RPCNotification(frame); err != nil {
+			return err
+		}
+	}
+	return t.wsTransport.write(frame, deadline)
+}
+
+// writeBatch は束ねずに1つずつ通知として書く（JSON-RPC には束ねたイベントの形がない）
+func (t jsonrpcTransport) writeBatch(frames [][]byte, deadline time.Time) error {
+	for _, f := range frames {
+		if err := t.write(f, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackTransport は MessagePack のサブプロトコルの WebSocket。フレームをバイナリに変換して書き出す。
+type msgpackTransport struct {
+	wsTransport
+}
+
+func (t msgpackTransport) write(frame []byte, deadline time.Time) error {
+	b, err := protocol.FrameToMsgpack(frame)
+	if err != nil {
+		return err
+	}
+	t.ws.SetWriteDeadline(deadline)
+	return t.ws.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (t msgpackTransport) writeBatch(frames [][]byte, deadline time.Time) error {
+	packed := make([][]byte, len(frames))
+	for i, f := range frames {
+		var err error
+		if packed[i], err = protocol.FrameToMsgpack(f); err != nil {
+			return err
+		}
+	}
+	b, err := protocol.MsgpackBatch(packed)
+	if err != nil {
+		return err
+	}
+	t.ws.SetWriteDeadline(deadline)
+	return t.ws.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// sseTransport は text/event-stream で書き出す。seq 付きのイベントは id に seq を入れるので、
+// ブラウザ（EventSource）は再接続時に Last-Event-ID で続きから受け取れる。
+type sseTransport struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (t sseTransport) write(frame []byte, deadline time.Time) error {
+	t.rc.SetWriteDeadline(deadline)
+	if err := t.event(frame); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// writeBatch は各イベントを別々のイベントとして書き、まとめてフラッシュする（id を付けるため束ねない）
+func (t sseTransport) writeBatch(frames [][]byte, deadline time.Time) error {
+	t.rc.SetWriteDeadline(deadline)
+	for _, f := range frames {
+		if err := t.event(f); err != nil {
+			return err
+		}
+	}
+	return t.rc.Flush()
+}
+
+func (t sseTransport) event(frame []byte) error {
+	var h protocol.Header
+	if err := json.Unmarshal(frame, &h); err == nil && h.Seq > 0 {
+		if _, err := fmt.Fprintf(t.w, "id: %d\n", h.Seq); err != nil {
+			return err
+		}
+	}
+	// フレームは1行の JSON なので、そのまま data 行にできる
+	_, err := fmt.Fprintf(t.w, "data: %s\n\n", frame)
+	return err
+}
+
+// ping はコメント行を送る（EventSource は無視する）。プロキシに切られないようにするのと、切れた接続を見つけるため。
+func (t sseTransport) ping(deadline time.Time) error {
+	t.rc.SetWriteDeadline(deadline)
+	if _, err := io.WriteString(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t sseTransport) goodbye(time.Time) {}
+
+// close はレスポンスを閉じない（ハンドラーが抜けると閉じる）
+func (t sseTransport) close() {}
@@ -1,66 +1,72 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux" // gorilla/muxパッケージをインポート
 	"github.com/rs/cors"     // CORS設定を管理するrs/corsパッケージをインポート
 
+	"backend/bus"      // WebSocketイベントをレプリカ間で配るバス
+	"backend/config"   // 環境変数・設定ファイル・フラグから設定を読み込むパッケージ
 	"backend/db"       // データベースを管理するパッケージ
 	"backend/handlers" // HTTPリクエストのハンドラー関数を定義するパッケージ
+	"backend/store"    // 永続化層（PostgreSQL / メモリ）
 )
 
 func main() {
-	db.Initialize()
-	r := mux.NewRouter()
-
-	// 🔐 認証
-	r.HandleFunc("/signup", handlers.SignUp).Methods("POST")
-	r.HandleFunc("/login", handlers.Login).Methods("POST")
-	r.HandleFunc("/logout", handlers.Logout).Methods("POST")
-	r.HandleFunc("/me", handlers.GetMe).Methods("GET")
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(2)
+	}
+	config.Set(cfg)
+	log.Printf("⚙️ 設定読み込み完了: env=%s", cfg.Env)
 
-	// 👤 ユーザー一覧
-	r.HandleFunc("/users", handlers.GetUsers).Methods("GET")
-	r.HandleFunc("/room/members", handlers.GetRoomMembers).Methods("GET")
+	// go run . [flags] migrate up|down|status
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(args[1:])
+		return
+	}
 
-	// 💬 メッセージ・ルーム関連
-	r.HandleFunc("/messages", handlers.SendMessage).Methods("POST")
-	r.HandleFunc("/messages", handlers.GetMessages).Methods("GET")
-	r.HandleFunc("/room", handlers.GetOrCreateRoom).Methods("GET")             // 1対1チャット
-	r.HandleFunc("/rooms", handlers.CreateGroupRoom).Methods("POST")           // グループチャット
-	r.HandleFunc("/create-chat-room", handlers.CreateChatRoom).Methods("POST") // 旧名APIなら整理も検討
-	r.HandleFunc("/my-rooms", handlers.GetMyRooms).Methods("GET")
-	r.HandleFunc("/group_rooms", handlers.GetGroupRooms).Methods("GET")
-	r.HandleFunc("/messages/read", handlers.MarkAllAsRead).Methods("POST")
-	r.HandleFunc("/upload", handlers.UploadImage).Methods("POST")
-	r.HandleFunc("/reactions", handlers.AddReaction).Methods("POST")
-	r.HandleFunc("/messages/edit", handlers.EditMessage).Methods("PUT")
-	r.HandleFunc("/room/unread_count", handlers.GetUnreadCount)
-	r.HandleFunc("/unread_counts", handlers.GetUnreadCount).Methods("GET")
-	r.HandleFunc("/messages/hard_delete", handlers.HardDeleteMessage).Methods("DELETE")
+	var st store.Store
+	if cfg.Store == config.StoreMemory {
+		log.Println("⚠️ メモリストアで起動します（データは保存されません）")
+		st = store.NewMemory()
+	} else {
+		db.Initialize()
+		st = store.NewPostgres(db.Conn)
+	}
 
-	// main.go または router の設定箇所
-	r.HandleFunc("/messages/delete", handlers.DeleteMessage).Methods("DELETE")
+	// WebSocketイベントは既定では同じプロセス内だけで配る。
+	// 複数台で動かすときは、LISTEN/NOTIFY で全台に配る
+	var b bus.Bus = bus.NewLocal()
+	if cfg.Bus == config.BusPostgres {
+		pb, err := bus.NewPostgres(db.Conn, cfg.DB.DSN())
+		if err != nil {
+			log.Fatalf("❌ イベントバスの起動に失敗: %v", err)
+		}
+		defer pb.Close()
+		b = pb
+		log.Println("✅ イベントバス: PostgreSQL LISTEN/NOTIFY")
+	}
 
-	// 静的ファイル配信（画像URLアクセス用）
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./uploads"))))
+	srv := handlers.NewServer(st, b)
+	srv.Start()
 
-	// 🌐 WebSocket
-	r.HandleFunc("/ws", handlers.HandleWebSocket)
-
-	// 既読処理エンドポイント追加
-	r.HandleFunc("/api/mark_as_read", handlers.MarkMessageAsRead).Methods("POST")
+	r := mux.NewRouter()
+	srv.RegisterRoutes(r)
 
 	// CORS設定
 	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3001"},
+		AllowedOrigins:   cfg.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	}).Handler(r)
 
-	log.Println("✅ Server started at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	log.Printf("✅ Server started at %s (%s)", cfg.Addr, cfg.PublicURL)
+	log.Fatal(http.ListenAndServe(cfg.Addr, handler))
 }
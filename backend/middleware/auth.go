@@ -4,10 +4,15 @@ import (
 	"errors"
 	"net/http"
 
+	"backend/config"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var SecretKey = []byte("your-secret-key") // 本番環境では .env や設定から取得
+// SecretKey はJWTの署名キーを設定から取得する
+func SecretKey() []byte {
+	return []byte(config.Get().JWTSecret)
+}
 
 // ✅ Cookieからトークンを検証して user_id を返す関数
 func ValidateToken(r *http.Request) (int, error) {
@@ -22,7 +27,7 @@ func ValidateToken(r *http.Request) (int, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("署名方法が無効です")
 		}
-		return SecretKey, nil
+		return SecretKey(), nil
 	})
 
 	if err != nil || !token.Valid {
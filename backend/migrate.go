@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"backend/db"
+)
+
+// runMigrate は `migrate up|down [N]|status|force VERSION` サブコマンドを処理する
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up | down [N] | status | force VERSION")
+		os.Exit(2)
+	}
+
+	db.Connect()
+	defer db.Conn.Close()
+
+	switch args[0] {
+	case "up":
+		n, err := db.MigrateUp(db.Conn)
+		if err != nil {
+			log.Fatalf("❌ migrate up 失敗: %v", err)
+		}
+		log.Printf("✅ %d件のマイグレーションを適用しました", n)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("❌ 戻す件数が不正です: %s", args[1])
+			}
+		}
+		n, err := db.MigrateDown(db.Conn, steps)
+		if err != nil {
+			log.Fatalf("❌ migrate down 失敗: %v", err)
+		}
+		log.Printf("✅ %d件のマイグレーションを取り消しました", n)
+
+	case "status":
+		statuses, err := db.GetMigrationStatus(db.Conn)
+		if err != nil {
+			log.Fatalf("❌ migrate status 失敗: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Dirty {
+				state = "DIRTY"
+			} else if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+
+	case "force":
+		if len(args) < 2 {
+			log.Fatal("❌ force には VERSION が必要です")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("❌ VERSION が不正です: %s", args[1])
+		}
+		if err := db.MigrateForce(db.Conn, version); err != nil {
+			log.Fatalf("❌ migrate force 失敗: %v", err)
+		}
+		log.Printf("✅ version %d を適用済みとして記録しました", version)
+
+	default:
+		log.Fatalf("❌ 未対応のサブコマンド: %s", args[0])
+	}
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ページングの既定値と上限
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// Cursor はメッセージ一覧の位置を表す。
+// created_at が同じメッセージがあっても順序が一意になるよう id を併用する。
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// CursorOf はメッセージの位置を指すカーソルを返す
+func CursorOf(m Message) Cursor {
+	return Cursor{CreatedAt: m.Timestamp, ID: m.ID}
+}
+
+// Encode はクライアントに渡す不透明な文字列にする
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d_%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Before は c が other より前（古い）かどうか
+func (c Cursor) Before(other Cursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+	return c.ID < other.ID
+}
+
+// DecodeCursor は Encode した文字列を元に戻す
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errors.New("cursor の形式が正しくありません")
+	}
+	parts := strings.SplitN(string(raw), "_", 2)
+	if len(parts) != 2 {
+		return Cursor{}, errors.New("cursor の形式が正しくありません")
+	}
+	nanos, err1 := strconv.ParseInt(parts[0], 10, 64)
+	id, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return Cursor{}, errors.New("cursor の形式が正しくありません")
+	}
+	return Cursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// PageQuery はメッセージ一覧の取得範囲。
+// Before も After もなければ最新 Limit 件、Before なら それより古い Limit 件、After なら それより新しい Limit 件。
+type PageQuery struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
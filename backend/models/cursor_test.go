@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{CreatedAt: time.Date(2026, 4, 1, 9, 30, 0, 123456789, time.UTC), ID: 42},
+		{CreatedAt: time.Unix(0, 0).UTC(), ID: 1},
+	}
+	for _, c := range tests {
+		got, err := DecodeCursor(c.Encode())
+		if err != nil {
+			t.Fatalf("DecodeCursor(%q): %v", c.Encode(), err)
+		}
+		if !got.CreatedAt.Equal(c.CreatedAt) || got.ID != c.ID {
+			t.Errorf("DecodeCursor(Encode(%v)) = %v", c, got)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"!!!",
+		"MTIz",     // "123"（区切りがない）
+		"YWJjXzQy", // "abc_42"
+		"MTIzX3h5", // "123_xy"
+	} {
+		if _, err := DecodeCursor(s); err == nil {
+			t.Errorf("DecodeCursor(%q) がエラーになりません", s)
+		}
+	}
+}
+
+// created_at が同じなら id で順序を決める
+func TestCursorBefore(t *testing.T) {
+	at := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		a, b Cursor
+		want bool
+	}{
+		{Cursor{at, 1}, Cursor{at.Add(time.Second), 0}, true},
+		{Cursor{at.Add(time.Second), 0}, Cursor{at, 1}, false},
+		{Cursor{at, 1}, Cursor{at, 2}, true},
+		{Cursor{at, 2}, Cursor{at, 1}, false},
+		{Cursor{at, 1}, Cursor{at, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Before(tt.b); got != tt.want {
+			t.Errorf("%v.Before(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserEvent はユーザーに配信したWebSocketイベントの記録。Seq はユーザーごとに 1 から単調増加する。
+// Payload はエンベロープ（v / type / seq）を除いたイベント本体のJSON。
+type UserEvent struct {
+	UserID    int             `json:"user_id"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
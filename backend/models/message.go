@@ -8,5 +8,43 @@ type Message struct {
 	SenderID  int        `json:"sender_id"`
 	Content   string     `json:"content"`
 	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"edited_at"` // 未編集なら null
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *int       `json:"deleted_by,omitempty"`
 	ReadAt    *time.Time `json:"read_at,omitempty"`
+
+	// スレッド: 返信なら ParentMessageID が親（スレッドの先頭）。親には返信数と最終返信時刻が入る。
+	ParentMessageID *int       `json:"parent_message_id,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	LastReplyAt     *time.Time `json:"last_reply_at,omitempty"`
+
+	// 引用返信: 引用元のメッセージID（スレッドとは別）
+	ReplyToMessageID *int `json:"reply_to_message_id,omitempty"`
+
+	// クライアントが付けた送信の request_id（再送の重複排除用、空なら重複チェックしない）
+	ClientRequestID string `json:"-"`
+}
+
+// IsDeleted は論理削除済みかどうか
+func (m Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// MessageRevision は編集前の本文。EditedAt はこの本文が置き換えられた時刻。
+type MessageRevision struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  int       `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+// QuotePreview は引用元メッセージの簡易表示。
+// 取得のたびに引用元から組み立てるので、編集・削除が反映される（削除済みなら deleted=true で content は空）。
+type QuotePreview struct {
+	ID       int        `json:"id"`
+	SenderID int        `json:"sender_id"`
+	Content  string     `json:"content"`
+	EditedAt *time.Time `json:"edited_at"`
+	Deleted  bool       `json:"deleted"`
 }
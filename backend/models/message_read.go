@@ -1,7 +1,6 @@
 package models
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"time"
@@ -9,112 +8,73 @@ import (
 
 // 既読・未読管理のための構造体
 type MessageRead struct {
-	MessageID int       `json:"message_id"`
-	UserID    int       `json:"user_id"`
-	Reaction  string    `json:"reaction"`
-	ReadAt    time.Time `json:"read_at"`
+	MessageID int        `json:"message_id"`
+	UserID    int        `json:"user_id"`
+	Reaction  *string    `json:"reaction"`
+	ReadAt    *time.Time `json:"read_at"`
 }
 
 type ReadUpdate struct {
-	ID     int
-	ReadAt time.Time
+	ID       int
+	SenderID int
+	ReadAt   time.Time
+}
+
+// ReadReceipt は既読通知（送信者へ送る "read" イベント）に必要な情報
+type ReadReceipt struct {
+	MessageID int
+	SenderID  int
+	ReadAt    time.Time
+}
+
+// ReadStore は既読処理で使う永続化操作（store.Store が満たす）
+type ReadStore interface {
+	GetRoomMembers(roomID int) ([]User, error)
+	GetSenderIDByMessageID(messageID int) (int, error)
+	InsertUnreadReads(messageID int, userIDs []int) error
+	MarkMessageRead(messageID, userID int) (*ReadReceipt, error)
+	MarkRoomRead(roomID, userID int) ([]ReadUpdate, error)
 }
 
 // メッセージ送信時に全メンバーに未読データを追加
-func InsertMessageReads(db *sql.DB, messageID int, roomID int) error {
-	members, err := GetRoomMembers(db, roomID)
+func InsertMessageReads(s ReadStore, messageID int, roomID int) error {
+	members, err := s.GetRoomMembers(roomID)
 	if err != nil {
 		return fmt.Errorf("error retrieving room members: %v", err)
 	}
 
-	senderID, err := GetSenderIDByMessageID(db, messageID)
+	senderID, err := s.GetSenderIDByMessageID(messageID)
 	if err != nil {
 		return fmt.Errorf("failed to get sender ID: %v", err)
 	}
 	log.Printf("✅ senderID=%d", senderID)
 
+	var unreadUserIDs []int
 	for _, member := range members {
-		log.Printf("🔍 比較中: member.ID=%d vs senderID=%d", member.ID, senderID)
 		if member.ID == senderID {
 			continue // 自分自身は未読にしない
 		}
-		_, err := db.Exec(`
-			INSERT INTO message_reads (message_id, user_id, read_at)
-			VALUES ($1, $2, NULL)
-			ON CONFLICT (message_id, user_id) DO NOTHING
-		`, messageID, member.ID)
-		if err != nil {
-			return fmt.Errorf("error inserting unread message: %v", err)
-		}
-		log.Printf("✅ 未読挿入: message_id=%d, user_id=%d", messageID, member.ID)
+		unreadUserIDs = append(unreadUserIDs, member.ID)
 	}
+
+	if err := s.InsertUnreadReads(messageID, unreadUserIDs); err != nil {
+		return fmt.Errorf("error inserting unread message: %v", err)
+	}
+	log.Printf("✅ 未読挿入: message_id=%d, user_ids=%v", messageID, unreadUserIDs)
 	return nil
 }
 
 // 単一メッセージの既読処理
-func MarkMessageAsRead(db *sql.DB, messageID int, userID int) error {
-	_, err := db.Exec(
-		"UPDATE message_reads SET read_at = $1 WHERE message_id = $2 AND user_id = $3",
-		time.Now(), messageID, userID,
-	)
+func MarkMessageAsRead(s ReadStore, messageID int, userID int) error {
+	_, err := s.MarkMessageRead(messageID, userID)
 	return err
 }
 
 // 全メッセージを既読にし、更新されたmessage_idとread_atを返す
-func MarkAllMessagesAsRead(db *sql.DB, roomID int, userID int) ([]ReadUpdate, error) {
-	rows, err := db.Query(`
-		UPDATE message_reads mr
-		SET read_at = NOW()
-		FROM messages m
-		WHERE mr.message_id = m.id
-		  AND m.room_id = $1
-		  AND m.sender_id != $2
-		  AND mr.user_id = $2
-		  AND mr.read_at IS NULL
-		RETURNING mr.message_id, mr.read_at
-	`, roomID, userID)
+func MarkAllMessagesAsRead(s ReadStore, roomID int, userID int) ([]ReadUpdate, error) {
+	updates, err := s.MarkRoomRead(roomID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error marking messages as read: %v", err)
 	}
-	defer rows.Close()
-
-	var updates []ReadUpdate
-	for rows.Next() {
-		var r ReadUpdate
-		if err := rows.Scan(&r.ID, &r.ReadAt); err != nil {
-			return nil, err
-		}
-		updates = append(updates, r)
-	}
 	return updates, nil
 }
-
-// ルームメンバーを取得
-func GetRoomMembers(db *sql.DB, roomID int) ([]User, error) {
-	var members []User
-	query := "SELECT user_id FROM room_members WHERE room_id = $1"
-	rows, err := db.Query(query, roomID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var member User
-		if err := rows.Scan(&member.ID); err != nil {
-			return nil, err
-		}
-		members = append(members, member)
-	}
-	return members, nil
-}
-
-// メッセージIDから送信者IDを取得
-func GetSenderIDByMessageID(db *sql.DB, messageID int) (int, error) {
-	var senderID int
-	err := db.QueryRow("SELECT sender_id FROM messages WHERE id = $1", messageID).Scan(&senderID)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get sender_id: %v", err)
-	}
-	return senderID, nil
-}
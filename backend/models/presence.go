@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// プレゼンスの状態
+const (
+	PresenceOnline  = "online"
+	PresenceIdle    = "idle" // 接続しているが、しばらく操作がない
+	PresenceOffline = "offline"
+)
+
+// Presence はユーザーのオンライン状態と最後に操作した時刻。
+// LastSeenAt は本人が隠している（HideLastSeen）と、本人以外には nil で返す。
+type Presence struct {
+	State        string     `json:"state"`
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	HideLastSeen bool       `json:"-"`
+}
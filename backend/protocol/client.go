@@ -0,0 +1,109 @@
+package protocol
+
+import "errors"
+
+func init() {
+	register(func() ClientEvent { return &MessageRequest{} })
+	register(func() ClientEvent { return &ReadRequest{} })
+	register(func() ClientEvent { return &ReactionRequest{} })
+	register(func() ClientEvent { return &TypingStartRequest{} })
+	register(func() ClientEvent { return &TypingStopRequest{} })
+	register(func() ClientEvent { return &PresenceRequest{} })
+}
+
+// MessageRequest はメッセージの送信 (type: "message")
+type MessageRequest struct {
+	RoomID           int    `json:"room_id"`
+	SenderID         int    `json:"sender_id,omitempty"` // 省略可。送信者は接続の認証で決まり、違う値なら拒否する
+	Content          string `json:"content"`
+	ParentMessageID  *int   `json:"parent_message_id,omitempty"`   // スレッドの返信先
+	ReplyToMessageID *int   `json:"reply_to_message_id,omitempty"` // 引用元
+}
+
+func (*MessageRequest) EventType() string { return "message" }
+
+func (r *MessageRequest) Validate() error {
+	if r.RoomID <= 0 {
+		return errors.New("room_id が必要です")
+	}
+	if r.Content == "" {
+		return errors.New("content が空です")
+	}
+	return nil
+}
+
+// ReadRequest はメッセージを既読にする (type: "read")。既読の時刻はサーバーが決める。
+type ReadRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+func (*ReadRequest) EventType() string { return "read" }
+
+func (r *ReadRequest) Validate() error {
+	if r.MessageID <= 0 {
+		return errors.New("message_id が必要です")
+	}
+	return nil
+}
+
+// ReactionRequest はリアクションの付け外し (type: "reaction")
+type ReactionRequest struct {
+	MessageID int    `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+func (*ReactionRequest) EventType() string { return "reaction" }
+
+func (r *ReactionRequest) Validate() error {
+	if r.MessageID <= 0 {
+		return errors.New("message_id が必要です")
+	}
+	if r.Emoji == "" {
+		return errors.New("emoji が空です")
+	}
+	return nil
+}
+
+// TypingStartRequest はルームで入力を始めた・続けている (type: "typing_start")。
+// 入力中は数秒ごとに送り直す。送り直さなければサーバー側で入力をやめたとみなす。
+type TypingStartRequest struct {
+	RoomID int `json:"room_id"`
+}
+
+func (*TypingStartRequest) EventType() string { return "typing_start" }
+
+func (r *TypingStartRequest) Validate() error {
+	if r.RoomID <= 0 {
+		return errors.New("room_id が必要です")
+	}
+	return nil
+}
+
+// TypingStopRequest はルームでの入力をやめた (type: "typing_stop")
+type TypingStopRequest struct {
+	RoomID int `json:"room_id"`
+}
+
+func (*TypingStopRequest) EventType() string { return "typing_stop" }
+
+func (r *TypingStopRequest) Validate() error {
+	if r.RoomID <= 0 {
+		return errors.New("room_id が必要です")
+	}
+	return nil
+}
+
+// PresenceRequest はクライアント側で分かる在席状態 (type: "presence")。
+// タブを隠したら "idle"、戻ったら "online" を送る。"idle" は次に何かフレームを送ると解除される。
+type PresenceRequest struct {
+	State string `json:"state"`
+}
+
+func (*PresenceRequest) EventType() string { return "presence" }
+
+func (r *PresenceRequest) Validate() error {
+	if r.State != "online" && r.State != "idle" {
+		return errors.New("state は online / idle のいずれかです")
+	}
+	return nil
+}
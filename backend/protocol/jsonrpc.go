@@ -0,0 +1,166 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JSONRPCSubprotocol は /ws で JSON-RPC 2.0 を話すときに Sec-WebSocket-Protocol で指定するサブプロトコル。
+//
+// 指定した接続では、クライアントはメソッド（sendMessage など）を呼び出し、同じ id のレスポンスを受け取る。
+// サーバーからのイベントは method がイベントの type、params がフレーム（v / type / seq を含む）の通知として届く。
+// 指定しなければ従来の {"type": ...} のフレームでやり取りする。
+const JSONRPCSubprotocol = "jsonrpc-2.0"
+
+// JSON-RPC のエラーコード。-32700〜-32600 は仕様で決まっているもの、-32000 番台はこのサーバーのもの。
+const (
+	RPCParseError     = -32700 // JSON として読めない
+	RPCInvalidRequest = -32600 // リクエストの形式が正しくない
+	RPCMethodNotFound = -32601 // 未対応のメソッド
+	RPCInvalidParams  = -32602 // params の形式・値が不正
+	RPCInternalError  = -32603 // サーバー側の失敗
+
+	RPCRejected  = -32000 // 形式は正しいが処理できなかった（空のメッセージ、存在しない返信先など）
+	RPCForbidden = -32003 // 権限がない（ルームのメンバーでない、本人のメッセージでないなど）
+	RPCNotFound  = -32004 // 対象が見つからない（削除済みを含む）
+)
+
+// RPCError は JSON-RPC のエラーオブジェクト
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("jsonrpc %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // なければ通知（レスポンスを返さない）
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// RPCHandler はメソッドを1回呼び出す。*RPCError 以外のエラーはサーバー側の失敗として中身を伏せる。
+type RPCHandler func(method string, params json.RawMessage) (result any, err error)
+
+// ServeJSONRPC は JSON-RPC 2.0 のフレーム（単体またはバッチ）を処理し、返すフレームを返す。
+// 通知だけのフレームなど、返すものがなければ nil。
+func ServeJSONRPC(frame []byte, call RPCHandler) []byte {
+	frame = bytes.TrimSpace(frame)
+	if len(frame) > 0 && frame[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(frame, &batch); err != nil {
+			return encodeRPC(rpcErrorResponse(rpcNullID, &RPCError{Code: RPCParseError, Message: "JSONとして読めません"}))
+		}
+		if len(batch) == 0 {
+			return encodeRPC(rpcErrorResponse(rpcNullID, &RPCError{Code: RPCInvalidRequest, Message: "空のバッチです"}))
+		}
+		var out []rpcResponse
+		for _, req := range batch {
+			if resp, ok := serveOne(req, call); ok {
+				out = append(out, resp)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return encodeRPC(out)
+	}
+
+	resp, ok := serveOne(frame, call)
+	if !ok {
+		return nil
+	}
+	return encodeRPC(resp)
+}
+
+// serveOne はリクエストを1つ処理する。通知なら ok=false（レスポンスを返さない）。
+func serveOne(raw []byte, call RPCHandler) (resp rpcResponse, ok bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if json.Valid(raw) {
+			return rpcErrorResponse(rpcNullID, &RPCError{Code: RPCInvalidRequest, Message: "リクエストの形式が正しくありません"}), true
+		}
+		return rpcErrorResponse(rpcNullID, &RPCError{Code: RPCParseError, Message: "JSONとして読めません"}), true
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" || !validRPCID(req.ID) {
+		id := req.ID
+		if !validRPCID(id) || id == nil {
+			id = rpcNullID
+		}
+		return rpcErrorResponse(id, &RPCError{Code: RPCInvalidRequest, Message: `jsonrpc は "2.0"、method は必須です`}), true
+	}
+
+	result, err := call(req.Method, req.Params)
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: RPCInternalError, Message: "処理に失敗しました"}
+		}
+		return rpcErrorResponse(req.ID, rpcErr), true
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return rpcErrorResponse(req.ID, &RPCError{Code: RPCInternalError, Message: "処理に失敗しました"}), true
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: b}, true
+}
+
+// validRPCID は id が文字列・数値・null（または省略）かどうか
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+func rpcErrorResponse(id json.RawMessage, err *RPCError) rpcResponse {
+	return rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+func encodeRPC(v any) []byte {
+	b, _ := json.Marshal(v) // RawMessage と文字列・数値だけなので失敗しない
+	return b
+}
+
+// IsJSONRPC は b が JSON-RPC のレスポンス（ServeJSONRPC が返したフレーム）かどうか。
+// イベントのフレームは必ず {"v": で始まるので、先頭だけで見分けられる。
+func IsJSONRPC(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(`{"jsonrpc"`)) || bytes.HasPrefix(b, []byte(`[`))
+}
+
+// RPCNotification はイベントのフレームを JSON-RPC の通知にする（method はイベントの type、params はフレーム）
+func RPCNotification(frame []byte) ([]byte, error) {
+	var h Header
+	if err := json.Unmarshal(frame, &h); err != nil {
+		return nil, err
+	}
+	method, _ := json.Marshal(h.Type)
+	out := make([]byte, 0, len(frame)+len(method)+40)
+	out = append(out, `{"jsonrpc":"2.0","method":`...)
+	out = append(out, method...)
+	out = append(out, `,"params":`...)
+	out = append(out, frame...)
+	return append(out, '}'), nil
+}
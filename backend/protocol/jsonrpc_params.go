@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// JSON-RPC のメソッドの params。react / startTyping / stopTyping / setPresence は、
+// 従来のフレームと同じ ReactionRequest / TypingStartRequest / TypingStopRequest / PresenceRequest を使う。
+
+// SendMessageParams は sendMessage の params。request_id を付けると、同じ request_id の再送は保存し直さない。
+type SendMessageParams struct {
+	RoomID           int    `json:"room_id"`
+	Content          string `json:"content"`
+	ParentMessageID  *int   `json:"parent_message_id,omitempty"`
+	ReplyToMessageID *int   `json:"reply_to_message_id,omitempty"`
+	RequestID        string `json:"request_id,omitempty"`
+}
+
+func (p *SendMessageParams) Validate() error {
+	if p.RoomID <= 0 {
+		return errors.New("room_id が必要です")
+	}
+	if len(p.RequestID) > maxRequestIDLength {
+		return errors.New("request_id が長すぎます")
+	}
+	return nil
+}
+
+// EditMessageParams は editMessage の params
+type EditMessageParams struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+func (p *EditMessageParams) Validate() error {
+	if p.MessageID <= 0 {
+		return errors.New("message_id が必要です")
+	}
+	return nil
+}
+
+// DeleteMessageParams は deleteMessage の params
+type DeleteMessageParams struct {
+	MessageID int `json:"message_id"`
+}
+
+func (p *DeleteMessageParams) Validate() error {
+	if p.MessageID <= 0 {
+		return errors.New("message_id が必要です")
+	}
+	return nil
+}
+
+// MarkReadParams は markRead の params。room_id ならルーム全体、message_id ならそのメッセージだけを既読にする。
+type MarkReadParams struct {
+	RoomID    int `json:"room_id,omitempty"`
+	MessageID int `json:"message_id,omitempty"`
+}
+
+func (p *MarkReadParams) Validate() error {
+	if (p.RoomID > 0) == (p.MessageID > 0) {
+		return errors.New("room_id か message_id のどちらか一方が必要です")
+	}
+	return nil
+}
+
+// GetMessagesParams は getMessages の params（GET /messages のクエリと同じ）
+type GetMessagesParams struct {
+	RoomID int    `json:"room_id"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // 省略すると既定の件数
+}
+
+func (p *GetMessagesParams) Validate() error {
+	if p.RoomID <= 0 {
+		return errors.New("room_id が必要です")
+	}
+	if p.Limit < 0 {
+		return errors.New("limit は1以上の数値である必要があります")
+	}
+	return nil
+}
+
+// CreateRoomParams は createRoom の params（グループルーム。自分は指定しなくてもメンバーになる）
+type CreateRoomParams struct {
+	Name    string `json:"name"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+// DecodeParams は名前付きの params を v に読み込んで検証する。失敗したら RPCInvalidParams の *RPCError を返す。
+func DecodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || params[0] != '{' {
+		return &RPCError{Code: RPCInvalidParams, Message: "params はオブジェクトで指定してください"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+	}
+	if val, ok := v.(validator); ok {
+		if err := val.Validate(); err != nil {
+			return &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+		}
+	}
+	return nil
+}
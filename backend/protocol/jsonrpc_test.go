@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testRPC は echo で params を返し、rejected・fail でエラーを返す
+func testRPC(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "echo":
+		return params, nil
+	case "rejected":
+		return nil, &RPCError{Code: RPCRejected, Message: "空のメッセージです"}
+	case "fail":
+		return nil, errors.New("connection refused")
+	}
+	return nil, &RPCError{Code: RPCMethodNotFound, Message: "未対応のメソッドです"}
+}
+
+func TestServeJSONRPC(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  string // 空ならレスポンスなし
+	}{
+		{"呼び出し", `{"jsonrpc":"2.0","id":1,"method":"echo","params":{"a":1}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"a":1}}`},
+		{"文字列の id", `{"jsonrpc":"2.0","id":"x","method":"echo","params":[]}`,
+			`{"jsonrpc":"2.0","id":"x","result":[]}`},
+		{"通知にはレスポンスを返さない", `{"jsonrpc":"2.0","method":"echo"}`, ""},
+		{"処理のエラー", `{"jsonrpc":"2.0","id":2,"method":"rejected"}`,
+			`{"jsonrpc":"2.0","id":2,"error":{"code":-32000,"message":"空のメッセージです"}}`},
+		{"サーバー側の失敗は中身を伏せる", `{"jsonrpc":"2.0","id":3,"method":"fail"}`,
+			`{"jsonrpc":"2.0","id":3,"error":{"code":-32603,"message":"処理に失敗しました"}}`},
+		{"未対応のメソッド", `{"jsonrpc":"2.0","id":4,"method":"call"}`,
+			`{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"未対応のメソッドです"}}`},
+		{"JSONではない", `{"jsonrpc":`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"JSONとして読めません"}}`},
+		{"jsonrpc がない", `{"id":5,"method":"echo"}`,
+			`{"jsonrpc":"2.0","id":5,"error":{"code":-32600,"message":"jsonrpc は \"2.0\"、method は必須です"}}`},
+		{"オブジェクトの id", `{"jsonrpc":"2.0","id":{},"method":"echo"}`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"jsonrpc は \"2.0\"、method は必須です"}}`},
+		{"オブジェクトではない", `42`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"リクエストの形式が正しくありません"}}`},
+		{"空のバッチ", `[]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"空のバッチです"}}`},
+		{"バッチ", `[{"jsonrpc":"2.0","id":1,"method":"echo","params":1},{"jsonrpc":"2.0","method":"echo"},{"jsonrpc":"2.0","id":2,"method":"call"}]`,
+			`[{"jsonrpc":"2.0","id":1,"result":1},{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"未対応のメソッドです"}}]`},
+		{"通知だけのバッチ", `[{"jsonrpc":"2.0","method":"echo"}]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ServeJSONRPC([]byte(tt.frame), testRPC)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("ServeJSONRPC = %s, want なし", got)
+				}
+				return
+			}
+			var gotV, wantV any
+			if err := json.Unmarshal(got, &gotV); err != nil {
+				t.Fatalf("JSONとして読めません: %s", got)
+			}
+			json.Unmarshal([]byte(tt.want), &wantV)
+			if !reflect.DeepEqual(gotV, wantV) {
+				t.Errorf("ServeJSONRPC =\n%s\nwant\n%s", got, tt.want)
+			}
+			if !IsJSONRPC(got) {
+				t.Errorf("IsJSONRPC(%s) = false", got)
+			}
+		})
+	}
+}
+
+func TestRPCNotification(t *testing.T) {
+	frame := []byte(`{"v":1,"type":"unread","seq":7,"room_id":3,"count":1}`)
+	b, err := RPCNotification(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("JSONとして読めません: %s", b)
+	}
+	if got.JSONRPC != "2.0" || got.ID != nil || got.Method != "unread" || string(got.Params) != string(frame) {
+		t.Errorf("通知 = %s", b)
+	}
+	if IsJSONRPC(frame) {
+		t.Error("イベントのフレームを JSON-RPC と判定しました")
+	}
+}
@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackSubprotocol は /ws でイベントを MessagePack のバイナリフレームで受け取るときに
+// Sec-WebSocket-Protocol で指定するサブプロトコル。指定しなければ JSON のテキストフレームのまま。
+//
+// サーバーのフレームは [v, type, seq, イベント] の配列（seq がなければ 0）。
+// イベントはキーを省いた配列で、要素は server.go の構造体のフィールド順（省略可能なフィールドも nil として並ぶ）。
+// 時刻は MessagePack の timestamp 拡張型（-1）。フィールドは末尾にだけ追加するので、
+// クライアントは知らない末尾の要素を読み飛ばせばよい。
+//
+// 束ねたフレーム（/ws?batch=1）は [v, "batch", 0, [フレーム, ...]]。
+//
+// クライアントからのフレームは、JSON と同じキーを持つ MessagePack のマップのバイナリフレームでもよい。
+const MsgpackSubprotocol = "msgpack-v1"
+
+// serverEvents は type → 空のイベントを作る関数。MessagePack にするときにフレームを型に戻すために使う。
+// ここにない type は、JSON のキーを残したマップとして送る。
+var serverEvents = map[string]func() ServerEvent{}
+
+func registerServer(newEvent func() ServerEvent) {
+	t := newEvent().EventType()
+	if _, dup := serverEvents[t]; dup {
+		panic("protocol: イベントの重複登録: " + t)
+	}
+	serverEvents[t] = newEvent
+}
+
+func init() {
+	registerServer(func() ServerEvent { return &MessageEvent{} })
+	registerServer(func() ServerEvent { return &UnreadEvent{} })
+	registerServer(func() ServerEvent { return &ReadEvent{} })
+	registerServer(func() ServerEvent { return &MentionEvent{} })
+	registerServer(func() ServerEvent { return &ReactionEvent{} })
+	registerServer(func() ServerEvent { return &EditEvent{} })
+	registerServer(func() ServerEvent { return &DeleteEvent{} })
+	registerServer(func() ServerEvent { return &RestoreEvent{} })
+	registerServer(func() ServerEvent { return &QuoteUpdateEvent{} })
+	registerServer(func() ServerEvent { return &ThreadReplyEvent{} })
+	registerServer(func() ServerEvent { return &ThreadUpdateEvent{} })
+	registerServer(func() ServerEvent { return &TypingEvent{} })
+	registerServer(func() ServerEvent { return &PresenceEvent{} })
+	registerServer(func() ServerEvent { return &ReadyEvent{} })
+	registerServer(func() ServerEvent { return &ResyncEvent{} })
+	registerServer(func() ServerEvent { return &AckEvent{} })
+	registerServer(func() ServerEvent { return &ErrorEvent{} })
+}
+
+// FrameToMsgpack は JSON のフレーム（Frame / Encode の結果）を MessagePack のフレームにする。
+// フレームはイベントログやバスを JSON のまま通るので、送る直前に変換する。
+func FrameToMsgpack(frame []byte) ([]byte, error) {
+	var h Header
+	if err := json.Unmarshal(frame, &h); err != nil {
+		return nil, err
+	}
+	var ev any
+	if newEvent, ok := serverEvents[h.Type]; ok {
+		ev = newEvent()
+	} else {
+		ev = &map[string]any{}
+	}
+	if err := json.Unmarshal(frame, ev); err != nil {
+		return nil, fmt.Errorf("protocol: %s を読めません: %w", h.Type, err)
+	}
+	if m, ok := ev.(*map[string]any); ok {
+		delete(*m, "v")
+		delete(*m, "type")
+		delete(*m, "seq")
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseArrayEncodedStructs(true)
+	enc.UseCompactInts(true)
+
+	if err := enc.EncodeArrayLen(4); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeInt(int64(h.V)); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(h.Type); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeInt(h.Seq); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(ev); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MsgpackBatch は FrameToMsgpack したフレームを [v, "batch", 0, [フレーム, ...]] の1つのフレームに束ねる
+func MsgpackBatch(packed [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+
+	if err := enc.EncodeArrayLen(4); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeInt(Version); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(BatchType); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeInt(0); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeArrayLen(len(packed)); err != nil {
+		return nil, err
+	}
+	for _, p := range packed {
+		buf.Write(p) // 各フレームはそれだけで完結した MessagePack の値なので、そのまま並べられる
+	}
+	return buf.Bytes(), nil
+}
+
+// MsgpackToJSON はクライアントが MessagePack のマップで送ったフレームを、Decode で読める JSON にする
+func MsgpackToJSON(b []byte) ([]byte, error) {
+	var m map[string]any
+	if err := msgpack.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
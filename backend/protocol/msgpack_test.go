@@ -0,0 +1,219 @@
+package protocol
+
+import (
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// JSON と MessagePack のフレームサイズ・エンコードの時間を比べる。
+//
+//	go test -run '^$' -bench . ./protocol
+//
+// EncodeJSON はイベントを Marshal して Frame にするまで（全接続で共有するので1イベントにつき1回）、
+// FrameToMsgpack はそのフレームを変換するまで（MessagePack の接続ごとに1回）を測る。
+// bytes/frame はそれぞれのフレームの大きさ。
+
+var (
+	benchTime    = time.Date(2025, 6, 1, 10, 30, 0, 0, time.UTC)
+	benchPreview = &models.QuotePreview{ID: 41, SenderID: 2, Content: "了解です、明日の10時からでお願いします", EditedAt: &benchTime}
+)
+
+var (
+	benchMessage  = MessageEvent{ID: 1042, RoomID: 17, SenderID: 3, Content: "こんにちは！今日の打ち合わせの資料を共有します", Timestamp: benchTime}
+	benchReplyTo  = MessageEvent{ID: 1043, RoomID: 17, SenderID: 2, Content: "ありがとうございます", Timestamp: benchTime, ReplyTo: benchPreview}
+	benchUnread   = UnreadEvent{RoomID: 17, Count: 5}
+	benchRead     = ReadEvent{MessageIDs: []int{1042}, ReadAt: benchTime}
+	benchReaction = ReactionEvent{MessageID: 1042, Emoji: "👍", UserID: 2}
+	benchTyping   = TypingEvent{RoomID: 17, UserIDs: []int{2, 5}, Count: 2}
+	benchPresence = PresenceEvent{UserID: 2, State: models.PresenceIdle, LastSeenAt: &benchTime}
+)
+
+// benchFrame は NotifyUser と同じように seq 付きのフレームを作る
+func benchFrame(tb testing.TB, ev ServerEvent) []byte {
+	body, err := Marshal(ev)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return Frame(ev.EventType(), 12345, body)
+}
+
+func benchmarkEncodeJSON(b *testing.B, ev ServerEvent) {
+	b.ReportAllocs()
+	var frame []byte
+	for b.Loop() {
+		frame = benchFrame(b, ev)
+	}
+	b.ReportMetric(float64(len(frame)), "bytes/frame")
+}
+
+func benchmarkFrameToMsgpack(b *testing.B, ev ServerEvent) {
+	frame := benchFrame(b, ev)
+	b.ReportAllocs()
+	var packed []byte
+	for b.Loop() {
+		var err error
+		if packed, err = FrameToMsgpack(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(packed)), "bytes/frame")
+}
+
+func BenchmarkEncodeJSON_Message(b *testing.B)  { benchmarkEncodeJSON(b, benchMessage) }
+func BenchmarkEncodeJSON_ReplyTo(b *testing.B)  { benchmarkEncodeJSON(b, benchReplyTo) }
+func BenchmarkEncodeJSON_Unread(b *testing.B)   { benchmarkEncodeJSON(b, benchUnread) }
+func BenchmarkEncodeJSON_Read(b *testing.B)     { benchmarkEncodeJSON(b, benchRead) }
+func BenchmarkEncodeJSON_Reaction(b *testing.B) { benchmarkEncodeJSON(b, benchReaction) }
+func BenchmarkEncodeJSON_Typing(b *testing.B)   { benchmarkEncodeJSON(b, benchTyping) }
+func BenchmarkEncodeJSON_Presence(b *testing.B) { benchmarkEncodeJSON(b, benchPresence) }
+
+func BenchmarkFrameToMsgpack_Message(b *testing.B)  { benchmarkFrameToMsgpack(b, benchMessage) }
+func BenchmarkFrameToMsgpack_ReplyTo(b *testing.B)  { benchmarkFrameToMsgpack(b, benchReplyTo) }
+func BenchmarkFrameToMsgpack_Unread(b *testing.B)   { benchmarkFrameToMsgpack(b, benchUnread) }
+func BenchmarkFrameToMsgpack_Read(b *testing.B)     { benchmarkFrameToMsgpack(b, benchRead) }
+func BenchmarkFrameToMsgpack_Reaction(b *testing.B) { benchmarkFrameToMsgpack(b, benchReaction) }
+func BenchmarkFrameToMsgpack_Typing(b *testing.B)   { benchmarkFrameToMsgpack(b, benchTyping) }
+func BenchmarkFrameToMsgpack_Presence(b *testing.B) { benchmarkFrameToMsgpack(b, benchPresence) }
+
+// decodeMsgpackFrame は [v, type, seq, イベント] のフレームを読み、イベントを ev に入れる
+func decodeMsgpackFrame(t *testing.T, b []byte, ev any) Header {
+	t.Helper()
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	n, err := dec.DecodeArrayLen()
+	if err != nil || n != 4 {
+		t.Fatalf("フレームが4要素の配列ではありません: n=%d err=%v", n, err)
+	}
+	var h Header
+	if err := dec.Decode(&h.V); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&h.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&h.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(ev); err != nil {
+		t.Fatalf("%s を読めません: %v", h.Type, err)
+	}
+	return h
+}
+
+// JSON のフレームを MessagePack にして読み戻すと、同じイベントになる
+func TestMsgpackRoundTrip(t *testing.T) {
+	tests := []struct {
+		ev   ServerEvent
+		seq  int64
+		into func() ServerEvent
+	}{
+		{benchMessage, 12345, func() ServerEvent { return &MessageEvent{} }},
+		{benchReplyTo, 1, func() ServerEvent { return &MessageEvent{} }},
+		{benchUnread, 0, func() ServerEvent { return &UnreadEvent{} }},
+		{benchRead, 7, func() ServerEvent { return &ReadEvent{} }},
+		{benchReaction, 8, func() ServerEvent { return &ReactionEvent{} }},
+		{benchTyping, 0, func() ServerEvent { return &TypingEvent{} }},
+		{benchPresence, 0, func() ServerEvent { return &PresenceEvent{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ev.EventType(), func(t *testing.T) {
+			body, err := Marshal(tt.ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packed, err := FrameToMsgpack(Frame(tt.ev.EventType(), tt.seq, body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tt.into()
+			h := decodeMsgpackFrame(t, packed, got)
+			if h.V != Version || h.Type != tt.ev.EventType() || h.Seq != tt.seq {
+				t.Errorf("ヘッダー = %+v", h)
+			}
+			gotJSON, err := Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(gotJSON, body) {
+				t.Errorf("読み戻したイベント =\n%s\nwant\n%s", gotJSON, body)
+			}
+		})
+	}
+}
+
+// sameJSON は a と b が同じ JSON かどうか（時刻の文字列は同じ時刻ならタイムゾーンが違ってもよい）
+func sameJSON(a, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return sameValue(av, bv)
+}
+
+func sameValue(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if !sameValue(v, b[k]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !sameValue(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return false
+		}
+		at, err1 := time.Parse(time.RFC3339Nano, a)
+		bt, err2 := time.Parse(time.RFC3339Nano, b)
+		if err1 == nil && err2 == nil {
+			return at.Equal(bt)
+		}
+		return a == b
+	}
+	return a == b
+}
+
+// クライアントが MessagePack のマップで送ったフレームも Decode で読める
+func TestMsgpackToJSON(t *testing.T) {
+	b, err := msgpack.Marshal(map[string]any{"v": 1, "type": "message", "request_id": "r1", "room_id": 3, "content": "バイナリから"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := MsgpackToJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ev, err := Decode(j)
+	if err != nil {
+		t.Fatalf("Decode(%s): %v", j, err)
+	}
+	if m, ok := ev.(*MessageRequest); !ok || h.RequestID != "r1" || m.RoomID != 3 || m.Content != "バイナリから" {
+		t.Errorf("Decode = %+v, %+v", h, ev)
+	}
+
+	if _, err := MsgpackToJSON([]byte{0xc1}); err == nil {
+		t.Error("MessagePack として読めないのにエラーになりません")
+	}
+}
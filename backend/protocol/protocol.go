@@ -0,0 +1,191 @@
+// Package protocol はWebSocketでやり取りするイベントの型と、エンベロープの読み書きを定義する。
+//
+// フレームはすべて {"v": 1, "type": "...", ...イベントのフィールド} の形のJSONオブジェクト。
+// クライアント→サーバーは *Request、サーバー→クライアントは *Event の型を使う。
+//
+// クライアントのフレームには必ず request_id（クライアントが生成する一意な文字列）を付ける。
+// サーバーは処理できたら "ack"、できなければ "error" を同じ request_id で返す。
+// 同じ request_id の再送は処理し直さず、前回と同じ ack を返す（再接続後の再送を安全にするため）。
+//
+// 記録が残るイベント（メッセージ・編集・既読など）には、ユーザーごとに単調増加する seq が付く。
+// 再接続時に /ws?last_seq=N で最後に受け取った seq を渡すと、N より後のイベントが順に再送され、
+// 最後に "ready" が届く。取りこぼしが古すぎて再送できない場合は、"ready" の前に "resync" が届く。
+//
+// サブプロトコル JSONRPCSubprotocol で接続した場合は、同じイベントを JSON-RPC 2.0 でやり取りする（jsonrpc.go）。
+// MsgpackSubprotocol なら、イベントを MessagePack のバイナリフレームで受け取る（msgpack.go）。
+//
+// /ws?batch=1 で接続すると、短い間に続いたイベントが {"v": 1, "type": "batch", "events": [フレーム, ...]} に
+// 束ねて届くことがある。events の各要素は単体で届くときと同じフレーム（seq も含む）なので、順に処理すればよい。
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version は現在のプロトコルのバージョン。v を省略したフレームは Version として扱う（旧クライアント互換）。
+const Version = 1
+
+// Header はすべてのフレームに共通するエンベロープ部分。
+// RequestID はクライアントのフレームだけ、Seq はイベントログに記録したサーバーのイベントだけに付く。
+type Header struct {
+	V         int    `json:"v"`
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	Seq       int64  `json:"seq,omitempty"`
+}
+
+// request_id の最大長（重複排除のキーとして保存するため）
+const maxRequestIDLength = 128
+
+// ClientEvent はクライアントから送られるイベント
+type ClientEvent interface {
+	EventType() string
+}
+
+// ServerEvent はサーバーから送るイベント
+type ServerEvent interface {
+	EventType() string
+}
+
+// validator は受信後に中身を検証できるイベント
+type validator interface {
+	Validate() error
+}
+
+// clientEvents は type → 空のイベントを作る関数（受信できるイベントの一覧）
+var clientEvents = map[string]func() ClientEvent{}
+
+func register(newEvent func() ClientEvent) {
+	t := newEvent().EventType()
+	if _, dup := clientEvents[t]; dup {
+		panic("protocol: イベントの重複登録: " + t)
+	}
+	clientEvents[t] = newEvent
+}
+
+// エラーコード
+const (
+	CodeMalformed          = "malformed"           // JSONオブジェクトとして読めない
+	CodeUnsupportedVersion = "unsupported_version" // v がサーバーの対応範囲外
+	CodeUnknownType        = "unknown_type"        // 未対応の type
+	CodeInvalidPayload     = "invalid_payload"     // type は正しいが中身の形式・値が不正
+	CodeRejected           = "rejected"            // 形式は正しいが処理できなかった（存在しない返信先など）
+	CodeInternal           = "internal"            // サーバー側の失敗
+)
+
+// Error はクライアントに返すエラー。Type と RequestID は原因になったフレームのもの（分かれば）。
+type Error struct {
+	Code      string
+	Message   string
+	Type      string
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Code, e.Type, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Event はクライアントに送る "error" イベントに変換する
+func (e *Error) Event() ErrorEvent {
+	return ErrorEvent{Code: e.Code, Message: e.Message, RequestType: e.Type, RequestID: e.RequestID}
+}
+
+// Decode はフレームを読み、エンベロープと type に対応するイベントの型にして返す。
+// 失敗したときは *Error を返すので、そのままクライアントに返信できる（読めた範囲で request_id も入る）。
+func Decode(b []byte) (Header, ClientEvent, error) {
+	var h Header
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, nil, &Error{Code: CodeMalformed, Message: "JSONオブジェクトではありません"}
+	}
+	fail := func(code, msg string) (Header, ClientEvent, error) {
+		return h, nil, &Error{Code: code, Message: msg, Type: h.Type, RequestID: h.RequestID}
+	}
+	if h.Type == "" {
+		return fail(CodeMalformed, "type がありません")
+	}
+	if h.V < 0 || h.V > Version {
+		return fail(CodeUnsupportedVersion, fmt.Sprintf("対応していないバージョンです: v=%d（対応: %d）", h.V, Version))
+	}
+	if h.RequestID == "" {
+		return fail(CodeInvalidPayload, "request_id が必要です")
+	}
+	if len(h.RequestID) > maxRequestIDLength {
+		return fail(CodeInvalidPayload, fmt.Sprintf("request_id は%dバイト以内にしてください", maxRequestIDLength))
+	}
+
+	newEvent, ok := clientEvents[h.Type]
+	if !ok {
+		return fail(CodeUnknownType, "未対応の type です")
+	}
+	ev := newEvent()
+	if err := json.Unmarshal(b, ev); err != nil {
+		return fail(CodeInvalidPayload, err.Error())
+	}
+	if v, ok := ev.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return fail(CodeInvalidPayload, err.Error())
+		}
+	}
+	return h, ev, nil
+}
+
+// Encode は ev をエンベロープ付きのフレームにする（seq なし）
+func Encode(ev ServerEvent) ([]byte, error) {
+	body, err := Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	return Frame(ev.EventType(), 0, body), nil
+}
+
+// Marshal はエンベロープを除いたイベント本体をJSONにする。
+// 宛先ごとに seq だけ違うフレームを作るときは、本体を1回だけ Marshal して Frame に渡す。
+func Marshal(ev ServerEvent) ([]byte, error) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 2 || body[0] != '{' {
+		return nil, fmt.Errorf("protocol: %s はJSONオブジェクトになりません", ev.EventType())
+	}
+	return body, nil
+}
+
+// BatchType は複数のイベントを束ねたフレームの type
+const BatchType = "batch"
+
+// BatchFrame はフレームを {"v":1,"type":"batch","events":[...]} の1つのフレームに束ねる
+func BatchFrame(frames [][]byte) []byte {
+	head, _ := json.Marshal(Header{V: Version, Type: BatchType})
+	n := len(head) + len(`,"events":[]}`)
+	for _, f := range frames {
+		n += len(f) + 1
+	}
+	out := make([]byte, 0, n)
+	out = append(out, head[:len(head)-1]...)
+	out = append(out, `,"events":[`...)
+	for i, f := range frames {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, f...)
+	}
+	return append(out, "]}"...)
+}
+
+// Frame は Marshal した本体にエンベロープを付ける（seq が 0 なら付けない）
+func Frame(eventType string, seq int64, body []byte) []byte {
+	head, _ := json.Marshal(Header{V: Version, Type: eventType, Seq: seq}) // 文字列と数値だけなので失敗しない
+
+	// {"v":1,"type":"..."} の閉じ括弧を外して、イベントのフィールドを続ける
+	out := make([]byte, 0, len(head)+len(body))
+	out = append(out, head[:len(head)-1]...)
+	if len(body) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, body[1:]...)
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string
+		wantCode  string // 空なら成功
+		wantType  string
+		wantReqID string
+	}{
+		{"message", `{"v":1,"type":"message","request_id":"r1","room_id":3,"content":"こんにちは"}`, "", "message", "r1"},
+		{"v を省略した旧クライアント", `{"type":"read","request_id":"r2","message_id":5}`, "", "read", "r2"},
+		{"JSONではない", `こんにちは`, CodeMalformed, "", ""},
+		{"type がない", `{"v":1,"request_id":"r3"}`, CodeMalformed, "", "r3"},
+		{"新しすぎるバージョン", `{"v":2,"type":"message","request_id":"r4"}`, CodeUnsupportedVersion, "message", "r4"},
+		{"request_id がない", `{"v":1,"type":"message","room_id":3,"content":"x"}`, CodeInvalidPayload, "message", ""},
+		{"request_id が長すぎる", `{"v":1,"type":"read","request_id":"` + strings.Repeat("x", maxRequestIDLength+1) + `","message_id":5}`, CodeInvalidPayload, "read", strings.Repeat("x", maxRequestIDLength+1)},
+		{"未対応の type", `{"v":1,"type":"call","request_id":"r5"}`, CodeUnknownType, "call", "r5"},
+		{"フィールドの型が違う", `{"v":1,"type":"read","request_id":"r6","message_id":"5"}`, CodeInvalidPayload, "read", "r6"},
+		{"検証に通らない", `{"v":1,"type":"message","request_id":"r7","room_id":3,"content":""}`, CodeInvalidPayload, "message", "r7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ev, err := Decode([]byte(tt.frame))
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				if ev.EventType() != tt.wantType || h.RequestID != tt.wantReqID {
+					t.Errorf("type = %q, request_id = %q", ev.EventType(), h.RequestID)
+				}
+				return
+			}
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("Decode = %v, want *Error", err)
+			}
+			if perr.Code != tt.wantCode || perr.Type != tt.wantType || perr.RequestID != tt.wantReqID {
+				t.Errorf("Error = {%s %s %s}, want {%s %s %s}", perr.Code, perr.Type, perr.RequestID, tt.wantCode, tt.wantType, tt.wantReqID)
+			}
+		})
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	_, ev, err := Decode([]byte(`{"v":1,"type":"message","request_id":"r1","room_id":3,"content":"返信","parent_message_id":7}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := ev.(*MessageRequest)
+	if !ok || m.RoomID != 3 || m.Content != "返信" || m.ParentMessageID == nil || *m.ParentMessageID != 7 {
+		t.Errorf("MessageRequest = %+v", ev)
+	}
+}
+
+func TestEncodeAndFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame func() ([]byte, error)
+		want  map[string]any
+	}{
+		{"Encode", func() ([]byte, error) { return Encode(UnreadEvent{RoomID: 3, Count: 2}) },
+			map[string]any{"v": float64(1), "type": "unread", "room_id": float64(3), "count": float64(2)}},
+		{"Frame に seq", func() ([]byte, error) { return Frame("unread", 42, []byte(`{"room_id":3}`)), nil },
+			map[string]any{"v": float64(1), "type": "unread", "seq": float64(42), "room_id": float64(3)}},
+		{"空の本体", func() ([]byte, error) { return Frame("resync", 0, []byte(`{}`)), nil },
+			map[string]any{"v": float64(1), "type": "resync"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.frame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("JSONとして読めません: %s", b)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("frame = %s, want %v", b, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v（%s）", k, got[k], v, b)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchFrame(t *testing.T) {
+	b := BatchFrame([][]byte{[]byte(`{"v":1,"type":"unread","room_id":1}`), []byte(`{"v":1,"type":"typing","room_id":1}`)})
+	var got struct {
+		Type   string   `json:"type"`
+		Events []Header `json:"events"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("JSONとして読めません: %s", b)
+	}
+	if got.Type != BatchType || len(got.Events) != 2 || got.Events[0].Type != "unread" || got.Events[1].Type != "typing" {
+		t.Errorf("batch = %s", b)
+	}
+}
@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"backend/models"
+	"time"
+)
+
+// サーバーからのイベント。MessagePack ではフィールドを位置で送る（msgpack.go）ので、
+// フィールドは末尾にだけ追加し、並べ替えない。新しいイベントは msgpack.go の init にも登録する。
+
+// MessageEvent は新着メッセージ (type: "message")
+type MessageEvent struct {
+	ID        int                  `json:"id"`
+	RoomID    int                  `json:"room_id"`
+	SenderID  int                  `json:"sender_id"`
+	Content   string               `json:"content"`
+	Timestamp time.Time            `json:"timestamp"`
+	ReadAt    *time.Time           `json:"read_at"`
+	ReplyTo   *models.QuotePreview `json:"reply_to"`
+}
+
+func (MessageEvent) EventType() string { return "message" }
+
+// UnreadEvent はルームの未読数 (type: "unread")
+type UnreadEvent struct {
+	RoomID int `json:"room_id"`
+	Count  int `json:"count"`
+}
+
+func (UnreadEvent) EventType() string { return "unread" }
+
+// ReadEvent は送信者への既読通知 (type: "read")。
+// 同時に既読になった送信者のメッセージを MessageIDs にまとめて1件で送る。
+type ReadEvent struct {
+	MessageIDs []int     `json:"message_ids"`
+	ReadAt     time.Time `json:"read_at"`
+}
+
+func (ReadEvent) EventType() string { return "read" }
+
+// MentionEvent はメンション通知 (type: "mention")。From はメンションしたユーザー。
+type MentionEvent struct {
+	From    int    `json:"from"`
+	RoomID  int    `json:"room_id"`
+	Message string `json:"message"`
+}
+
+func (MentionEvent) EventType() string { return "mention" }
+
+// ReactionEvent はリアクションの変更 (type: "reaction")。UserID は付け外ししたユーザー。
+type ReactionEvent struct {
+	MessageID int    `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	UserID    int    `json:"user_id"`
+}
+
+func (ReactionEvent) EventType() string { return "reaction" }
+
+// EditEvent はメッセージの編集 (type: "edit")
+type EditEvent struct {
+	MessageID int        `json:"message_id"`
+	Content   string     `json:"content"`
+	EditedAt  *time.Time `json:"edited_at"`
+}
+
+func (EditEvent) EventType() string { return "edit" }
+
+// DeleteEvent はメッセージの削除 (type: "delete")
+type DeleteEvent struct {
+	MessageID int `json:"message_id"`
+}
+
+func (DeleteEvent) EventType() string { return "delete" }
+
+// RestoreEvent は削除メッセージの復元 (type: "restore")
+type RestoreEvent struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+func (RestoreEvent) EventType() string { return "restore" }
+
+// QuoteUpdateEvent は引用元の編集・削除・復元 (type: "quote_update")
+type QuoteUpdateEvent struct {
+	MessageID int                 `json:"message_id"`
+	Preview   models.QuotePreview `json:"preview"`
+}
+
+func (QuoteUpdateEvent) EventType() string { return "quote_update" }
+
+// ThreadReplyEvent はフォロー中のスレッドへの返信 (type: "thread_reply")
+type ThreadReplyEvent struct {
+	RootID    int                  `json:"root_id"`
+	ID        int                  `json:"id"`
+	RoomID    int                  `json:"room_id"`
+	SenderID  int                  `json:"sender_id"`
+	Content   string               `json:"content"`
+	Timestamp time.Time            `json:"timestamp"`
+	ReplyTo   *models.QuotePreview `json:"reply_to"`
+}
+
+func (ThreadReplyEvent) EventType() string { return "thread_reply" }
+
+// ThreadUpdateEvent はスレッドの返信数の変化 (type: "thread_update")
+type ThreadUpdateEvent struct {
+	RootID      int        `json:"root_id"`
+	RoomID      int        `json:"room_id"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+}
+
+func (ThreadUpdateEvent) EventType() string { return "thread_update" }
+
+// TypingEvent はルームで入力中のメンバー (type: "typing")。受け手自身は含まない。
+// 入力中が多いときは UserIDs を省き、人数（Count）だけを送る。誰も入力していなければ Count は 0。
+type TypingEvent struct {
+	RoomID  int   `json:"room_id"`
+	UserIDs []int `json:"user_ids,omitempty"`
+	Count   int   `json:"count"`
+}
+
+func (TypingEvent) EventType() string { return "typing" }
+
+// PresenceEvent は同じルームにいるユーザーのオンライン状態の変化 (type: "presence")。
+// State は online / idle / offline。LastSeenAt は本人が隠していれば省く。
+type PresenceEvent struct {
+	UserID     int        `json:"user_id"`
+	State      string     `json:"state"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+func (PresenceEvent) EventType() string { return "presence" }
+
+// ReadyEvent は接続時の再送が終わったことを知らせる (type: "ready")。
+// LastSeq は今後の再接続で last_seq に渡す値、Replayed は再送したイベント数。
+type ReadyEvent struct {
+	LastSeq  int64 `json:"last_seq"`
+	Replayed int   `json:"replayed"`
+}
+
+func (ReadyEvent) EventType() string { return "ready" }
+
+// ResyncEvent はイベントを取りこぼしたので一覧を取り直すよう促す (type: "resync")
+type ResyncEvent struct{}
+
+func (ResyncEvent) EventType() string { return "resync" }
+
+// AckEvent は受信したフレームを処理したことを返す (type: "ack")。
+// "message" なら保存されたメッセージの ID と Timestamp が入る。Duplicate は再送を無視したとき true。
+type AckEvent struct {
+	RequestID   string     `json:"request_id"`
+	RequestType string     `json:"request_type"`
+	ID          int        `json:"id,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+	Duplicate   bool       `json:"duplicate,omitempty"`
+}
+
+func (AckEvent) EventType() string { return "ack" }
+
+// ErrorEvent は受信したフレームを処理できなかったことを返す (type: "error")
+type ErrorEvent struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RequestType string `json:"request_type,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+func (ErrorEvent) EventType() string { return "error" }
@@ -0,0 +1,28 @@
+package search
+
+// Grams は term を含む本文が必ず持つ「1〜2文字の部分文字列」を返す（term は Normalize 済み）。
+// 1文字ならその文字、2文字以上なら連続する2文字すべて。
+//
+// PostgreSQL では search_grams(search_text)（本文の1文字と2文字の部分文字列すべて）に GIN インデックスを張り、
+// search_grams(search_text) @> Grams(term) で候補を絞ってから LIKE で確かめる。
+// pg_trgm は3文字未満の語に索引を使えないが、日本語の単語は1〜2文字のことが多い（東京、会議）。
+func Grams(term string) []string {
+	runes := []rune(term)
+	if len(runes) <= 1 {
+		if len(runes) == 0 {
+			return nil
+		}
+		return []string{term}
+	}
+
+	seen := make(map[string]bool, len(runes)-1)
+	grams := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		g := string(runes[i : i+2])
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
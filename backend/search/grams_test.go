@@ -0,0 +1,25 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGrams(t *testing.T) {
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"", nil},
+		{"東", []string{"東"}},
+		{"東京", []string{"東京"}},
+		{"東京都", []string{"東京", "京都"}},
+		{"ああああ", []string{"ああ"}},
+		{"go 言語", []string{"go", "o ", " 言", "言語"}},
+	}
+	for _, tt := range tests {
+		if got := Grams(tt.term); !slices.Equal(got, tt.want) {
+			t.Errorf("Grams(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
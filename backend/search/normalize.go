@@ -0,0 +1,43 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize は検索用に文字列を正規化する。
+// NFKC で全角英数字→半角、半角カナ→全角カナ（濁点・半濁点も合成する: ｶﾞ→ガ）にそろえ、英字は小文字にする。
+// 文字数が変わることがある（ｶﾞ→ガ、㌔→キロ）ので、元の本文での位置が要るときは normalizeMapped を使う。
+func Normalize(s string) string {
+	runes, _ := normalizeMapped(s)
+	return string(runes)
+}
+
+// normalizeMapped は Normalize した結果のルーンと、それぞれが元の文字列のどのルーン範囲から来たかを返す
+func normalizeMapped(s string) ([]rune, []span) {
+	runes := make([]rune, 0, len(s))
+	src := make([]span, 0, len(s))
+
+	var it norm.Iter
+	it.InitString(norm.NFKC, s)
+	pos, from := 0, 0 // 元の文字列でのバイト位置とルーン位置
+	for !it.Done() {
+		seg := it.Next()
+		next := it.Pos()
+		to := from + utf8.RuneCountInString(s[pos:next])
+		for _, r := range string(seg) {
+			runes = append(runes, unicode.ToLower(r))
+			src = append(src, span{from, to})
+		}
+		pos, from = next, to
+	}
+	return runes, src
+}
+
+// HasAttachment は本文がアップロード画像のURLかどうか（/upload が返す /static/ 配下）
+func HasAttachment(content string) bool {
+	return strings.Contains(content, "/static/")
+}
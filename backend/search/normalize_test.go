@@ -0,0 +1,39 @@
+package search
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello", "hello"},
+		{"ＡＢＣ１２３", "abc123"},      // 全角英数字→半角
+		{"ｶﾀｶﾅ", "カタカナ"},          // 半角カナ→全角カナ
+		{"ｶﾞｷﾞｸﾞ ﾊﾟﾋﾟ", "ガギグ パピ"}, // 濁点・半濁点は合成する
+		{"東京　会議", "東京 会議"},        // 全角スペース→半角
+		{"㌔", "キロ"},
+		{"ひらがな", "ひらがな"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHasAttachment(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"http://localhost:8080/static/cat.png", true},
+		{"/static/ を見て", true},
+		{"static ファイル", false},
+	}
+	for _, tt := range tests {
+		if got := HasAttachment(tt.content); got != tt.want {
+			t.Errorf("HasAttachment(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const dateLayout = "2006-01-02"
+
+// Query は検索クエリを解析した結果。
+//
+//	東京 "会議 資料" from:alice in:123 has:attachment after:2025-05-01 before:2025-05-31
+//
+// 日本語は単語の区切りがないので、検索語は空白で区切られた部分文字列としてそのまま一致させる。
+type Query struct {
+	Terms         []string   // Normalize 済みの検索語（すべて含むものに一致）
+	FromUsername  string     // from:ユーザー名
+	RoomID        int        // in:ルームID（0 なら全ルーム）
+	RoomName      string     // in:グループ名
+	HasAttachment bool       // has:attachment / has:image
+	Since         *time.Time // after:YYYY-MM-DD（その日を含む）
+	Until         *time.Time // before:YYYY-MM-DD（その日を含む。内部では翌日0時未満）
+}
+
+// IsEmpty は検索語も絞り込み条件もないかどうか
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && q.FromUsername == "" && q.RoomID == 0 && q.RoomName == "" &&
+		!q.HasAttachment && q.Since == nil && q.Until == nil
+}
+
+// MatchesText は content がすべての検索語を含むかどうか
+func (q Query) MatchesText(content string) bool {
+	normalized := Normalize(content)
+	for _, t := range q.Terms {
+		if !strings.Contains(normalized, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse は検索文字列を解析する。日付は loc のタイムゾーンで解釈する。
+func Parse(raw string, loc *time.Location) (Query, error) {
+	var q Query
+	for _, tok := range splitQuery(raw) {
+		key, value, ok := strings.Cut(tok.text, ":")
+		if tok.quoted || !ok || value == "" {
+			if t := Normalize(tok.text); t != "" {
+				q.Terms = append(q.Terms, t)
+			}
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			q.FromUsername = strings.TrimPrefix(value, "@")
+		case "in":
+			if id, err := strconv.Atoi(value); err == nil {
+				q.RoomID = id
+			} else {
+				q.RoomName = value
+			}
+		case "has":
+			switch strings.ToLower(value) {
+			case "attachment", "image", "file":
+				q.HasAttachment = true
+			default:
+				return q, fmt.Errorf("has:%s には対応していません", value)
+			}
+		case "after", "since":
+			d, err := time.ParseInLocation(dateLayout, value, loc)
+			if err != nil {
+				return q, fmt.Errorf("日付の形式が正しくありません (YYYY-MM-DD): %s", value)
+			}
+			q.Since = &d
+		case "before", "until":
+			d, err := time.ParseInLocation(dateLayout, value, loc)
+			if err != nil {
+				return q, fmt.Errorf("日付の形式が正しくありません (YYYY-MM-DD): %s", value)
+			}
+			end := d.AddDate(0, 0, 1)
+			q.Until = &end
+		default:
+			// 未知のキーは "12:00" のような普通の検索語として扱う
+			q.Terms = append(q.Terms, Normalize(tok.text))
+		}
+	}
+
+	if q.Since != nil && q.Until != nil && !q.Since.Before(*q.Until) {
+		return q, errors.New("after は before より前の日付にしてください")
+	}
+	return q, nil
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+// splitQuery は空白（全角スペースを含む）で区切り、"..." は1語として扱う
+func splitQuery(raw string) []queryToken {
+	var tokens []queryToken
+	var cur strings.Builder
+	inQuote := false
+
+	flush := func(quoted bool) {
+		if cur.Len() > 0 || quoted {
+			if s := cur.String(); strings.TrimSpace(s) != "" {
+				tokens = append(tokens, queryToken{text: s, quoted: quoted})
+			}
+		}
+		cur.Reset()
+	}
+
+	for _, r := range raw {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			flush(inQuote)
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			flush(false)
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush(inQuote)
+	return tokens
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(s string) *time.Time {
+		d, err := time.ParseInLocation(dateLayout, s, tokyo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+
+	tests := []struct {
+		raw  string
+		want Query
+	}{
+		{"東京　会議", Query{Terms: []string{"東京", "会議"}}},
+		{`"会議 資料" ＡＢＣ`, Query{Terms: []string{"会議 資料", "abc"}}},
+		{"from:@alice in:12", Query{FromUsername: "alice", RoomID: 12}},
+		{"in:開発 has:image", Query{RoomName: "開発", HasAttachment: true}},
+		{"after:2025-05-01 before:2025-05-31", Query{Since: day("2025-05-01"), Until: day("2025-06-01")}},
+		{"12:00 集合", Query{Terms: []string{"12:00", "集合"}}},
+		{"from:", Query{Terms: []string{"from:"}}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.raw, tokyo)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.raw, err)
+			continue
+		}
+		if !queryEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// 日付は渡したタイムゾーンの0時で区切る
+func TestParseDateInLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := Parse("after:2025-05-01", tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 4, 30, 15, 0, 0, 0, time.UTC); !q.Since.Equal(want) {
+		t.Errorf("Since = %v, want %v", q.Since.UTC(), want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, raw := range []string{
+		"has:video",
+		"after:2025/05/01",
+		"before:昨日",
+		"after:2025-06-01 before:2025-05-01",
+	} {
+		if _, err := Parse(raw, time.UTC); err == nil {
+			t.Errorf("Parse(%q) はエラーになるはずです", raw)
+		}
+	}
+}
+
+func TestMatchesText(t *testing.T) {
+	q := Query{Terms: []string{"東京", "go"}}
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"東京で Go の勉強会", true},
+		{"東京でＧＯの勉強会", true},
+		{"大阪で Go の勉強会", false},
+		{"東京の勉強会", false},
+	}
+	for _, tt := range tests {
+		if got := q.MatchesText(tt.content); got != tt.want {
+			t.Errorf("MatchesText(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func queryEqual(a, b Query) bool {
+	timeEqual := func(x, y *time.Time) bool {
+		return (x == nil) == (y == nil) && (x == nil || x.Equal(*y))
+	}
+	return slices.Equal(a.Terms, b.Terms) && a.FromUsername == b.FromUsername && a.RoomID == b.RoomID &&
+		a.RoomName == b.RoomName && a.HasAttachment == b.HasAttachment &&
+		timeEqual(a.Since, b.Since) && timeEqual(a.Until, b.Until)
+}
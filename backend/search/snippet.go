@@ -0,0 +1,106 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+)
+
+// ハイライト用のタグ（本文は HTML エスケープ済みで返す）
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+)
+
+type span struct{ start, end int } // ルーン単位 [start, end)
+
+// Snippet は最初に一致した箇所の前後 radius 文字を切り出し、一致部分を <mark> で囲んで返す。
+// 本文は HTML エスケープされる。一致がなければ先頭から切り出す。
+func Snippet(content string, terms []string, radius int) string {
+	runes := []rune(content)
+	normalized, src := normalizeMapped(content)
+	spans := toSource(findSpans(normalized, terms), src)
+
+	from, to := 0, len(runes)
+	if len(spans) > 0 {
+		from = max(0, spans[0].start-radius)
+		to = min(len(runes), spans[0].end+radius)
+	} else if to > radius*2 {
+		to = radius * 2
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.end <= from || s.start >= to {
+			continue
+		}
+		start, end := max(s.start, from), min(s.end, to)
+		b.WriteString(html.EscapeString(string(runes[pos:start])))
+		b.WriteString(markOpen)
+		b.WriteString(html.EscapeString(string(runes[start:end])))
+		b.WriteString(markClose)
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// findSpans は正規化済みの本文から検索語の出現位置をすべて探し、重なりをまとめて返す
+func findSpans(text []rune, terms []string) []span {
+	var spans []span
+	for _, t := range terms {
+		term := []rune(t)
+		if len(term) == 0 {
+			continue
+		}
+		for i := 0; i+len(term) <= len(text); i++ {
+			if runesEqual(text[i:i+len(term)], term) {
+				spans = append(spans, span{i, i + len(term)})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// toSource は正規化後の位置を元の本文の位置に直す（1文字が複数の文字から合成された場合は、その全体を含める）
+func toSource(spans []span, src []span) []span {
+	for i, s := range spans {
+		spans[i] = span{src[s.start].start, src[s.end-1].end}
+	}
+	return mergeSpans(spans)
+}
+
+// mergeSpans は位置順に並べ、重なったりつながったりする範囲をまとめる
+func mergeSpans(spans []span) []span {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := []span{spans[0]}
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start <= last.end {
+			last.end = max(last.end, s.end)
+		} else {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
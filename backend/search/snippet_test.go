@@ -0,0 +1,29 @@
+package search
+
+import "testing"
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		terms   []string
+		radius  int
+		want    string
+	}{
+		{"一致を囲む", "明日の会議は東京です", []string{"東京"}, 10, "明日の会議は<mark>東京</mark>です"},
+		{"前後を切る", "あいうえおかきくけこさしすせそ", []string{"く"}, 2, "…かき<mark>く</mark>けこ…"},
+		{"複数の語", "会議の資料と会議室", []string{"会議"}, 10, "<mark>会議</mark>の資料と<mark>会議</mark>室"},
+		{"重なりはまとめる", "abcdef", []string{"bcd", "cde"}, 10, "a<mark>bcde</mark>f"},
+		{"全角は元の表記で囲む", "ＧＯの話", []string{"go"}, 10, "<mark>ＧＯ</mark>の話"},
+		{"合成した濁点は両方囲む", "ｶﾞｲﾄﾞを読む", []string{"ガイド"}, 10, "<mark>ｶﾞｲﾄﾞ</mark>を読む"},
+		{"HTMLはエスケープ", "<b>東京</b>", []string{"東京"}, 10, "&lt;b&gt;<mark>東京</mark>&lt;/b&gt;"},
+		{"一致なしは先頭から", "あいうえおかきくけこ", []string{"xyz"}, 3, "あいうえおか…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Snippet(tt.content, tt.terms, tt.radius); got != tt.want {
+				t.Errorf("Snippet(%q, %q, %d) = %q, want %q", tt.content, tt.terms, tt.radius, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,959 @@
+package store
+
+import (
+	"backend/models"
+	"backend/search"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Store = (*Memory)(nil)
+
+// Memory はプロセス内メモリだけで動く Store 実装（テスト・DBなしの開発用）
+type Memory struct {
+	mu sync.RWMutex
+
+	nextUserID     int
+	nextRoomID     int
+	nextMessageID  int
+	nextRevisionID int
+
+	users     map[int]*models.User
+	rooms     map[int]*memRoom
+	messages  map[int]*memMessage
+	reads     map[readKey]*memRead
+	followers map[int]map[int]bool // スレッドの親ID → フォロー中のユーザー
+	requests  map[requestKey]int   // (送信者, request_id) → メッセージID
+	events    map[int]*memEventLog // userID → イベントログ
+	presence  map[presenceKey]memPresence
+	lastSeen  map[int]time.Time
+	hideSeen  map[int]bool
+}
+
+type presenceKey struct {
+	userID  int
+	replica string
+}
+
+type memPresence struct {
+	state     string
+	updatedAt time.Time
+}
+
+type memEventLog struct {
+	lastSeq int64
+	events  []models.UserEvent // seq 昇順
+}
+
+type requestKey struct {
+	senderID  int
+	requestID string
+}
+
+type memRoom struct {
+	room    models.ChatRoom
+	members map[int]time.Time // user_id → joined_at
+}
+
+type memMessage struct {
+	msg       models.Message
+	revisions []models.MessageRevision
+	purged    bool
+}
+
+type readKey struct {
+	messageID int
+	userID    int
+}
+
+type memRead struct {
+	reaction *string
+	readAt   *time.Time
+}
+
+// NewMemory は空のメモリストアを作る
+func NewMemory() *Memory {
+	return &Memory{
+		users:     make(map[int]*models.User),
+		rooms:     make(map[int]*memRoom),
+		messages:  make(map[int]*memMessage),
+		reads:     make(map[readKey]*memRead),
+		followers: make(map[int]map[int]bool),
+		requests:  make(map[requestKey]int),
+		events:    make(map[int]*memEventLog),
+		presence:  make(map[presenceKey]memPresence),
+		lastSeen:  make(map[int]time.Time),
+		hideSeen:  make(map[int]bool),
+	}
+}
+
+// ---- ユーザー ----
+
+func (m *Memory) CreateUser(username, passwordHash string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			return 0, errors.New("username already exists")
+		}
+	}
+	m.nextUserID++
+	m.users[m.nextUserID] = &models.User{ID: m.nextUserID, Username: username, PasswordHash: passwordHash}
+	return m.nextUserID, nil
+}
+
+func (m *Memory) GetUserByID(userID int) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (m *Memory) GetUserByUsername(username string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) ListUsersExcept(userID int) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []models.User
+	for _, id := range sortedKeys(m.users) {
+		if id == userID {
+			continue
+		}
+		users = append(users, models.User{ID: id, Username: m.users[id].Username})
+	}
+	return users, nil
+}
+
+// ---- ルーム ----
+
+func (m *Memory) GetOrCreateDirectRoom(user1ID, user2ID int) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range sortedKeys(m.rooms) {
+		r := m.rooms[id]
+		if r.room.IsGroup || len(r.members) != 2 {
+			continue
+		}
+		_, ok1 := r.members[user1ID]
+		_, ok2 := r.members[user2ID]
+		if ok1 && ok2 {
+			return id, false, nil
+		}
+	}
+
+	return m.createRoomLocked("", false, []int{user1ID, user2ID}), true, nil
+}
+
+func (m *Memory) CreateRoom(name string, isGroup bool, memberIDs []int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.createRoomLocked(name, isGroup, memberIDs), nil
+}
+
+func (m *Memory) createRoomLocked(name string, isGroup bool, memberIDs []int) int {
+	m.nextRoomID++
+	now := time.Now()
+	r := &memRoom{
+		room:    models.ChatRoom{ID: m.nextRoomID, RoomName: name, IsGroup: isGroup},
+		members: make(map[int]time.Time),
+	}
+	for _, uid := range memberIDs {
+		r.members[uid] = now
+	}
+	m.rooms[r.room.ID] = r
+	return r.room.ID
+}
+
+func (m *Memory) ListUserRooms(userID int, groupsOnly bool) ([]models.ChatRoom, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rooms := make([]models.ChatRoom, 0)
+	for _, id := range sortedKeys(m.rooms) {
+		r := m.rooms[id]
+		if _, ok := r.members[userID]; !ok {
+			continue
+		}
+		if groupsOnly && !r.room.IsGroup {
+			continue
+		}
+		rooms = append(rooms, r.room)
+	}
+	return rooms, nil
+}
+
+// ---- ルームメンバー ----
+
+func (m *Memory) GetRoomMembers(roomID int) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+	var members []models.User
+	for _, uid := range sortedKeys(r.members) {
+		u := models.User{ID: uid}
+		if user, ok := m.users[uid]; ok {
+			u.Username = user.Username
+		}
+		members = append(members, u)
+	}
+	return members, nil
+}
+
+func (m *Memory) ListRoomMemberIDs(roomID int) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+	return sortedKeys(r.members), nil
+}
+
+func (m *Memory) IsRoomMember(roomID, userID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		return false, nil
+	}
+	_, ok = r.members[userID]
+	return ok, nil
+}
+
+// ---- メッセージ ----
+
+func (m *Memory) CreateMessage(msg *models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{msg.SenderID, msg.ClientRequestID}
+	if msg.ClientRequestID != "" {
+		if id, ok := m.requests[key]; ok {
+			*msg = m.messages[id].msg
+			return ErrDuplicateRequest
+		}
+	}
+
+	m.nextMessageID++
+	msg.ID = m.nextMessageID
+	msg.Timestamp = time.Now()
+	m.messages[msg.ID] = &memMessage{msg: *msg}
+	if msg.ClientRequestID != "" {
+		m.requests[key] = msg.ID
+	}
+
+	if msg.ParentMessageID != nil {
+		if parent, ok := m.messages[*msg.ParentMessageID]; ok {
+			parent.msg.ReplyCount++
+			ts := msg.Timestamp
+			parent.msg.LastReplyAt = &ts
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetMessage(messageID int) (*models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mm, ok := m.messages[messageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	msg := mm.msg
+	return &msg, nil
+}
+
+func (m *Memory) ListMessagesByIDs(messageIDs []int) ([]models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []models.Message
+	for _, id := range messageIDs {
+		if mm, ok := m.messages[id]; ok {
+			messages = append(messages, mm.msg)
+		}
+	}
+	return messages, nil
+}
+
+func (m *Memory) GetSenderIDByMessageID(messageID int) (int, error) {
+	msg, err := m.GetMessage(messageID)
+	if err != nil {
+		return 0, err
+	}
+	return msg.SenderID, nil
+}
+
+func (m *Memory) IsMessageQuoted(messageID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, mm := range m.messages {
+		if mm.msg.ReplyToMessageID != nil && *mm.msg.ReplyToMessageID == messageID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) ListMessagesPage(roomID int, q models.PageQuery) ([]models.Message, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var all []models.Message
+	for _, mm := range m.messages {
+		if mm.msg.RoomID == roomID && mm.msg.ParentMessageID == nil {
+			all = append(all, mm.msg)
+		}
+	}
+	sortMessages(all)
+	page, hasMore := pageMessages(all, q)
+	return page, hasMore, nil
+}
+
+func (m *Memory) ListThreadPage(rootID int, q models.PageQuery) ([]models.Message, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var all []models.Message
+	for _, mm := range m.messages {
+		if mm.msg.ParentMessageID != nil && *mm.msg.ParentMessageID == rootID {
+			all = append(all, mm.msg)
+		}
+	}
+	sortMessages(all)
+	page, hasMore := pageMessages(all, q)
+	return page, hasMore, nil
+}
+
+func (m *Memory) UpdateMessageContent(messageID, senderID int, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.messages[messageID]
+	if !ok || mm.msg.SenderID != senderID || mm.msg.IsDeleted() {
+		return ErrNotFound
+	}
+	now := time.Now()
+	m.nextRevisionID++
+	mm.revisions = append(mm.revisions, models.MessageRevision{
+		ID:        m.nextRevisionID,
+		MessageID: messageID,
+		Content:   mm.msg.Content,
+		EditedBy:  senderID,
+		EditedAt:  now,
+	})
+	mm.msg.Content = content
+	mm.msg.EditedAt = &now
+	return nil
+}
+
+func (m *Memory) ListMessageRevisions(messageID int) ([]models.MessageRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := []models.MessageRevision{}
+	if mm, ok := m.messages[messageID]; ok {
+		revisions = append(revisions, mm.revisions...)
+	}
+	return revisions, nil
+}
+
+func (m *Memory) SoftDeleteMessage(messageID, deletedBy int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.messages[messageID]
+	if !ok || mm.msg.IsDeleted() {
+		return ErrNotFound
+	}
+	now := time.Now()
+	mm.msg.DeletedAt = &now
+	mm.msg.DeletedBy = &deletedBy
+	return nil
+}
+
+func (m *Memory) RestoreMessage(messageID int, deletedSince time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.messages[messageID]
+	if !ok || !mm.msg.IsDeleted() || mm.purged || mm.msg.DeletedAt.Before(deletedSince) {
+		return ErrNotFound
+	}
+	mm.msg.DeletedAt = nil
+	mm.msg.DeletedBy = nil
+	return nil
+}
+
+func (m *Memory) PurgeDeletedMessages(deletedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for id, mm := range m.messages {
+		if !mm.msg.IsDeleted() || mm.purged || !mm.msg.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		mm.msg.Content = ""
+		mm.revisions = nil
+		mm.purged = true
+		for k, r := range m.reads {
+			if k.messageID == id {
+				r.reaction = nil
+			}
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (m *Memory) SearchMessages(userID int, q search.Query, page models.PageQuery) ([]models.Message, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hits []models.Message
+	for _, mm := range m.messages {
+		msg := mm.msg
+		r, ok := m.rooms[msg.RoomID]
+		if !ok {
+			continue
+		}
+		if _, member := r.members[userID]; !member {
+			continue
+		}
+		if msg.IsDeleted() || !q.MatchesText(msg.Content) {
+			continue
+		}
+		if q.FromUsername != "" {
+			if u, ok := m.users[msg.SenderID]; !ok || u.Username != q.FromUsername {
+				continue
+			}
+		}
+		if q.RoomID != 0 && msg.RoomID != q.RoomID {
+			continue
+		}
+		if q.RoomName != "" && r.room.RoomName != q.RoomName {
+			continue
+		}
+		if q.HasAttachment && !search.HasAttachment(msg.Content) {
+			continue
+		}
+		if q.Since != nil && msg.Timestamp.Before(*q.Since) {
+			continue
+		}
+		if q.Until != nil && !msg.Timestamp.Before(*q.Until) {
+			continue
+		}
+		if page.Before != nil && !models.CursorOf(msg).Before(*page.Before) {
+			continue
+		}
+		hits = append(hits, msg)
+	}
+
+	sortMessages(hits)
+	reverseMessages(hits)
+	hasMore := len(hits) > page.Limit
+	if hasMore {
+		hits = hits[:page.Limit]
+	}
+	return hits, hasMore, nil
+}
+
+func (m *Memory) HardDeleteMessage(messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted, ok := m.messages[messageID]
+	if !ok {
+		return nil
+	}
+	m.deleteMessageLocked(messageID)
+	for k := range m.reads {
+		if k.messageID == messageID {
+			delete(m.reads, k)
+		}
+	}
+	delete(m.followers, messageID)
+	// PostgreSQL の外部キーに合わせる（返信は CASCADE、引用は SET NULL）
+	for id, mm := range m.messages {
+		if mm.msg.ParentMessageID != nil && *mm.msg.ParentMessageID == messageID {
+			m.deleteMessageLocked(id)
+		}
+		if mm.msg.ReplyToMessageID != nil && *mm.msg.ReplyToMessageID == messageID {
+			mm.msg.ReplyToMessageID = nil
+		}
+	}
+
+	// スレッドの返信なら、親の reply_count / last_reply_at を残った返信に合わせる
+	if deleted.msg.ParentMessageID != nil {
+		if parent, ok := m.messages[*deleted.msg.ParentMessageID]; ok {
+			parent.msg.ReplyCount--
+			parent.msg.LastReplyAt = nil
+			for _, reply := range m.messages {
+				if reply.msg.ParentMessageID != nil && *reply.msg.ParentMessageID == parent.msg.ID &&
+					(parent.msg.LastReplyAt == nil || reply.msg.Timestamp.After(*parent.msg.LastReplyAt)) {
+					ts := reply.msg.Timestamp
+					parent.msg.LastReplyAt = &ts
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteMessageLocked はメッセージと再送判定用の request_id を消す（m.mu を持って呼ぶ）
+func (m *Memory) deleteMessageLocked(messageID int) {
+	if mm, ok := m.messages[messageID]; ok && mm.msg.ClientRequestID != "" {
+		delete(m.requests, requestKey{mm.msg.SenderID, mm.msg.ClientRequestID})
+	}
+	delete(m.messages, messageID)
+}
+
+// ---- スレッドのフォロー ----
+
+func (m *Memory) FollowThread(rootID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.followers[rootID] == nil {
+		m.followers[rootID] = make(map[int]bool)
+	}
+	m.followers[rootID][userID] = true
+	return nil
+}
+
+func (m *Memory) UnfollowThread(rootID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.followers[rootID], userID)
+	return nil
+}
+
+func (m *Memory) IsFollowingThread(rootID, userID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.followers[rootID][userID], nil
+}
+
+func (m *Memory) ListThreadFollowerIDs(rootID int) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return sortedKeys(m.followers[rootID]), nil
+}
+
+// countsAsUnread は followedThreadsOnly のとき、フォローしていないスレッドの返信を除く
+func (m *Memory) countsAsUnread(msg models.Message, userID int, followedThreadsOnly bool) bool {
+	if msg.IsDeleted() {
+		return false
+	}
+	if !followedThreadsOnly || msg.ParentMessageID == nil {
+		return true
+	}
+	return m.followers[*msg.ParentMessageID][userID]
+}
+
+// ---- 既読 ----
+
+func (m *Memory) InsertUnreadReads(messageID int, userIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, uid := range userIDs {
+		k := readKey{messageID, uid}
+		if _, ok := m.reads[k]; !ok {
+			m.reads[k] = &memRead{}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) MarkRoomRead(roomID, userID int) ([]models.ReadUpdate, error) {
+	return m.markRead(userID, func(msg models.Message) bool {
+		return msg.RoomID == roomID && msg.ParentMessageID == nil
+	})
+}
+
+func (m *Memory) MarkThreadRead(rootID, userID int) ([]models.ReadUpdate, error) {
+	return m.markRead(userID, func(msg models.Message) bool {
+		return msg.ParentMessageID != nil && *msg.ParentMessageID == rootID
+	})
+}
+
+func (m *Memory) markRead(userID int, match func(models.Message) bool) ([]models.ReadUpdate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var updates []models.ReadUpdate
+	for k, r := range m.reads {
+		mm, ok := m.messages[k.messageID]
+		if !ok || k.userID != userID || r.readAt != nil {
+			continue
+		}
+		if !match(mm.msg) || mm.msg.SenderID == userID {
+			continue
+		}
+		r.readAt = &now
+		updates = append(updates, models.ReadUpdate{ID: k.messageID, SenderID: mm.msg.SenderID, ReadAt: now})
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ID < updates[j].ID })
+	return updates, nil
+}
+
+func (m *Memory) MarkMessageRead(messageID, userID int) (*models.ReadReceipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.messages[messageID]
+	r, ok2 := m.reads[readKey{messageID, userID}]
+	if !ok || !ok2 {
+		return nil, ErrNotFound
+	}
+	if r.readAt == nil {
+		now := time.Now()
+		r.readAt = &now
+	}
+	return &models.ReadReceipt{MessageID: messageID, SenderID: mm.msg.SenderID, ReadAt: *r.readAt}, nil
+}
+
+func (m *Memory) UpsertMessageRead(messageID, userID int, readAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := readKey{messageID, userID}
+	r, ok := m.reads[k]
+	if !ok {
+		r = &memRead{}
+		m.reads[k] = r
+	}
+	r.readAt = &readAt
+	return nil
+}
+
+func (m *Memory) ListMessageReads(messageIDs []int) ([]models.MessageRead, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reads []models.MessageRead
+	for _, id := range messageIDs {
+		for k, r := range m.reads {
+			if k.messageID == id {
+				reads = append(reads, models.MessageRead{MessageID: k.messageID, UserID: k.userID, Reaction: r.reaction, ReadAt: r.readAt})
+			}
+		}
+	}
+	sort.Slice(reads, func(i, j int) bool {
+		if reads[i].MessageID != reads[j].MessageID {
+			return reads[i].MessageID < reads[j].MessageID
+		}
+		return reads[i].UserID < reads[j].UserID
+	})
+	return reads, nil
+}
+
+func (m *Memory) CountUnread(userID, roomID int, followedThreadsOnly bool) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for k, r := range m.reads {
+		if k.userID != userID || r.readAt != nil {
+			continue
+		}
+		if mm, ok := m.messages[k.messageID]; ok && mm.msg.RoomID == roomID && m.countsAsUnread(mm.msg, userID, followedThreadsOnly) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) UnreadCounts(userID int, followedThreadsOnly bool) (map[int]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[int]int)
+	for k, r := range m.reads {
+		if k.userID != userID || r.readAt != nil {
+			continue
+		}
+		if mm, ok := m.messages[k.messageID]; ok && m.countsAsUnread(mm.msg, userID, followedThreadsOnly) {
+			result[mm.msg.RoomID]++
+		}
+	}
+	return result, nil
+}
+
+func (m *Memory) CountRoomReaders(roomID int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	readers := make(map[int]bool)
+	for k, r := range m.reads {
+		if r.readAt == nil {
+			continue
+		}
+		if mm, ok := m.messages[k.messageID]; ok && mm.msg.RoomID == roomID {
+			readers[k.userID] = true
+		}
+	}
+	return len(readers), nil
+}
+
+// ---- リアクション ----
+
+func (m *Memory) GetReaction(messageID, userID int) (*string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if r, ok := m.reads[readKey{messageID, userID}]; ok {
+		return r.reaction, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) SetReaction(messageID, userID int, emoji *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := readKey{messageID, userID}
+	r, ok := m.reads[k]
+	if !ok {
+		now := time.Now()
+		r = &memRead{readAt: &now}
+		m.reads[k] = r
+	}
+	r.reaction = emoji
+	return nil
+}
+
+// ---- イベントログ ----
+
+func (m *Memory) AppendUserEvents(userIDs []int, eventType string, payload []byte) (map[int]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	seqs := make(map[int]int64, len(userIDs))
+	for _, uid := range userIDs {
+		log := m.events[uid]
+		if log == nil {
+			log = &memEventLog{}
+			m.events[uid] = log
+		}
+		log.lastSeq++
+		log.events = append(log.events, models.UserEvent{
+			UserID:    uid,
+			Seq:       log.lastSeq,
+			Type:      eventType,
+			Payload:   append([]byte(nil), payload...),
+			CreatedAt: now,
+		})
+		seqs[uid] = log.lastSeq
+	}
+	return seqs, nil
+}
+
+func (m *Memory) ListUserEvents(userID int, afterSeq int64, limit int) ([]models.UserEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	log := m.events[userID]
+	if log == nil {
+		return nil, nil
+	}
+	start := sort.Search(len(log.events), func(i int) bool { return log.events[i].Seq > afterSeq })
+	rest := log.events[start:]
+	if len(rest) > limit {
+		rest = rest[:limit]
+	}
+	return append([]models.UserEvent{}, rest...), nil
+}
+
+func (m *Memory) UserEventRange(userID int) (int64, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	log := m.events[userID]
+	if log == nil {
+		return 1, 0, nil
+	}
+	if len(log.events) == 0 {
+		return log.lastSeq + 1, log.lastSeq, nil
+	}
+	return log.events[0].Seq, log.lastSeq, nil
+}
+
+func (m *Memory) PurgeUserEvents(createdBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, log := range m.events {
+		n := sort.Search(len(log.events), func(i int) bool { return !log.events[i].CreatedAt.Before(createdBefore) })
+		log.events = append([]models.UserEvent{}, log.events[n:]...)
+		count += n
+	}
+	return count, nil
+}
+
+// ---- プレゼンス ----
+
+func (m *Memory) SetPresence(userID int, replica, state string, lastSeen time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := presenceKey{userID: userID, replica: replica}
+	if state == models.PresenceOffline {
+		delete(m.presence, key)
+	} else {
+		m.presence[key] = memPresence{state: state, updatedAt: time.Now()}
+	}
+	if lastSeen.After(m.lastSeen[userID]) {
+		m.lastSeen[userID] = lastSeen
+	}
+	return nil
+}
+
+func (m *Memory) RefreshPresence(replica string, staleBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, p := range m.presence {
+		switch {
+		case key.replica == replica:
+			p.updatedAt = now
+			m.presence[key] = p
+		case p.updatedAt.Before(staleBefore):
+			delete(m.presence, key)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetPresences(userIDs []int, freshSince time.Time) (map[int]models.Presence, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[int]models.Presence, len(userIDs))
+	for _, uid := range userIDs {
+		if _, ok := m.users[uid]; !ok {
+			continue
+		}
+		pr := models.Presence{State: models.PresenceOffline, HideLastSeen: m.hideSeen[uid]}
+		if t, ok := m.lastSeen[uid]; ok {
+			pr.LastSeenAt = &t
+		}
+		out[uid] = pr
+	}
+	for key, p := range m.presence {
+		pr, ok := out[key.userID]
+		if !ok || p.updatedAt.Before(freshSince) || pr.State == models.PresenceOnline {
+			continue
+		}
+		pr.State = p.state
+		out[key.userID] = pr
+	}
+	return out, nil
+}
+
+func (m *Memory) SetHideLastSeen(userID int, hide bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	m.hideSeen[userID] = hide
+	return nil
+}
+
+func (m *Memory) ListRoomPeerIDs(userID int) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	peers := make(map[int]bool)
+	for _, r := range m.rooms {
+		if _, ok := r.members[userID]; !ok {
+			continue
+		}
+		for uid := range r.members {
+			if uid != userID {
+				peers[uid] = true
+			}
+		}
+	}
+	return sortedKeys(peers), nil
+}
+
+// sortMessages は created_at, id の昇順に並べる
+func sortMessages(messages []models.Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+}
+
+// pageMessages は古い順に並んだ all から q の範囲を切り出す（Postgres 実装と同じ意味）
+func pageMessages(all []models.Message, q models.PageQuery) ([]models.Message, bool) {
+	switch {
+	case q.After != nil:
+		start := sort.Search(len(all), func(i int) bool { return q.After.Before(models.CursorOf(all[i])) })
+		rest := all[start:]
+		if len(rest) > q.Limit {
+			return append([]models.Message{}, rest[:q.Limit]...), true
+		}
+		return append([]models.Message{}, rest...), false
+	case q.Before != nil:
+		end := sort.Search(len(all), func(i int) bool { return !models.CursorOf(all[i]).Before(*q.Before) })
+		all = all[:end]
+	}
+
+	if len(all) > q.Limit {
+		return append([]models.Message{}, all[len(all)-q.Limit:]...), true
+	}
+	return append([]models.Message{}, all...), false
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
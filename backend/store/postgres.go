@@ -0,0 +1,1146 @@
+package store
+
+import (
+	"backend/models"
+	"backend/search"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var _ Store = (*Postgres)(nil)
+
+// Postgres は PostgreSQL をバックエンドにした Store 実装
+type Postgres struct {
+	db *sql.DB
+}
+
+// NewPostgres は接続済みの *sql.DB から Store を作る
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// ---- ユーザー ----
+
+func (p *Postgres) CreateUser(username, passwordHash string) (int, error) {
+	var id int
+	err := p.db.QueryRow(
+		`INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id`,
+		username, passwordHash,
+	).Scan(&id)
+	return id, err
+}
+
+func (p *Postgres) GetUserByID(userID int) (*models.User, error) {
+	var u models.User
+	err := p.db.QueryRow(
+		`SELECT id, username, password_hash FROM users WHERE id = $1`, userID,
+	).Scan(&u.ID, &u.Username, &u.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p *Postgres) GetUserByUsername(username string) (*models.User, error) {
+	var u models.User
+	err := p.db.QueryRow(
+		`SELECT id, username, password_hash FROM users WHERE username = $1`, username,
+	).Scan(&u.ID, &u.Username, &u.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (p *Postgres) ListUsersExcept(userID int) ([]models.User, error) {
+	rows, err := p.db.Query("SELECT id, username FROM users WHERE id != $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// ---- ルーム ----
+
+// GetOrCreateDirectRoom は 1対1チャット用のルームを取得または作成する
+func (p *Postgres) GetOrCreateDirectRoom(user1ID, user2ID int) (int, bool, error) {
+	var roomID int
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+	SELECT rm.room_id
+	FROM room_members rm
+	JOIN chat_rooms cr ON rm.room_id = cr.id
+	WHERE cr.is_group = 0
+	  AND rm.room_id IN (
+	    SELECT room_id FROM room_members WHERE user_id = $1
+	    INTERSECT
+	    SELECT room_id FROM room_members WHERE user_id = $2
+	  )
+	GROUP BY rm.room_id
+	HAVING COUNT(*) = 2
+`, user1ID, user2ID).Scan(&roomID)
+
+	created := false
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`
+    INSERT INTO chat_rooms (room_name, is_group, created_at, updated_at)
+    VALUES ('', 0, NOW(), NOW())
+    RETURNING id
+    `).Scan(&roomID)
+		if err != nil {
+			return 0, false, err
+		}
+
+		for _, uid := range []int{user1ID, user2ID} {
+			_, err := tx.Exec(`
+				INSERT INTO room_members (room_id, user_id, joined_at)
+				VALUES ($1, $2, NOW())
+			`, roomID, uid)
+			if err != nil {
+				return 0, false, err
+			}
+		}
+		created = true
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return roomID, created, nil
+}
+
+func (p *Postgres) CreateRoom(name string, isGroup bool, memberIDs []int) (int, error) {
+	isGroupInt := 0
+	if isGroup {
+		isGroupInt = 1
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var roomID int
+	err = tx.QueryRow(`
+		INSERT INTO chat_rooms (room_name, is_group, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		RETURNING id
+	`, name, isGroupInt, now).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO room_members (room_id, user_id, joined_at)
+		VALUES ($1, $2, $3)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, uid := range memberIDs {
+		if _, err := stmt.Exec(roomID, uid, now); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return roomID, nil
+}
+
+func (p *Postgres) ListUserRooms(userID int, groupsOnly bool) ([]models.ChatRoom, error) {
+	query := `
+		SELECT cr.id, cr.room_name, cr.is_group
+		FROM chat_rooms cr
+		JOIN room_members rm ON cr.id = rm.room_id
+		WHERE rm.user_id = $1
+	`
+	if groupsOnly {
+		query += ` AND cr.is_group = 1`
+	}
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]models.ChatRoom, 0)
+	for rows.Next() {
+		var room models.ChatRoom
+		if err := rows.Scan(&room.ID, &room.RoomName, &room.IsGroup); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// ---- ルームメンバー ----
+
+func (p *Postgres) GetRoomMembers(roomID int) ([]models.User, error) {
+	rows, err := p.db.Query(`
+		SELECT u.id, u.username
+		FROM room_members rm
+		JOIN users u ON rm.user_id = u.id
+		WHERE rm.room_id = $1
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username); err != nil {
+			return nil, err
+		}
+		members = append(members, u)
+	}
+	return members, rows.Err()
+}
+
+func (p *Postgres) ListRoomMemberIDs(roomID int) ([]int, error) {
+	rows, err := p.db.Query("SELECT user_id FROM room_members WHERE room_id = $1", roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (p *Postgres) IsRoomMember(roomID, userID int) (bool, error) {
+	var exists bool
+	err := p.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// ---- メッセージ ----
+
+// messageColumns は models.Message を読み込むときの列（messages の別名は m）。scanMessage と対応する。
+const messageColumns = `m.id, m.room_id, m.sender_id, m.content, m.created_at, m.updated_at, m.deleted_at, m.deleted_by,
+	m.parent_message_id, m.reply_count, m.last_reply_at, m.reply_to_message_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner, m *models.Message) error {
+	return row.Scan(&m.ID, &m.RoomID, &m.SenderID, &m.Content, &m.Timestamp, &m.EditedAt, &m.DeletedAt, &m.DeletedBy,
+		&m.ParentMessageID, &m.ReplyCount, &m.LastReplyAt, &m.ReplyToMessageID)
+}
+
+// CreateMessage は msg を保存し、ID と Timestamp を埋める。
+// スレッドの返信（ParentMessageID あり）なら親の reply_count / last_reply_at も更新する。
+func (p *Postgres) CreateMessage(msg *models.Message) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+	INSERT INTO messages (sender_id, room_id, content, search_text, parent_message_id, reply_to_message_id, client_request_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NOW())
+	ON CONFLICT (sender_id, client_request_id) WHERE client_request_id IS NOT NULL DO NOTHING
+	RETURNING id, created_at
+`, msg.SenderID, msg.RoomID, msg.Content, search.Normalize(msg.Content), msg.ParentMessageID, msg.ReplyToMessageID, msg.ClientRequestID).Scan(&msg.ID, &msg.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 再送: 既に保存済みのメッセージを返す
+		requestID := msg.ClientRequestID
+		err = scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages m WHERE m.sender_id = $1 AND m.client_request_id = $2`,
+			msg.SenderID, requestID), msg)
+		if err != nil {
+			return err
+		}
+		msg.ClientRequestID = requestID
+		return ErrDuplicateRequest
+	}
+	if err != nil {
+		return err
+	}
+
+	if msg.ParentMessageID != nil {
+		if _, err := tx.Exec(`
+			UPDATE messages
+			SET reply_count = reply_count + 1, last_reply_at = $2
+			WHERE id = $1
+		`, *msg.ParentMessageID, msg.Timestamp); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) GetMessage(messageID int) (*models.Message, error) {
+	var m models.Message
+	err := scanMessage(p.db.QueryRow(`SELECT `+messageColumns+` FROM messages m WHERE m.id = $1`, messageID), &m)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListMessagesByIDs は指定IDのメッセージを返す（存在しないIDは無視、順序は不定）
+func (p *Postgres) ListMessagesByIDs(messageIDs []int) ([]models.Message, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := p.db.Query(`SELECT `+messageColumns+` FROM messages m WHERE m.id = ANY($1)`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (p *Postgres) GetSenderIDByMessageID(messageID int) (int, error) {
+	var senderID int
+	err := p.db.QueryRow("SELECT sender_id FROM messages WHERE id = $1", messageID).Scan(&senderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return senderID, err
+}
+
+func (p *Postgres) IsMessageQuoted(messageID int) (bool, error) {
+	var quoted bool
+	err := p.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE reply_to_message_id = $1)`,
+		messageID,
+	).Scan(&quoted)
+	return quoted, err
+}
+
+// ListMessagesPage はルームのタイムライン（スレッドの返信を除く）から q の範囲を古い順で返す。
+// hasMore は取得方向（Before/最新なら過去、After なら未来）にまだ続きがあるかどうか。
+func (p *Postgres) ListMessagesPage(roomID int, q models.PageQuery) ([]models.Message, bool, error) {
+	return p.listMessagesPage(`m.room_id = $1 AND m.parent_message_id IS NULL`, roomID, q)
+}
+
+// ListThreadPage はスレッドの返信から q の範囲を古い順で返す（親メッセージは含まない）
+func (p *Postgres) ListThreadPage(rootID int, q models.PageQuery) ([]models.Message, bool, error) {
+	return p.listMessagesPage(`m.parent_message_id = $1`, rootID, q)
+}
+
+// listMessagesPage は where（$1 に key を使う）に一致するメッセージを (created_at, id) 順でページングする
+func (p *Postgres) listMessagesPage(where string, key int, q models.PageQuery) ([]models.Message, bool, error) {
+	var rows *sql.Rows
+	var err error
+	switch {
+	case q.After != nil:
+		rows, err = p.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages m
+			WHERE `+where+` AND (m.created_at, m.id) > ($2, $3)
+			ORDER BY m.created_at ASC, m.id ASC
+			LIMIT $4
+		`, key, q.After.CreatedAt, q.After.ID, q.Limit+1)
+	case q.Before != nil:
+		rows, err = p.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages m
+			WHERE `+where+` AND (m.created_at, m.id) < ($2, $3)
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $4
+		`, key, q.Before.CreatedAt, q.Before.ID, q.Limit+1)
+	default:
+		rows, err = p.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages m
+			WHERE `+where+`
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $2
+		`, key, q.Limit+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > q.Limit
+	if hasMore {
+		messages = messages[:q.Limit]
+	}
+	if q.After == nil {
+		reverseMessages(messages)
+	}
+	return messages, hasMore, nil
+}
+
+// UpdateMessageContent は送信者本人のメッセージだけを更新し、編集前の本文を履歴に残す。
+// 該当しなければ ErrNotFound。
+func (p *Postgres) UpdateMessageContent(messageID, senderID int, content string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow(`
+		SELECT content FROM messages
+		WHERE id = $1 AND sender_id = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, messageID, senderID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_revisions (message_id, content, edited_by, edited_at)
+		VALUES ($1, $2, $3, NOW())
+	`, messageID, previous, senderID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE messages
+		SET content = $1, search_text = $2, updated_at = NOW()
+		WHERE id = $3
+	`, content, search.Normalize(content), messageID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListMessageRevisions は編集前の本文を古い順に返す
+func (p *Postgres) ListMessageRevisions(messageID int) ([]models.MessageRevision, error) {
+	rows, err := p.db.Query(`
+		SELECT id, message_id, content, COALESCE(edited_by, 0), edited_at
+		FROM message_revisions
+		WHERE message_id = $1
+		ORDER BY id
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.MessageRevision{}
+	for rows.Next() {
+		var r models.MessageRevision
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.Content, &r.EditedBy, &r.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// SoftDeleteMessage はメッセージを削除済みにする（本文は消去ジョブまで残す）。
+// 存在しないか削除済みなら ErrNotFound。
+func (p *Postgres) SoftDeleteMessage(messageID, deletedBy int) error {
+	res, err := p.db.Exec(`
+		UPDATE messages
+		SET deleted_at = NOW(), deleted_by = $2, search_text = ''
+		WHERE id = $1 AND deleted_at IS NULL
+	`, messageID, deletedBy)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// RestoreMessage は deletedSince 以降に削除され、まだ本文が消去されていないメッセージを復元する。
+// 該当しなければ ErrNotFound。
+func (p *Postgres) RestoreMessage(messageID int, deletedSince time.Time) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var content string
+	err = tx.QueryRow(`
+		SELECT content FROM messages
+		WHERE id = $1 AND deleted_at IS NOT NULL AND deleted_at >= $2 AND purged_at IS NULL
+		FOR UPDATE
+	`, messageID, deletedSince).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE messages
+		SET deleted_at = NULL, deleted_by = NULL, search_text = $2
+		WHERE id = $1
+	`, messageID, search.Normalize(content)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeletedMessages は deletedBefore より前に削除されたメッセージの本文・編集履歴・リアクション・
+// 添付・メンションを消去し、消去した件数を返す。トゥームストーン自体は残す。
+func (p *Postgres) PurgeDeletedMessages(deletedBefore time.Time) (int, error) {
+	var count int
+	err := p.db.QueryRow(`
+		WITH purged AS (
+			UPDATE messages
+			SET content = '', search_text = '', purged_at = NOW()
+			WHERE deleted_at < $1 AND purged_at IS NULL
+			RETURNING id
+		), revisions AS (
+			DELETE FROM message_revisions WHERE message_id IN (SELECT id FROM purged)
+		), reactions AS (
+			UPDATE message_reads SET reaction = NULL
+			WHERE message_id IN (SELECT id FROM purged) AND reaction IS NOT NULL
+		), attachments AS (
+			DELETE FROM message_attachments WHERE message_id IN (SELECT id FROM purged)
+		), mentioned AS (
+			DELETE FROM mentions WHERE message_id IN (SELECT id FROM purged)
+		)
+		SELECT COUNT(*) FROM purged
+	`, deletedBefore).Scan(&count)
+	return count, err
+}
+
+// HardDeleteMessage はメッセージを削除する。スレッドの返信なら親の reply_count / last_reply_at も戻す。
+func (p *Postgres) HardDeleteMessage(messageID int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	err = tx.QueryRow("DELETE FROM messages WHERE id = $1 RETURNING parent_message_id", messageID).Scan(&parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if parentID.Valid {
+		if _, err := tx.Exec(`
+			UPDATE messages
+			SET reply_count = reply_count - 1,
+			    last_reply_at = (SELECT MAX(created_at) FROM messages WHERE parent_message_id = $1)
+			WHERE id = $1
+		`, parentID.Int64); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SearchMessages は userID が参加しているルームのメッセージを新しい順に検索する。
+// page.Before を渡すとそれより古い結果を返す。
+func (p *Postgres) SearchMessages(userID int, q search.Query, page models.PageQuery) ([]models.Message, bool, error) {
+	var where []string
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// search_grams の GIN インデックスで候補を絞ってから LIKE で確かめる（0002_message_search.up.sql）
+	for _, t := range q.Terms {
+		where = append(where, "search_grams(m.search_text) @> "+arg(pq.Array(search.Grams(t)))+"::text[]")
+		where = append(where, "m.search_text LIKE "+arg("%"+escapeLike(t)+"%"))
+	}
+	if q.FromUsername != "" {
+		where = append(where, "u.username = "+arg(q.FromUsername))
+	}
+	if q.RoomID != 0 {
+		where = append(where, "m.room_id = "+arg(q.RoomID))
+	}
+	if q.RoomName != "" {
+		where = append(where, "cr.room_name = "+arg(q.RoomName))
+	}
+	if q.HasAttachment {
+		where = append(where, "m.has_attachment")
+	}
+	if q.Since != nil {
+		where = append(where, "m.created_at >= "+arg(*q.Since))
+	}
+	if q.Until != nil {
+		where = append(where, "m.created_at < "+arg(*q.Until))
+	}
+	if page.Before != nil {
+		where = append(where, fmt.Sprintf("(m.created_at, m.id) < (%s, %s)", arg(page.Before.CreatedAt), arg(page.Before.ID)))
+	}
+	where = append(where, "m.deleted_at IS NULL")
+
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $1
+		JOIN users u ON u.id = m.sender_id
+		JOIN chat_rooms cr ON cr.id = m.room_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT ` + arg(page.Limit+1)
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+	return messages, hasMore, nil
+}
+
+// ---- スレッドのフォロー ----
+
+func (p *Postgres) FollowThread(rootID, userID int) error {
+	_, err := p.db.Exec(`
+		INSERT INTO thread_followers (message_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`, rootID, userID)
+	return err
+}
+
+func (p *Postgres) UnfollowThread(rootID, userID int) error {
+	_, err := p.db.Exec(`DELETE FROM thread_followers WHERE message_id = $1 AND user_id = $2`, rootID, userID)
+	return err
+}
+
+func (p *Postgres) IsFollowingThread(rootID, userID int) (bool, error) {
+	var exists bool
+	err := p.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM thread_followers WHERE message_id = $1 AND user_id = $2)`,
+		rootID, userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (p *Postgres) ListThreadFollowerIDs(rootID int) ([]int, error) {
+	rows, err := p.db.Query(`SELECT user_id FROM thread_followers WHERE message_id = $1 ORDER BY user_id`, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ---- 既読 ----
+
+func (p *Postgres) InsertUnreadReads(messageID int, userIDs []int) error {
+	for _, uid := range userIDs {
+		_, err := p.db.Exec(`
+			INSERT INTO message_reads (message_id, user_id, read_at)
+			VALUES ($1, $2, NULL)
+			ON CONFLICT (message_id, user_id) DO NOTHING
+		`, messageID, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkRoomRead はルームのタイムライン（スレッドの返信を除く）の未読を既読にする
+func (p *Postgres) MarkRoomRead(roomID, userID int) ([]models.ReadUpdate, error) {
+	return p.markRead(`m.room_id = $1 AND m.parent_message_id IS NULL`, roomID, userID)
+}
+
+// MarkThreadRead はスレッドの返信の未読を既読にする
+func (p *Postgres) MarkThreadRead(rootID, userID int) ([]models.ReadUpdate, error) {
+	return p.markRead(`m.parent_message_id = $1`, rootID, userID)
+}
+
+// markRead は where（$1 に key を使う）に一致する他人のメッセージの未読を既読にする
+func (p *Postgres) markRead(where string, key, userID int) ([]models.ReadUpdate, error) {
+	rows, err := p.db.Query(`
+		UPDATE message_reads mr
+		SET read_at = NOW()
+		FROM messages m
+		WHERE mr.message_id = m.id
+		  AND `+where+`
+		  AND m.sender_id != $2
+		  AND mr.user_id = $2
+		  AND mr.read_at IS NULL
+		RETURNING mr.message_id, m.sender_id, mr.read_at
+	`, key, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []models.ReadUpdate
+	for rows.Next() {
+		var r models.ReadUpdate
+		if err := rows.Scan(&r.ID, &r.SenderID, &r.ReadAt); err != nil {
+			return nil, err
+		}
+		updates = append(updates, r)
+	}
+	return updates, rows.Err()
+}
+
+// MarkMessageRead は未読なら既読にし、送信者と既読時刻を返す
+func (p *Postgres) MarkMessageRead(messageID, userID int) (*models.ReadReceipt, error) {
+	_, err := p.db.Exec(`
+			UPDATE message_reads
+			SET read_at = NOW()
+			WHERE message_id = $1 AND user_id = $2 AND read_at IS NULL
+		`, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := models.ReadReceipt{MessageID: messageID}
+	err = p.db.QueryRow(`
+			SELECT m.sender_id, mr.read_at
+			FROM messages m
+			JOIN message_reads mr ON m.id = mr.message_id
+			WHERE m.id = $1 AND mr.user_id = $2 AND mr.read_at IS NOT NULL
+		`, messageID, userID).Scan(&r.SenderID, &r.ReadAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (p *Postgres) UpsertMessageRead(messageID, userID int, readAt time.Time) error {
+	_, err := p.db.Exec(`
+		INSERT INTO message_reads (message_id, user_id, read_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id)
+		DO UPDATE SET read_at = EXCLUDED.read_at
+	`, messageID, userID, readAt)
+	return err
+}
+
+func (p *Postgres) ListMessageReads(messageIDs []int) ([]models.MessageRead, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := p.db.Query(`
+		SELECT message_id, user_id, reaction, read_at
+		FROM message_reads
+		WHERE message_id = ANY($1)
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reads []models.MessageRead
+	for rows.Next() {
+		var r models.MessageRead
+		var emoji sql.NullString
+		var readAt sql.NullTime
+		if err := rows.Scan(&r.MessageID, &r.UserID, &emoji, &readAt); err != nil {
+			log.Println("❌ message_reads Scan失敗:", err)
+			continue
+		}
+		if emoji.Valid {
+			r.Reaction = &emoji.String
+		}
+		if readAt.Valid {
+			r.ReadAt = &readAt.Time
+		}
+		reads = append(reads, r)
+	}
+	return reads, rows.Err()
+}
+
+// unreadThreadFilter は followedThreadsOnly（$2）のとき、フォローしていないスレッドの返信を除く条件
+const unreadThreadFilter = `(NOT $2 OR m.parent_message_id IS NULL OR EXISTS (
+		SELECT 1 FROM thread_followers tf WHERE tf.message_id = m.parent_message_id AND tf.user_id = mr.user_id
+	))`
+
+func (p *Postgres) CountUnread(userID, roomID int, followedThreadsOnly bool) (int, error) {
+	var count int
+	err := p.db.QueryRow(`
+		SELECT COUNT(*) FROM message_reads mr
+		JOIN messages m ON mr.message_id = m.id
+		WHERE mr.user_id = $1 AND mr.read_at IS NULL AND m.room_id = $3 AND m.deleted_at IS NULL
+		  AND `+unreadThreadFilter+`
+	`, userID, followedThreadsOnly, roomID).Scan(&count)
+	return count, err
+}
+
+func (p *Postgres) UnreadCounts(userID int, followedThreadsOnly bool) (map[int]int, error) {
+	rows, err := p.db.Query(`
+		SELECT m.room_id, COUNT(*) AS unread_count
+		FROM messages m
+		JOIN message_reads mr ON m.id = mr.message_id
+		WHERE mr.user_id = $1 AND mr.read_at IS NULL AND m.deleted_at IS NULL
+		  AND `+unreadThreadFilter+`
+		GROUP BY m.room_id
+	`, userID, followedThreadsOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]int)
+	for rows.Next() {
+		var roomID, count int
+		if err := rows.Scan(&roomID, &count); err == nil {
+			result[roomID] = count
+		}
+	}
+	return result, rows.Err()
+}
+
+func (p *Postgres) CountRoomReaders(roomID int) (int, error) {
+	var readCount int
+	err := p.db.QueryRow(`
+		SELECT COUNT(DISTINCT mr.user_id)
+		FROM message_reads mr
+		JOIN messages m ON mr.message_id = m.id
+		WHERE m.room_id = $1 AND mr.read_at IS NOT NULL
+	`, roomID).Scan(&readCount)
+	return readCount, err
+}
+
+// ---- リアクション ----
+
+func (p *Postgres) GetReaction(messageID, userID int) (*string, error) {
+	var current *string
+	err := p.db.QueryRow(`
+		SELECT reaction FROM message_reads
+		WHERE message_id = $1 AND user_id = $2
+	`, messageID, userID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return current, err
+}
+
+// SetReaction はリアクションを設定する（nil で取り消し）。行がなければ既読として作成する。
+func (p *Postgres) SetReaction(messageID, userID int, emoji *string) error {
+	_, err := p.db.Exec(`
+		INSERT INTO message_reads (message_id, user_id, reaction, read_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (message_id, user_id)
+		DO UPDATE SET reaction = EXCLUDED.reaction
+	`, messageID, userID, emoji)
+	return err
+}
+
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ---- イベントログ ----
+
+// AppendUserEvents は userIDs それぞれのログにイベントを追加し、振った seq を返す。
+// 連番の行をユーザーID順にロックするので、同時に追加しても seq は重複せずデッドロックもしない。
+func (p *Postgres) AppendUserEvents(userIDs []int, eventType string, payload []byte) (map[int]int64, error) {
+	seqs := make(map[int]int64, len(userIDs))
+	if len(userIDs) == 0 {
+		return seqs, nil
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		INSERT INTO user_event_seqs (user_id, last_seq)
+		SELECT u, 1 FROM unnest($1::int[]) AS u ORDER BY u
+		ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_seqs.last_seq + 1
+		RETURNING user_id, last_seq
+	`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(userIDs))
+	values := make([]int64, 0, len(userIDs))
+	for rows.Next() {
+		var uid int
+		var seq int64
+		if err := rows.Scan(&uid, &seq); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		seqs[uid] = seq
+		ids = append(ids, int64(uid))
+		values = append(values, seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_events (user_id, seq, type, payload)
+		SELECT unnest($1::int[]), unnest($2::bigint[]), $3, $4
+	`, pq.Array(ids), pq.Array(values), eventType, string(payload)); err != nil {
+		return nil, err
+	}
+	return seqs, tx.Commit()
+}
+
+// ListUserEvents は afterSeq より後のイベントを seq 順に最大 limit 件返す
+func (p *Postgres) ListUserEvents(userID int, afterSeq int64, limit int) ([]models.UserEvent, error) {
+	rows, err := p.db.Query(`
+		SELECT user_id, seq, type, payload, created_at
+		FROM user_events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var e models.UserEvent
+		var payload []byte
+		if err := rows.Scan(&e.UserID, &e.Seq, &e.Type, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (p *Postgres) UserEventRange(userID int) (int64, int64, error) {
+	var oldest, latest int64
+	err := p.db.QueryRow(`
+		SELECT
+			COALESCE((SELECT MIN(seq) FROM user_events WHERE user_id = $1), s.last_seq + 1),
+			s.last_seq
+		FROM (SELECT COALESCE((SELECT last_seq FROM user_event_seqs WHERE user_id = $1), 0) AS last_seq) s
+	`, userID).Scan(&oldest, &latest)
+	return oldest, latest, err
+}
+
+// PurgeUserEvents は createdBefore より前のイベントを削除し、削除した件数を返す
+func (p *Postgres) PurgeUserEvents(createdBefore time.Time) (int, error) {
+	res, err := p.db.Exec(`DELETE FROM user_events WHERE created_at < $1`, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ---- プレゼンス ----
+
+func (p *Postgres) SetPresence(userID int, replica, state string, lastSeen time.Time) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if state == models.PresenceOffline {
+		_, err = tx.Exec(`DELETE FROM user_presence WHERE user_id = $1 AND replica = $2`, userID, replica)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO user_presence (user_id, replica, state, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (user_id, replica) DO UPDATE SET state = EXCLUDED.state, updated_at = NOW()
+		`, userID, replica, state)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE users SET last_seen_at = $2
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < $2)
+	`, userID, lastSeen); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) RefreshPresence(replica string, staleBefore time.Time) error {
+	if _, err := p.db.Exec(`UPDATE user_presence SET updated_at = NOW() WHERE replica = $1`, replica); err != nil {
+		return err
+	}
+	_, err := p.db.Exec(`DELETE FROM user_presence WHERE updated_at < $1`, staleBefore)
+	return err
+}
+
+func (p *Postgres) GetPresences(userIDs []int, freshSince time.Time) (map[int]models.Presence, error) {
+	rows, err := p.db.Query(`
+		SELECT u.id, u.last_seen_at, u.hide_last_seen,
+			COALESCE((
+				SELECT CASE WHEN bool_or(pr.state = 'online') THEN 'online' ELSE 'idle' END
+				FROM user_presence pr
+				WHERE pr.user_id = u.id AND pr.updated_at >= $2
+				HAVING COUNT(*) > 0
+			), 'offline')
+		FROM users u
+		WHERE u.id = ANY($1)
+	`, pq.Array(userIDs), freshSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]models.Presence, len(userIDs))
+	for rows.Next() {
+		var id int
+		var pr models.Presence
+		if err := rows.Scan(&id, &pr.LastSeenAt, &pr.HideLastSeen, &pr.State); err != nil {
+			return nil, err
+		}
+		out[id] = pr
+	}
+	return out, rows.Err()
+}
+
+func (p *Postgres) SetHideLastSeen(userID int, hide bool) error {
+	res, err := p.db.Exec(`UPDATE users SET hide_last_seen = $2 WHERE id = $1`, userID, hide)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) ListRoomPeerIDs(userID int) ([]int, error) {
+	rows, err := p.db.Query(`
+		SELECT DISTINCT other.user_id
+		FROM room_members me
+		JOIN room_members other ON other.room_id = me.room_id
+		WHERE me.user_id = $1 AND other.user_id != $1
+		ORDER BY other.user_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func reverseMessages(messages []models.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// escapeLike は LIKE のワイルドカード文字をエスケープする
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
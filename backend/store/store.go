@@ -0,0 +1,89 @@
+package store
+
+import (
+	"backend/models"
+	"backend/search"
+	"errors"
+	"time"
+)
+
+// ErrNotFound は対象の行が存在しない（または操作権限がない）ことを表す
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateRequest は同じ送信者・同じ request_id のメッセージが既にあることを表す。
+// CreateMessage はこのとき既存のメッセージを msg に入れて返す。
+var ErrDuplicateRequest = errors.New("duplicate request")
+
+// Store はハンドラーが使う永続化操作をまとめたインターフェース。
+// PostgreSQL 実装 (NewPostgres) とメモリ実装 (NewMemory) がある。
+type Store interface {
+	// ユーザー
+	CreateUser(username, passwordHash string) (int, error)
+	GetUserByID(userID int) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	ListUsersExcept(userID int) ([]models.User, error)
+
+	// ルーム
+	GetOrCreateDirectRoom(user1ID, user2ID int) (roomID int, created bool, err error)
+	CreateRoom(name string, isGroup bool, memberIDs []int) (int, error)
+	ListUserRooms(userID int, groupsOnly bool) ([]models.ChatRoom, error)
+
+	// ルームメンバー
+	GetRoomMembers(roomID int) ([]models.User, error)
+	ListRoomMemberIDs(roomID int) ([]int, error)
+	IsRoomMember(roomID, userID int) (bool, error)
+
+	// メッセージ
+	CreateMessage(msg *models.Message) error // ClientRequestID が重複なら ErrDuplicateRequest
+	GetMessage(messageID int) (*models.Message, error)
+	ListMessagesByIDs(messageIDs []int) ([]models.Message, error)
+	GetSenderIDByMessageID(messageID int) (int, error)
+	IsMessageQuoted(messageID int) (bool, error) // messageID を引用しているメッセージがあるか
+	ListMessagesPage(roomID int, q models.PageQuery) (messages []models.Message, hasMore bool, err error)
+	ListThreadPage(rootID int, q models.PageQuery) (messages []models.Message, hasMore bool, err error)
+	UpdateMessageContent(messageID, senderID int, content string) error
+	ListMessageRevisions(messageID int) ([]models.MessageRevision, error)
+	SoftDeleteMessage(messageID, deletedBy int) error
+	RestoreMessage(messageID int, deletedSince time.Time) error
+	PurgeDeletedMessages(deletedBefore time.Time) (int, error)
+	HardDeleteMessage(messageID int) error
+	SearchMessages(userID int, q search.Query, page models.PageQuery) (messages []models.Message, hasMore bool, err error)
+
+	// スレッドのフォロー
+	FollowThread(rootID, userID int) error
+	UnfollowThread(rootID, userID int) error
+	IsFollowingThread(rootID, userID int) (bool, error)
+	ListThreadFollowerIDs(rootID int) ([]int, error)
+
+	// 既読
+	InsertUnreadReads(messageID int, userIDs []int) error
+	MarkRoomRead(roomID, userID int) ([]models.ReadUpdate, error)
+	MarkThreadRead(rootID, userID int) ([]models.ReadUpdate, error)
+	MarkMessageRead(messageID, userID int) (*models.ReadReceipt, error)
+	UpsertMessageRead(messageID, userID int, readAt time.Time) error
+	ListMessageReads(messageIDs []int) ([]models.MessageRead, error)
+	// followedThreadsOnly なら、フォローしていないスレッドの返信を未読数に数えない
+	CountUnread(userID, roomID int, followedThreadsOnly bool) (int, error)
+	UnreadCounts(userID int, followedThreadsOnly bool) (map[int]int, error)
+	CountRoomReaders(roomID int) (int, error)
+
+	// リアクション（message_reads.reaction に保存）
+	GetReaction(messageID, userID int) (*string, error)
+	SetReaction(messageID, userID int, emoji *string) error
+
+	// WebSocketイベントログ（ユーザーごとの連番）
+	AppendUserEvents(userIDs []int, eventType string, payload []byte) (map[int]int64, error)
+	ListUserEvents(userID int, afterSeq int64, limit int) ([]models.UserEvent, error)
+	UserEventRange(userID int) (oldest, latest int64, err error) // 保持中の最古の seq と最新の seq（なければ latest+1, latest）
+	PurgeUserEvents(createdBefore time.Time) (int, error)
+
+	// プレゼンス（レプリカごとの接続状態と最後に操作した時刻）
+	// SetPresence は replica での userID の状態を記録する（offline なら replica の行を消す）。last_seen_at は新しいときだけ進める
+	SetPresence(userID int, replica, state string, lastSeen time.Time) error
+	// RefreshPresence は replica の行を更新済みにし、staleBefore より前から更新されていない行（落ちたレプリカの分）を消す
+	RefreshPresence(replica string, staleBefore time.Time) error
+	// GetPresences は userIDs の状態を返す。freshSince より前から更新されていない行は数えない
+	GetPresences(userIDs []int, freshSince time.Time) (map[int]models.Presence, error)
+	SetHideLastSeen(userID int, hide bool) error
+	ListRoomPeerIDs(userID int) ([]int, error) // userID と同じルームにいるユーザー（本人を除く）
+}
@@ -16,7 +16,7 @@ func GenerateJWT(userID int) (string, error) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	tokenString, err := token.SignedString(middleware.SecretKey)
+	tokenString, err := token.SignedString(middleware.SecretKey())
 	if err != nil {
 		return "", err
 	}
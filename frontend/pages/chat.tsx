@@ -3,12 +3,21 @@ import { useEffect, useState, useRef } from "react";
 import { useRouter } from "next/navigation";
 import EmojiStampPicker from "../components/EmojiStampPicker";
 
-type User = { id: number; username: string };
+// WebSocket プロトコルのバージョン（送信するフレームには必ず v と request_id を付ける）
+const PROTOCOL_VERSION = 1;
+
+type Presence = { state: "online" | "idle" | "offline"; last_seen_at?: string | null };
+type User = { id: number; username: string; presence?: Presence };
+type QuotePreview = { id: number; sender_id: number; content: string; deleted: boolean };
 type Message = {
   id: number;
   room_id: number;
   sender_id: number;
   content: string;
+  edited_at?: string | null;
+  deleted?: boolean;
+  reply_count?: number;
+  reply_to?: QuotePreview | null;
   read_at?: string | null;
   reactions?: { user_id: number; emoji: string }[];
 };
@@ -42,6 +51,11 @@ export default function ChatPage() {
 
   const [mentionOpen, setMentionOpen] = useState(false);
 
+  // 入力中のメンバー（多いときは人数だけ届く）
+  const [typingInfo, setTypingInfo] = useState<{ userIds: number[]; count: number }>({ userIds: [], count: 0 });
+  const lastTypingSentRef = useRef(0);
+  const [hideLastSeen, setHideLastSeen] = useState(false);
+
 
   const markAllAsRead = async (roomId: number) => {
     await fetch("http://localhost:8080/messages/read", {
@@ -118,9 +132,10 @@ export default function ChatPage() {
 const lastMessage = messages.slice().reverse().find(m => m.sender_id !== userId);
 if (lastMessage) {
   socket.send(JSON.stringify({
+    v: PROTOCOL_VERSION,
+    request_id: crypto.randomUUID(),
     type: "read",
     message_id: lastMessage.id,
-    read_at: new Date().toISOString(),
   }));
 }
 
@@ -154,14 +169,23 @@ if (lastMessage) {
 
   useEffect(() => {
   if (!userId || !roomId) return;
-  const ws = new WebSocket("ws://localhost:8080/ws");
+  // 最後に受け取ったイベントの seq を渡し、切断中に取りこぼしたイベントを再送してもらう
+  const seqKey = `lastSeq_user${userId}`;
+  const lastSeq = localStorage.getItem(seqKey);
+  // batch=1: 続けて届いたイベントを {"type": "batch", "events": [...]} にまとめて受け取る
+  const params = new URLSearchParams({ batch: "1" });
+  if (lastSeq) params.set("last_seq", lastSeq);
+  const ws = new WebSocket(`ws://localhost:8080/ws?${params}`);
   ws.onopen = async () => {
     setSocket(ws);
+    setTypingInfo({ userIds: [], count: 0 });
     await markAllAsRead(roomId);
   };
 
-    ws.onmessage = async (event) => {
-  const data = JSON.parse(event.data);
+  const handleEvent = async (data: any) => {
+  if (typeof data.seq === "number" && data.seq > Number(localStorage.getItem(seqKey) ?? 0)) {
+    localStorage.setItem(seqKey, String(data.seq));
+  }
   
   if (data.type === "message") {
   const msgRoomId = Number(data.room_id);
@@ -183,12 +207,13 @@ if (lastMessage) {
       .then(data => setUnreadCounts(data));
 
 } else if (data.type === "read") {
-  const id = Number(data.message_id);
-  if (!isNaN(id)) {
+  // 同時に既読になったメッセージは message_ids にまとめて届く
+  const ids = new Set<number>((data.message_ids ?? []).map(Number));
+  if (ids.size > 0) {
     setMessages((prev) =>
       prev.map((m) => {
         // すでに read_at が存在していればそのまま（上書きしない）
-        if (m.id === id && !m.read_at) {
+        if (ids.has(m.id) && !m.read_at) {
           return { ...m, read_at: data.read_at };
         }
         return m;
@@ -217,7 +242,7 @@ if (lastMessage) {
     const id = Number(data.message_id);
     if (!isNaN(id)) {
       setMessages((prev) =>
-        prev.map((m) => (m.id === id ? { ...m, content: data.content } : m))
+        prev.map((m) => (m.id === id ? { ...m, content: data.content, edited_at: data.edited_at } : m))
       );
     }
   } else if (data.type === "delete") {
@@ -225,10 +250,46 @@ if (lastMessage) {
     if (!isNaN(id)) {
       setMessages((prev) =>
         prev.map((m) =>
-          m.id === id ? { ...m, content: "このメッセージは削除されました" } : m
+          m.id === id ? { ...m, content: "", deleted: true } : m
+        )
+      );
+    }
+  } else if (data.type === "quote_update") {
+    const id = Number(data.message_id);
+    if (!isNaN(id)) {
+      setMessages((prev) =>
+        prev.map((m) =>
+          m.reply_to?.id === id ? { ...m, reply_to: data.preview } : m
+        )
+      );
+    }
+  } else if (data.type === "thread_update") {
+    const rootId = Number(data.root_id);
+    if (!isNaN(rootId)) {
+      setMessages((prev) =>
+        prev.map((m) =>
+          m.id === rootId ? { ...m, reply_count: data.reply_count } : m
+        )
+      );
+    }
+  } else if (data.type === "restore") {
+    const id = Number(data.message_id);
+    if (!isNaN(id)) {
+      setMessages((prev) =>
+        prev.map((m) =>
+          m.id === id ? { ...m, content: data.content, deleted: false } : m
         )
       );
     }
+  } else if (data.type === "resync") {
+    // 取りこぼしがあったのでメッセージと未読数を取り直す
+    const res = await fetch(`http://localhost:8080/messages?room_id=${roomId}`, { credentials: "include" });
+    const msgs = await res.json();
+    setMessages(Array.isArray(msgs) ? msgs : msgs.messages || []);
+
+    fetch("http://localhost:8080/unread_counts", { credentials: "include" })
+      .then(res => res.json())
+      .then(data => setUnreadCounts(data));
       // これ ↓ に置き換えてください
 } else if (data.type === "unread") {
   const roomId = Number(data.room_id);
@@ -246,11 +307,50 @@ if (lastMessage) {
         room_id: data.room_id,
         message: data.message,
       }]);
+    } else if (data.type === "presence") {
+      // 同じルームにいるユーザーのオンライン状態が変わった
+      const presence: Presence = { state: data.state, last_seen_at: data.last_seen_at ?? null };
+      const apply = (list: User[]) => list.map(u => (u.id === data.user_id ? { ...u, presence } : u));
+      setUsers(apply);
+      setRoomMembers(apply);
+    } else if (data.type === "typing") {
+      if (Number(data.room_id) !== roomId) return;
+      setTypingInfo({ userIds: data.user_ids ?? [], count: Number(data.count) || 0 });
+    } else if (data.type === "ready") {
+      // 再送が終わった（resync が先に届いていれば一覧は取り直し済み）
+      localStorage.setItem(seqKey, String(data.last_seq));
+    } else if (data.type === "ack") {
+      // 送信したフレームが処理された（message なら data.id が保存されたメッセージID）
+      console.debug(`ack: ${data.request_type} ${data.request_id}`, data.id ?? "");
+    } else if (data.type === "error") {
+      console.warn(`WebSocketエラー (${data.request_type ?? "-"}): ${data.code} ${data.message}`);
+    }
+  };
+
+  ws.onmessage = async (event) => {
+    const frame = JSON.parse(event.data);
+    for (const data of frame.type === "batch" ? frame.events : [frame]) {
+      await handleEvent(data);
     }
   };
+  // タブを隠したら離席（idle）、戻ったらオンラインをサーバーに知らせる
+  const onVisibilityChange = () => {
+    if (ws.readyState !== WebSocket.OPEN) return;
+    ws.send(JSON.stringify({
+      v: PROTOCOL_VERSION,
+      request_id: crypto.randomUUID(),
+      type: "presence",
+      state: document.visibilityState === "hidden" ? "idle" : "online",
+    }));
+  };
+  document.addEventListener("visibilitychange", onVisibilityChange);
+
   ws.onclose = () => console.warn("WebSocket closed");
   ws.onerror = (err) => console.error("WebSocket error", err);
-  return () => ws.close();
+  return () => {
+    document.removeEventListener("visibilitychange", onVisibilityChange);
+    ws.close();
+  };
 }, [userId, roomId]);
 
   useEffect(() => {
@@ -318,26 +418,66 @@ if (lastMessage) {
     }
   };
   
-  const handleHardDeleteMessage = async (id: number) => {
-  const res = await fetch(`http://localhost:8080/messages/hard_delete?id=${id}`, {
-    method: "DELETE",
-    credentials: "include"
-  });
-  if (res.ok) {
-    setMessages(prev => prev.filter(m => m.id !== id));
-  }
-};
-
   const handleDeleteMessage = async (id: number) => {
     const res = await fetch(`http://localhost:8080/messages/delete?id=${id}`, {
       method: "DELETE",
       credentials: "include"
     });
     if (res.ok) {
-      setMessages(prev => prev.map(m => m.id === id ? { ...m, content: "このメッセージは削除されました" } : m));
+      setMessages(prev => prev.map(m => m.id === id ? { ...m, content: "", deleted: true } : m));
     }
   };
 
+// 入力中はサーバーが数秒で消すので、3秒ごとに送り直す
+const TYPING_REFRESH_MS = 3000;
+
+const sendTyping = (start: boolean) => {
+  if (!socket || socket.readyState !== WebSocket.OPEN || roomId == null) return;
+  socket.send(JSON.stringify({
+    v: PROTOCOL_VERSION,
+    request_id: crypto.randomUUID(),
+    type: start ? "typing_start" : "typing_stop",
+    room_id: roomId,
+  }));
+};
+
+const handleMessageTextChange = (text: string) => {
+  setMessageText(text);
+  const now = Date.now();
+  if (text.trim() === "") {
+    if (lastTypingSentRef.current) sendTyping(false);
+    lastTypingSentRef.current = 0;
+  } else if (now - lastTypingSentRef.current >= TYPING_REFRESH_MS) {
+    sendTyping(true);
+    lastTypingSentRef.current = now;
+  }
+};
+
+const typingLabel = () => {
+  if (typingInfo.count === 0) return "";
+  if (typingInfo.userIds.length === 0) return `${typingInfo.count}人が入力中…`;
+  const names = typingInfo.userIds.map(id => users.find(u => u.id === id)?.username ?? `ユーザー${id}`);
+  return `${names.join("、")} が入力中…`;
+};
+
+const presenceLabel = (p?: Presence) => {
+  if (!p) return "";
+  if (p.state === "online") return "🟢";
+  if (p.state === "idle") return "🟡";
+  return p.last_seen_at ? `⚪ ${new Date(p.last_seen_at).toLocaleString()}` : "⚪";
+};
+
+// 最終ログインの時刻を他のユーザーに見せない
+const toggleHideLastSeen = async (hide: boolean) => {
+  const res = await fetch("http://localhost:8080/me/presence", {
+    method: "PUT",
+    credentials: "include",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ hide_last_seen: hide }),
+  });
+  if (res.ok) setHideLastSeen(hide);
+};
+
 const handleSendMessage = async () => {
   if (!messageText.trim() || userId == null || roomId == null || !socket) return;
 
@@ -345,6 +485,8 @@ const handleSendMessage = async () => {
 
   // 通常メッセージ送信
   const msg = {
+    v: PROTOCOL_VERSION,
+    request_id: crypto.randomUUID(),
     type: "message",
     sender_id: userId,
     receiver_id: selectedUser?.id,
@@ -353,24 +495,10 @@ const handleSendMessage = async () => {
   };
   socket.send(JSON.stringify(msg));
 
-  // @メンション検出（例: @taro）
-  const mentionRegex = /@([\w\u3040-\u309F\u30A0-\u30FF\u4E00-\u9FFF]+)/g;
-  const mentionedUsernames = [...content.matchAll(mentionRegex)].map(match => match[1]);
-
-  mentionedUsernames.forEach(username => {
-    const targetUser = users.find(u => u.username === username);
-    if (targetUser) {
-      const mention = {
-        type: "mention",
-        from: userId,
-        to: targetUser.id,
-        room_id: roomId,
-        message: content,
-      };
-      socket.send(JSON.stringify(mention));
-    }
-  });
+  // @メンションの通知はサーバーが本文から送る
 
+  // 入力中はサーバーが送信時に取り消す
+  lastTypingSentRef.current = 0;
   setMessageText("");
 };
 
@@ -383,6 +511,8 @@ const handleSendMessage = async () => {
     const res = await fetch("http://localhost:8080/upload", { method: "POST", body: formData });
     const { url } = await res.json();
     const msg = {
+      v: PROTOCOL_VERSION,
+      request_id: crypto.randomUUID(),
       type: "message",
       sender_id: userId,
       receiver_id: selectedUser?.id,
@@ -479,8 +609,15 @@ const handleSendMessage = async () => {
       whiteSpace: "pre-wrap",
     }}
   >
+    {msg.reply_to && (
+      <div style={{ borderLeft: "3px solid #bbb", paddingLeft: "0.5rem", marginBottom: "0.25rem", fontSize: "0.8em", color: "#666" }}>
+        {msg.reply_to.deleted ? "このメッセージは削除されました" : msg.reply_to.content}
+      </div>
+    )}
     {/* 👇 ここで msg.content のみ表示するよう変更 */}
-    {msg.content}
+    {msg.deleted ? "このメッセージは削除されました" : msg.content}
+    {!msg.deleted && msg.edited_at && <span style={{ fontSize: "0.7em", color: "#888" }}> (編集済み)</span>}
+    {!!msg.reply_count && <div style={{ fontSize: "0.75em", color: "#2a6ebb" }}>💬 {msg.reply_count}件の返信</div>}
   </div>
 )}
 
@@ -557,22 +694,6 @@ const handleSendMessage = async () => {
     >
       送信取消
     </button>
-    <button
-      onClick={(e) => {
-        e.stopPropagation();
-        handleHardDeleteMessage(msg.id);
-        setMenuOpenMessageId(null);
-      }}
-      style={{
-        background: "#faa",
-        border: "none",
-        padding: "0.3rem 0.5rem",
-        borderRadius: "4px",
-        cursor: "pointer",
-      }}
-    >
-      削除
-    </button>
   </div>
 )}
 
@@ -665,6 +786,9 @@ const handleSendMessage = async () => {
 ))}
 
         <h3 style={{ marginTop: "1rem" }}>ユーザー一覧</h3>
+        <label style={{ fontSize: "0.8rem", display: "block", marginBottom: "0.5rem" }}>
+          <input type="checkbox" checked={hideLastSeen} onChange={e => toggleHideLastSeen(e.target.checked)} /> 最終ログインを隠す
+        </label>
         
         {users.map(user => {
 
@@ -683,7 +807,7 @@ const handleSendMessage = async () => {
       }}
       onClick={() => handleUserClick(user)}>
       
-      <span>{user.username}</span>
+      <span>{user.username} <small style={{ color: "#888" }}>{presenceLabel(user.presence)}</small></span>
 
       {unread > 0 && (
         <span
@@ -786,7 +910,7 @@ const handleSendMessage = async () => {
                   <strong style={{ marginRight: "0.5rem" }}>メンバー一覧：</strong>
                   <div style={{ display: "flex", flexWrap: "wrap", gap: "0.4rem" }}>
                     {roomMembers.map(member => (
-                      <span key={member.id} style={{ background: "#eee", padding: "0.3rem 0.6rem", borderRadius: "1rem" }}>{member.username}</span>
+                      <span key={member.id} style={{ background: "#eee", padding: "0.3rem 0.6rem", borderRadius: "1rem" }}>{member.username} {presenceLabel(member.presence)}</span>
                     ))}
                   </div>
                 </div>
@@ -801,6 +925,8 @@ const handleSendMessage = async () => {
                 onStampSelect={(url) => {
                   if (!socket || !userId || !roomId) return;
                   const msg = {
+                    v: PROTOCOL_VERSION,
+                    request_id: crypto.randomUUID(),
                     type: "message",
                     sender_id: userId,
                     receiver_id: selectedUser?.id,
@@ -810,7 +936,8 @@ const handleSendMessage = async () => {
                   socket.send(JSON.stringify(msg));
                 }}
               />
-              <input type="text" value={messageText} onChange={e => setMessageText(e.target.value)} style={{ width: "80%" }} placeholder="メッセージを入力" />
+              <div style={{ minHeight: "1.2rem", fontSize: "0.8rem", color: "#888" }}>{typingLabel()}</div>
+              <input type="text" value={messageText} onChange={e => handleMessageTextChange(e.target.value)} style={{ width: "80%" }} placeholder="メッセージを入力" />
               <button onClick={handleSendMessage}>送信</button>
             </>
           ) : (